@@ -15,16 +15,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/ipuplugin"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/p4rtclient"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	ut "github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	"github.com/ipdk-io/k8s-infra-offload/pkg/utils"
@@ -49,11 +54,15 @@ const (
 	defaulP4Pkg         = "redhat"
 	defaultP4rtBin      = "/opt/p4/p4-cp-nws/bin/p4rt-ctl"
 	defaultOvsCliDir    = "/opt/p4/p4-cp-nws"
+	defaultOvsdbSock    = "/opt/p4/p4-cp-nws/var/run/openvswitch/db.sock"
 	defaultPortMuxVsi   = 0x0a //this is just a place-holder, since VSI can change.
 	defaultP4BridgeName = "br0"
 	defaultDaemonHostIp = "192.168.1.1"
 	defaultDaemonIpuIp  = "192.168.1.2"
 	defaultDaemonPort   = 50151
+	defaultVfWatchIntv  = 30 * time.Second
+	defaultVfCreateTmo  = 10 * time.Second
+	defaultStateFile    = "/var/lib/ipuplugin/bridgeports.json"
 )
 
 var (
@@ -66,7 +75,11 @@ var (
 		interfaceName string
 		logDir        string
 		ovsCliDir     string
+		ovsdbSock     string
 		bridgeType    string
+		stag          int
+		vlanRanges    []string
+		reservedVlans []string
 		p4pkg         string
 		p4rtbin       string
 		portMuxVsi    int
@@ -75,6 +88,19 @@ var (
 		daemonHostIp  string
 		daemonIpuIp   string
 		daemonPort    int
+		vfWatchIntv   time.Duration
+		excludeIntfs  []string
+		vfCreateTmo   time.Duration
+		tlsCertFile   string
+		tlsKeyFile    string
+		tlsClientCA   string
+		allowInsecure bool
+		reflection    bool
+		metricsAddr   string
+		otlpEndpoint  string
+		shutdownPol   string
+		shutdownTmo   time.Duration
+		stateFile     string
 	}
 
 	rootCmd = &cobra.Command{
@@ -95,7 +121,13 @@ var (
 			bridge := viper.GetString("bridge")
 			intf := viper.GetString("interface")
 			ovsCliDir := viper.GetString("ovsCliDir")
+			ovsdbSock := viper.GetString("ovsdbSock")
 			bridgeType := viper.GetString("bridgeType")
+			stag := viper.GetInt("stag")
+			vlans := ipuplugin.VlanConfig{
+				Ranges:   viper.GetStringSlice("vlanRanges"),
+				Reserved: viper.GetStringSlice("reservedVlans"),
+			}
 			p4pkg := viper.GetString("p4pkg")
 			p4rtbin := viper.GetString("p4rtbin")
 			portMuxVsi := viper.GetInt("portMuxVsi")
@@ -103,6 +135,38 @@ var (
 			daemonHostIp := viper.GetString("daemonHostIp")
 			daemonIpuIp := viper.GetString("daemonIpuIp")
 			daemonPort := viper.GetInt("daemonPort")
+			vfWatchInterval := viper.GetDuration("vfWatchInterval")
+			excludeIntfs := viper.GetStringSlice("excludeInterfaces")
+			vfCreateTimeout := viper.GetDuration("vfCreateTimeout")
+			tlsConfig := ipuplugin.TLSConfig{
+				CertFile:     viper.GetString("tlsCertFile"),
+				KeyFile:      viper.GetString("tlsKeyFile"),
+				ClientCAFile: viper.GetString("tlsClientCAFile"),
+			}
+			allowInsecureTcp := viper.GetBool("allowInsecureTcp")
+			enableReflection := viper.GetBool("enableReflection")
+			metricsAddr := viper.GetString("metricsAddr")
+			otlpEndpoint := viper.GetString("otlpEndpoint")
+			shutdown := getShutdownConfig()
+			var listeners []ipuplugin.ListenerConfig
+			if err := viper.UnmarshalKey("listeners", &listeners); err != nil {
+				exitWithError(fmt.Errorf("invalid listeners configuration: %w", err), 2)
+			}
+			if len(listeners) == 0 {
+				// No listener list in the config file, serve on the address given by the flags
+				listeners = []ipuplugin.ListenerConfig{{
+					Name:             "default",
+					Proto:            servingProto,
+					Addr:             servingAddr,
+					Port:             port,
+					TLS:              tlsConfig,
+					AllowInsecureTCP: allowInsecureTcp,
+				}}
+			}
+			var resourcePools []ipuplugin.ResourcePool
+			if err := viper.UnmarshalKey("resourcePools", &resourcePools); err != nil {
+				exitWithError(fmt.Errorf("invalid resourcePools configuration: %w", err), 2)
+			}
 
 			log.Info("Initializing IPU plugin")
 			if mode == types.IpuMode {
@@ -116,33 +180,114 @@ var (
 				}
 			}
 			log.WithFields(log.Fields{
-				"servingAddr":  servingAddr,
-				"servingProto": servingProto,
-				"servingPort":  port,
-				"bridge":       bridge,
-				"interface":    intf,
-				"ovsCliDir":    ovsCliDir,
-				"bridgeType":   bridgeType,
-				"p4pkg":        p4pkg,
-				"p4rtbin":      p4rtbin,
-				"portMuxVsi":   portMuxVsi,
-				"mode":         mode,
-				"daemonHostIp": daemonHostIp,
-				"daemonIpuIp":  daemonIpuIp,
-				"daemonPort":   daemonPort,
+				"servingAddr":       servingAddr,
+				"servingProto":      servingProto,
+				"servingPort":       port,
+				"bridge":            bridge,
+				"interface":         intf,
+				"ovsCliDir":         ovsCliDir,
+				"ovsdbSock":         ovsdbSock,
+				"bridgeType":        bridgeType,
+				"stag":              stag,
+				"vlanRanges":        vlans.Ranges,
+				"reservedVlans":     vlans.Reserved,
+				"p4pkg":             p4pkg,
+				"p4rtbin":           p4rtbin,
+				"portMuxVsi":        portMuxVsi,
+				"mode":              mode,
+				"daemonHostIp":      daemonHostIp,
+				"daemonIpuIp":       daemonIpuIp,
+				"daemonPort":        daemonPort,
+				"vfWatchInterval":   vfWatchInterval,
+				"excludeInterfaces": excludeIntfs,
+				"resourcePools":     resourcePools,
+				"vfCreateTimeout":   vfCreateTimeout,
+				"tlsCertFile":       tlsConfig.CertFile,
+				"tlsKeyFile":        tlsConfig.KeyFile,
+				"tlsClientCAFile":   tlsConfig.ClientCAFile,
+				"allowInsecureTcp":  allowInsecureTcp,
+				"listeners":         listeners,
+				"enableReflection":  enableReflection,
+				"metricsAddr":       metricsAddr,
+				"otlpEndpoint":      otlpEndpoint,
+				"shutdownPolicy":    shutdown.Policy,
+				"shutdownTimeout":   shutdown.Timeout,
+				"stateFile":         shutdown.StateFile,
 			}).Info("Configurations")
 
-			brCtlr, brType := getBridgeController(bridge, bridgeType, ovsCliDir)
+			shutdownTracing := func() {}
+			if otlpEndpoint != "" {
+				var err error
+				if shutdownTracing, err = tracing.SetupOTLP(otlpEndpoint, cliName); err != nil {
+					exitWithError(err, 2)
+				}
+				defer shutdownTracing()
+			}
+			// os.Exit skips the deferred calls, flush the pending spans first
+			exit := func(err error, exitCode int) {
+				shutdownTracing()
+				exitWithError(err, exitCode)
+			}
+
+			brCtlr, brType := getBridgeController(bridge, bridgeType, ovsCliDir, ovsdbSock)
 			p4Client := getP4Client(p4pkg, p4rtbin, portMuxVsi, defaultP4BridgeName, brType)
 
-			mgr := ipuplugin.NewIpuPlugin(port, brCtlr, p4rtbin, p4Client, servingAddr, servingProto, bridge, intf, ovsCliDir, mode, daemonHostIp, daemonIpuIp, daemonPort)
-			if err := mgr.Run(); err != nil {
-				exitWithError(err, 4)
+			mgr, err := ipuplugin.NewIpuPlugin(brCtlr, p4Client, ipuplugin.Config{
+				Bridge:            bridge,
+				BridgeType:        brType,
+				Interface:         intf,
+				P4rtBin:           p4rtbin,
+				P4cpInstall:       ovsCliDir,
+				Mode:              mode,
+				DaemonHostIp:      daemonHostIp,
+				DaemonIpuIp:       daemonIpuIp,
+				DaemonPort:        daemonPort,
+				Stag:              stag,
+				Vlans:             vlans,
+				VfWatchInterval:   vfWatchInterval,
+				ExcludeInterfaces: excludeIntfs,
+				ResourcePools:     resourcePools,
+				VfCreateTimeout:   vfCreateTimeout,
+				Listeners:         listeners,
+				EnableReflection:  enableReflection,
+				MetricsAddr:       metricsAddr,
+				Shutdown:          shutdown,
+				ReloadFn:          reloadConfig,
+			})
+			if err != nil {
+				exit(err, 2)
+			}
+			// SIGINT and SIGTERM stop the plugin, SIGHUP reloads its configuration
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			if reloader, ok := mgr.(types.Reloader); ok {
+				go reloadOnSighup(ctx, reloader)
+			}
+			if err := mgr.Run(ctx); err != nil {
+				exit(err, 4)
 			}
 		},
 	}
 )
 
+// reloadOnSighup reloads the configuration of r on each SIGHUP until ctx is done
+func reloadOnSighup(ctx context.Context, r types.Reloader) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			log.Info("SIGHUP received, reloading configuration")
+			if err := r.Reload(); err != nil {
+				log.Errorf("configuration not reloaded: %v", err)
+			}
+		}
+	}
+}
+
 func findVsiForPfInterface(mode string, intfName string) (int, error) {
 
 	var pfList []netlink.Link
@@ -198,7 +343,15 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.interfaceName, "interface", defaultBridgeIntf, "The uplink network interface name")
 	rootCmd.PersistentFlags().StringVar(&config.bridge, "bridge", tenantBridgeName, "The bridge name that IPU plugin will manage")
 	rootCmd.PersistentFlags().StringVar(&config.ovsCliDir, "ovsCliDir", defaultOvsCliDir, "The directory where the ovs-vsctl is located")
-	rootCmd.PersistentFlags().StringVar(&config.bridgeType, "bridgeType", defaultBridge, "The bridge type that IPU plugin will manage")
+	rootCmd.PersistentFlags().StringVar(&config.ovsdbSock, "ovsdbSock", defaultOvsdbSock, "The OVSDB server socket used by the 'ovsdb' bridge type")
+	rootCmd.PersistentFlags().StringVar(&config.bridgeType, "bridgeType", defaultBridge,
+		"The bridge type that IPU plugin will manage: 'linux|linux-vlan|ovs|ovsdb'")
+	rootCmd.PersistentFlags().IntVar(&config.stag, "stag", 0,
+		"The S-tag of the outer 802.1ad vlan of the BridgePorts which do not set one with a 'stag=<id>' logical bridge")
+	rootCmd.PersistentFlags().StringSliceVar(&config.vlanRanges, "vlanRanges", nil,
+		"The vlan ranges the BridgePorts may use, e.g.; 100-199, from which the vlan of a BridgePort created without one is allocated (default 2-4094)")
+	rootCmd.PersistentFlags().StringSliceVar(&config.reservedVlans, "reservedVlans", nil,
+		"The vlan ranges used by the infrastructure, which no BridgePort or LogicalBridge may use")
 	rootCmd.PersistentFlags().StringVar(&config.p4pkg, "p4pkg", defaulP4Pkg, "The P4 package plugin is running with")
 	rootCmd.PersistentFlags().StringVar(&config.p4rtbin, "p4rtbin", defaultP4rtBin, "The directory where the p4rt-ctl binary is located")
 	rootCmd.PersistentFlags().IntVar(&config.portMuxVsi, "portMuxVsi", defaultPortMuxVsi,
@@ -208,6 +361,28 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.daemonHostIp, "daemonHostIp", defaultDaemonHostIp, "Daemon address on host")
 	rootCmd.PersistentFlags().StringVar(&config.daemonIpuIp, "daemonIpuIp", defaultDaemonIpuIp, "Daemon address on ipu")
 	rootCmd.PersistentFlags().IntVar(&config.daemonPort, "daemonPort", defaultDaemonPort, "Daemon port port")
+	rootCmd.PersistentFlags().DurationVar(&config.vfWatchIntv, "vfWatchInterval", defaultVfWatchIntv,
+		"Interval at which the host VFs are re-read from the IMC to update the FXP rules, 0 disables the periodic resync")
+	rootCmd.PersistentFlags().StringSliceVar(&config.excludeIntfs, "excludeInterfaces", ipuplugin.DefaultExcludedInterfaces,
+		"Interfaces that are never reported as devices")
+	rootCmd.PersistentFlags().DurationVar(&config.vfCreateTmo, "vfCreateTimeout", defaultVfCreateTmo,
+		"Time to wait for the VFs of a PF to be created or removed by SetNumVfs")
+	rootCmd.PersistentFlags().StringVar(&config.tlsCertFile, "tlsCertFile", "", "TLS certificate file of the gRPC server, TLS is disabled when not set")
+	rootCmd.PersistentFlags().StringVar(&config.tlsKeyFile, "tlsKeyFile", "", "TLS private key file of the gRPC server")
+	rootCmd.PersistentFlags().StringVar(&config.tlsClientCA, "tlsClientCAFile", "",
+		"CA certificate file used to verify client certificates, mutual TLS is required when set")
+	rootCmd.PersistentFlags().BoolVar(&config.allowInsecure, "allowInsecureTcp", false, "Allow serving on TCP without TLS")
+	rootCmd.PersistentFlags().BoolVar(&config.reflection, "enableReflection", false, "Register the gRPC server reflection service")
+	rootCmd.PersistentFlags().StringVar(&config.metricsAddr, "metricsAddr", "",
+		"Address the Prometheus metrics are served on over HTTP, e.g.; :9090, metrics are disabled when not set")
+	rootCmd.PersistentFlags().StringVar(&config.otlpEndpoint, "otlpEndpoint", "",
+		"OTLP/HTTP endpoint the request spans are exported to, e.g.; http://otel-collector:4318, tracing is disabled when not set")
+	rootCmd.PersistentFlags().StringVar(&config.shutdownPol, "shutdownPolicy", ipuplugin.ShutdownPreserve,
+		"What is done with the BridgePorts on shutdown: 'preserve|teardown'")
+	rootCmd.PersistentFlags().DurationVar(&config.shutdownTmo, "shutdownTimeout", ipuplugin.DefaultShutdownTimeout,
+		"Time given to the in-flight requests to complete on shutdown before they are cancelled, 0 waits for them")
+	rootCmd.PersistentFlags().StringVar(&config.stateFile, "stateFile", defaultStateFile,
+		"File the BridgePorts, LogicalBridges and Vrfs are saved to on each change and on shutdown, and restored from on start, state is not persisted when empty")
 
 	// Determine plugin mode based on platform arch. i.e.; arm == "ipu" mode
 	// Should the platform arch changes to amd64 in future then we will need to introduce the "mode" flag again
@@ -222,7 +397,11 @@ func init() {
 		"interface",
 		"bridge",
 		"ovsCliDir",
+		"ovsdbSock",
 		"bridgeType",
+		"stag",
+		"vlanRanges",
+		"reservedVlans",
 		"p4pkg",
 		"p4rtbin",
 		"portMuxVsi",
@@ -230,6 +409,19 @@ func init() {
 		"daemonHostIp",
 		"daemonIpuIp",
 		"daemonPort",
+		"vfWatchInterval",
+		"excludeInterfaces",
+		"vfCreateTimeout",
+		"tlsCertFile",
+		"tlsKeyFile",
+		"tlsClientCAFile",
+		"allowInsecureTcp",
+		"enableReflection",
+		"metricsAddr",
+		"otlpEndpoint",
+		"shutdownPolicy",
+		"shutdownTimeout",
+		"stateFile",
 	}
 
 	for _, f := range flagList {
@@ -264,6 +456,37 @@ func initConfig() {
 	}
 }
 
+func getShutdownConfig() ipuplugin.ShutdownConfig {
+	return ipuplugin.ShutdownConfig{
+		Policy:    viper.GetString("shutdownPolicy"),
+		Timeout:   viper.GetDuration("shutdownTimeout"),
+		StateFile: viper.GetString("stateFile"),
+	}
+}
+
+// reloadConfig reads the config file again on SIGHUP. Only the log level, the resource pools, the excluded
+// interfaces and the shutdown settings are reloaded, the other options require a restart.
+func reloadConfig() (*ipuplugin.ReloadableConfig, error) {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	}
+	lgLvl, err := log.ParseLevel(viper.GetString("verbosity"))
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ipuplugin.ReloadableConfig{
+		ExcludeInterfaces: viper.GetStringSlice("excludeInterfaces"),
+		Shutdown:          getShutdownConfig(),
+	}
+	if err := viper.UnmarshalKey("resourcePools", &cfg.ResourcePools); err != nil {
+		return nil, fmt.Errorf("invalid resourcePools configuration: %w", err)
+	}
+	log.SetLevel(lgLvl)
+	return cfg, nil
+}
+
 func validateConfigs() error {
 	if config.mode != types.HostMode && config.mode != types.IpuMode {
 		return fmt.Errorf("invalid mode specified: %s", config.mode)
@@ -306,12 +529,16 @@ func logInit(logDir string, logLevel string) error {
 	return nil
 }
 
-func getBridgeController(bridge, bridgeType, ovsCliDir string) (types.BridgeController, types.BridgeType) {
+func getBridgeController(bridge, bridgeType, ovsCliDir, ovsdbSock string) (types.BridgeController, types.BridgeType) {
 	switch bridgeType {
 	case "ovs":
 		return ipuplugin.NewOvsBridgeController(bridge, ovsCliDir), types.OvsBridge
+	case "ovsdb":
+		return ipuplugin.NewOvsdbBridgeController(bridge, ovsdbSock), types.OvsBridge
 	case "linux":
 		return ipuplugin.NewLinuxBridgeController(bridge), types.LinuxBridge
+	case "linux-vlan":
+		return ipuplugin.NewLinuxVlanBridgeController(bridge), types.LinuxVlanBridge
 	default:
 		return ipuplugin.NewLinuxBridgeController(bridge), types.LinuxBridge
 	}
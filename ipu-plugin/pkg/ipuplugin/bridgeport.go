@@ -16,9 +16,15 @@ package ipuplugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
 	log "github.com/sirupsen/logrus"
@@ -28,114 +34,406 @@ import (
 )
 
 const (
-	outerVlanId = 0 // hardcoded s-tag
+	// stagPrefix marks the logical bridge giving the S-tag of the outer vlan of a port, e.g.; "stag=300"
+	stagPrefix = "stag="
 )
 
+// Abstract script execution for unit tests
+var executeScriptFn = utils.ExecuteScript
+
 // CreateBridgePort executes the creation of the port
-func (s *server) CreateBridgePort(_ context.Context, in *pb.CreateBridgePortRequest) (*pb.BridgePort, error) {
-	s.log.WithField("CreateBridgePortRequest", in).Debug("CreateBridgePort")
-
-	// The assumption here is that the second octet is the VSI number.
-	// e.g.; a mac address of 00:08:00:00:03:14 the corresponding VSI is 08.
-	// VSI = 0 should be invalid and this function will return 0 when there's an error converting
-	// this octet to int value
-	macAddrSize := len(in.BridgePort.Spec.MacAddress)
-	if macAddrSize < 1 || macAddrSize > 6 {
-		// We do not have a valid mac address
-		return nil, fmt.Errorf("invalid mac address provided")
-	}
-	vfVsi := int(in.BridgePort.Spec.MacAddress[1])
-	if in.BridgePort.Spec.LogicalBridges == nil || len(in.BridgePort.Spec.LogicalBridges) < 1 {
-		return nil, fmt.Errorf("vlan id is not provided")
+func (s *server) CreateBridgePort(ctx context.Context, in *pb.CreateBridgePortRequest) (*pb.BridgePort, error) {
+	logger := tracing.Logger(ctx)
+	logger.WithField("CreateBridgePortRequest", in).Debug("CreateBridgePort")
+
+	// Keep the logical bridges of the port from being changed or deleted until it is stored
+	s.lbMu.RLock()
+	defer s.lbMu.RUnlock()
+	spec, err := s.validateBridgePort(ctx, in.BridgePort)
+	if err != nil {
+		logger.WithField("error", err).Debug("invalid bridge port")
+		return nil, err
+	}
+
+	// Serialize the operations on the same port, e.g.; a CNI ADD retried while the first one is still running
+	defer s.portLocks.lock(in.BridgePort.Name)()
+
+	// A retried request returns the port it created, a request with another spec is rejected
+	if port, ok := s.getPort(in.BridgePort.Name); ok {
+		stored := port.Spec
+		if vlan, ok := s.portAutoVlan(in.BridgePort.Name); ok && spec.autoVlan {
+			stored = withoutVlan(stored, vlan)
+		}
+		if diff := specDiff(stored, in.BridgePort.Spec); diff != "" {
+			return nil, alreadyExists(bridgePortResource, in.BridgePort.Name,
+				fmt.Sprintf("bridge port %s already exists with another spec: %s", in.BridgePort.Name, diff))
+		}
+		if qos := s.getPortQos(in.BridgePort.Name); qos != spec.qos {
+			return nil, alreadyExists(bridgePortResource, in.BridgePort.Name,
+				fmt.Sprintf("bridge port %s already exists with another qos: %+v", in.BridgePort.Name, qos))
+		}
+		return proto.Clone(port).(*pb.BridgePort), nil
 	}
-	vlan := s.getFirstVlanID(in.BridgePort.Spec.LogicalBridges)
 
-	if vlan < 2 || vlan > 4094 {
-		s.log.WithField("vlan", vlan).Debug("invalid vlan")
-		return nil, fmt.Errorf("invalid vlan %d, vlan must be within 2-4094 range", vlan)
+	autoVlan := 0
+	if spec.autoVlan {
+		vlan, release, err := s.vlanAlloc.allocate(spec.stag, func(vlan int) bool { return s.vlanUsed(spec.stag, vlan) })
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		logger.WithFields(log.Fields{"vlan": vlan, "stag": spec.stag}).Info("vlan allocated to the bridge port")
+		autoVlan, spec.vlans, spec.ownVlans = vlan, []int{vlan}, []int{vlan}
 	}
 
-	if vfVsi < 1 {
-		s.log.WithField("vfVsi", vfVsi).Debug("invalid VSI")
-		return nil, fmt.Errorf("invalid VSI:%d in given mac address, the value in 2nd octed must be > 0", vfVsi)
+	// Keep two ports from claiming the same VSI or vlan at the same time
+	for _, key := range spec.claimKeys() {
+		defer s.claimLocks.lock(key)()
+	}
+	if err := s.checkClaims(in.BridgePort.Name, spec); err != nil {
+		return nil, err
 	}
 
-	if isBridgePortPresent(*s, in.BridgePort.Name) {
-		return s.Ports[in.BridgePort.Name], nil
+	if err := s.ensureOuterVlan(ctx, spec.stag); err != nil {
+		return nil, internalError(reasonNetlink, err)
 	}
 
-	if !isOuterVlanSetup(s.uplinkInterface) {
-		outerVlanIntfName, err := createAndSetUpOuterVlan(s.uplinkInterface)
-		if err != nil {
-			s.log.WithField("vlan", in.BridgePort.Name).Error("unable to create outer vlan: ")
-			return nil, fmt.Errorf("unable to create bridge port: %v", err)
+	if err := s.addPortVlans(ctx, in.BridgePort, spec.vsi, spec.stag, spec.vlans); err != nil {
+		return nil, internalError(reasonNetlink, err)
+	}
+	if err := s.addPortQos(ctx, spec.vsi, spec.stag, spec.vlans, spec.qos); err != nil {
+		if rbErr := s.removePortVlans(ctx, in.BridgePort, spec.vsi, spec.stag, spec.vlans); rbErr != nil {
+			logger.WithField("error", rbErr).Error("unable to roll back the vlans of the bridge port")
 		}
+		return nil, internalError(reasonNetlink, err)
+	}
+
+	resp := proto.Clone(in.BridgePort).(*pb.BridgePort)
+	if autoVlan != 0 {
+		// The client learns the allocated vlan from the returned port
+		resp.Spec.LogicalBridges = append(resp.Spec.LogicalBridges, strconv.Itoa(autoVlan))
+	}
+	resp.Status = &pb.BridgePortStatus{OperStatus: pb.BPOperStatus_BP_OPER_STATUS_UP}
+	s.setPort(in.BridgePort.Name, resp, spec.vsi, portVlans{Stag: spec.stag, Vlans: spec.vlans}, autoVlan, spec.qos)
+	s.persistState(ctx)
+	return proto.Clone(resp).(*pb.BridgePort), nil
+}
 
-		if err := s.bridgeCtlr.AddPort(outerVlanIntfName); err != nil {
-			return nil, fmt.Errorf("failed to add port to bridge: %v", err)
+// addPortVlans adds each vlan of port to the bridge with its FXP rules. When one of them fails, the vlans added
+// before it are removed again.
+func (s *server) addPortVlans(ctx context.Context, port *pb.BridgePort, vsi, stag int, vlans []int) error {
+	for i, vlan := range vlans {
+		if err := s.addPortVlan(ctx, port.Name, stag, vlan); err != nil {
+			if rbErr := s.removePortVlans(ctx, port, vsi, stag, vlans[:i]); rbErr != nil {
+				tracing.Logger(ctx).WithField("error", rbErr).Error("unable to roll back the vlans of the bridge port")
+			}
+			return err
 		}
-		runCmd := "bridge link set dev " + outerVlanIntfName + " learning off"
-		log.Debugf("run cmd->%s\n", runCmd)
-		_, err = utils.ExecuteScript(runCmd)
-		if err != nil {
-			return nil, fmt.Errorf("Error->%v, turning learning off on outer vlan->%v\n", err, outerVlanIntfName)
-		} else {
-			log.Debugf("Turned learning off for outer vlan->%v\n", outerVlanIntfName)
+		// Add FXP rules
+		s.p4RtClient.AddRules(ctx, types.FxpRuleSpec{MacAddr: port.Spec.MacAddress, Vsi: vsi, Vlan: vlan, Stag: stag})
+	}
+	return nil
+}
+
+func (s *server) addPortVlan(ctx context.Context, name string, stag, vlan int) error {
+	if s.vlanFiltering() {
+		if err := s.addTrunkVlan(ctx, stag, vlan); err != nil {
+			return fmt.Errorf("failed to add vlan to bridge: %w", err)
 		}
+		return nil
 	}
 
-	vlanIntfName, err := createAndSetUpInnerVlan(s.uplinkInterface, in.BridgePort.Spec.LogicalBridges)
+	vlanIntfName, err := s.createInnerVlan(ctx, stag, vlan)
 	if err != nil {
-		s.log.WithField("vlan", in.BridgePort.Name).Error("unable to create vlan: ")
-		return nil, fmt.Errorf("unable to create bridge port: %v", err)
+		tracing.Logger(ctx).WithField("vlan", vlan).WithField("error", err).Error("unable to create vlan")
+		return fmt.Errorf("unable to create bridge port: %w", err)
+	}
+
+	if err := s.bridgeCtlr.AddPort(types.WithBridgePortName(ctx, name), vlanIntfName); err != nil {
+		if rbErr := s.removeInnerVlan(ctx, name, stag, vlan); rbErr != nil {
+			tracing.Logger(ctx).WithField("error", rbErr).Error("unable to remove the vlan interface")
+		}
+		return fmt.Errorf("failed to add port to bridge: %w", err)
 	}
+	return nil
+}
 
-	if err := s.bridgeCtlr.AddPort(vlanIntfName); err != nil {
-		return nil, fmt.Errorf("failed to add port to bridge: %v", err)
+// removePortVlans removes each vlan of port from the bridge with its FXP rules, going on with the next vlans
+// when one of them fails
+func (s *server) removePortVlans(ctx context.Context, port *pb.BridgePort, vsi, stag int, vlans []int) error {
+	var errs []error
+	for _, vlan := range vlans {
+		if err := s.removePortVlan(ctx, port.Name, stag, vlan); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// Delete FXP rules
+		s.p4RtClient.DeleteRules(ctx, types.FxpRuleSpec{MacAddr: port.Spec.MacAddress, Vsi: vsi, Vlan: vlan, Stag: stag})
 	}
-	// Add FXP rules
-	s.p4RtClient.AddRules(in.BridgePort.Spec.MacAddress, vlan)
+	return errors.Join(errs...)
+}
 
-	resp := proto.Clone(in.BridgePort).(*pb.BridgePort)
-	resp.Status = &pb.BridgePortStatus{OperStatus: pb.BPOperStatus_BP_OPER_STATUS_UP}
-	s.Ports[in.BridgePort.Name] = resp
-	return resp, nil
+func (s *server) removePortVlan(ctx context.Context, name string, stag, vlan int) error {
+	if s.vlanFiltering() {
+		if err := s.removeTrunkVlan(ctx, name, stag, vlan); err != nil {
+			tracing.Logger(ctx).WithField("error", err).Error("unable to remove vlan from bridge")
+			return fmt.Errorf("failed to delete vlan from bridge: %v", err)
+		}
+		return nil
+	}
+	return s.removeInnerVlan(ctx, name, stag, vlan)
+}
+
+// ensureOuterVlan creates the outer S-VLAN interface of stag shared by the ports of that S-tag and adds it to
+// the bridge, if it is not set up yet
+func (s *server) ensureOuterVlan(ctx context.Context, stag int) error {
+	logger := tracing.Logger(ctx)
+	// Hold the interface lock so that concurrent requests do not both find the outer vlan missing
+	defer s.intfLocks.lock(getOuterVlanIntfName(s.uplinkInterface, stag))()
+
+	if isOuterVlanSetup(s.uplinkInterface, stag) {
+		return nil
+	}
+
+	outerVlanIntfName, err := createAndSetUpOuterVlan(ctx, s.uplinkInterface, stag)
+	if err != nil {
+		logger.WithField("uplink", s.uplinkInterface).Error("unable to create outer vlan: ")
+		return fmt.Errorf("unable to create bridge port: %v", err)
+	}
+
+	if err := s.bridgeCtlr.AddPort(ctx, outerVlanIntfName); err != nil {
+		return fmt.Errorf("failed to add port to bridge: %v", err)
+	}
+	runCmd := "bridge link set dev " + outerVlanIntfName + " learning off"
+	if s.vlanFiltering() {
+		// The frames between two BridgePorts on the same vlan are sent back out of the trunk port they came in
+		runCmd += " hairpin on"
+	}
+	logger.Debugf("run cmd->%s\n", runCmd)
+	_, err = executeScriptFn(runCmd)
+	if err != nil {
+		return fmt.Errorf("Error->%v, turning learning off on outer vlan->%v\n", err, outerVlanIntfName)
+	} else {
+		logger.Debugf("Turned learning off for outer vlan->%v\n", outerVlanIntfName)
+	}
+	return nil
+}
+
+// removeOuterVlan removes the outer vlan interface of stag from the bridge and the host, if it was set up
+func (s *server) removeOuterVlan(ctx context.Context, stag int) error {
+	outerVlanIntfName := getOuterVlanIntfName(s.uplinkInterface, stag)
+	defer s.intfLocks.lock(outerVlanIntfName)()
+
+	if !isOuterVlanSetup(s.uplinkInterface, stag) {
+		return nil
+	}
+	if err := s.bridgeCtlr.DeletePort(ctx, outerVlanIntfName); err != nil {
+		return fmt.Errorf("failed to delete outer vlan from bridge: %v", err)
+	}
+	return removeVlanInterface(ctx, outerVlanIntfName)
+}
+
+// createInnerVlan creates the inner vlan interface of vlan in the outer vlan of stag, which may be shared with
+// the other ports on the same vlans
+func (s *server) createInnerVlan(ctx context.Context, stag, vlan int) (string, error) {
+	defer s.intfLocks.lock(getInnerVlanIntfName(s.uplinkInterface, stag, vlan))()
+	return createAndSetUpInnerVlan(ctx, s.uplinkInterface, stag, vlan)
+}
+
+// vlanFiltering returns whether the BridgePorts are VLANs of the outer vlan interface, used as the trunk port of
+// a vlan_filtering bridge, instead of inner vlan interfaces added to the bridge
+func (s *server) vlanFiltering() bool {
+	return s.bridgeType == types.LinuxVlanBridge
+}
+
+// addTrunkVlan makes the outer vlan interface of stag a tagged member of vlan
+func (s *server) addTrunkVlan(ctx context.Context, stag, vlan int) error {
+	outerVlanIntfName := getOuterVlanIntfName(s.uplinkInterface, stag)
+	defer s.intfLocks.lock(outerVlanIntfName)()
+	return s.bridgeCtlr.SetPortVlan(ctx, outerVlanIntfName, vlan, true)
+}
+
+// removeTrunkVlan removes vlan from the outer vlan interface of stag, unless another BridgePort than name is
+// still on it
+func (s *server) removeTrunkVlan(ctx context.Context, name string, stag, vlan int) error {
+	outerVlanIntfName := getOuterVlanIntfName(s.uplinkInterface, stag)
+	defer s.intfLocks.lock(outerVlanIntfName)()
+
+	if s.vlanInUse(name, stag, vlan) || !isOuterVlanSetup(s.uplinkInterface, stag) {
+		return nil
+	}
+	return s.bridgeCtlr.RemovePortVlan(ctx, outerVlanIntfName, vlan)
+}
+
+// vlanInUse returns whether a BridgePort other than name is on vlan in the outer vlan of stag
+func (s *server) vlanInUse(name string, stag, vlan int) bool {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	for portName := range s.Ports {
+		if portName == name {
+			continue
+		}
+		if pv, err := s.portVlansLocked(portName); err == nil && pv.Stag == stag && slices.Contains(pv.Vlans, vlan) {
+			return true
+		}
+	}
+	return false
+}
+
+// portVlans are the S-tag and vlans a BridgePort was created on. They are kept with the port so that it is removed
+// from them even after its logical bridges were changed or deleted.
+type portVlans struct {
+	Stag  int   `json:"stag"`
+	Vlans []int `json:"vlans"`
+}
+
+// getPortVlans returns the S-tag and vlans the BridgePort name was created on
+func (s *server) getPortVlans(name string) (portVlans, error) {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	return s.portVlansLocked(name)
+}
+
+// portVlansLocked returns the S-tag and vlans the BridgePort name was created on, or the ones given by its logical
+// bridges when they were not recorded, e.g.; for a port restored from an older state file. portsMu must be held.
+func (s *server) portVlansLocked(name string) (portVlans, error) {
+	if pv, ok := s.portVlans[name]; ok {
+		return pv, nil
+	}
+	return s.resolvePortVlans(s.Ports[name].GetSpec().GetLogicalBridges())
+}
+
+// resolvePortVlans returns the S-tag and vlans given by the logical bridges of a BridgePort
+func (s *server) resolvePortVlans(bridges []string) (portVlans, error) {
+	stag, err := s.getStag(bridges)
+	if err != nil {
+		return portVlans{}, err
+	}
+	vlans, err := s.getVlanIDs(bridges)
+	if err != nil {
+		return portVlans{}, err
+	}
+	return portVlans{Stag: stag, Vlans: vlans}, nil
+}
+
+// getPort returns the BridgePort name, if it exists
+func (s *server) getPort(name string) (*pb.BridgePort, bool) {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	port, ok := s.Ports[name]
+	return port, ok
+}
+
+// setPort stores the BridgePort name along with the VSI of its VF, the vlans it is on, the vlan allocated to it and
+// its QoS, if any
+func (s *server) setPort(name string, port *pb.BridgePort, vsi int, pv portVlans, autoVlan int, qos portQos) {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	if s.Ports == nil {
+		s.Ports = make(map[string]*pb.BridgePort)
+	}
+	if s.vsis == nil {
+		s.vsis = make(map[string]int)
+	}
+	if s.portVlans == nil {
+		s.portVlans = make(map[string]portVlans)
+	}
+	s.Ports[name] = port
+	s.vsis[name] = vsi
+	if len(pv.Vlans) > 0 {
+		s.portVlans[name] = pv
+	}
+	if autoVlan != 0 {
+		if s.autoVlans == nil {
+			s.autoVlans = make(map[string]int)
+		}
+		s.autoVlans[name] = autoVlan
+	}
+	if qos.isSet() {
+		if s.portQos == nil {
+			s.portQos = make(map[string]portQos)
+		}
+		s.portQos[name] = qos
+	}
+	metrics.BridgePorts.Set(float64(len(s.Ports)))
+}
+
+func (s *server) deletePort(name string) {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	delete(s.Ports, name)
+	delete(s.vsis, name)
+	delete(s.autoVlans, name)
+	delete(s.portVlans, name)
+	delete(s.portQos, name)
+	metrics.BridgePorts.Set(float64(len(s.Ports)))
+}
+
+// portVsi returns the VSI the BridgePort name was created with, or the one given by its mac address when it was
+// not recorded
+func (s *server) portVsi(name string) int {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	if vsi, ok := s.vsis[name]; ok {
+		return vsi
+	}
+	if mac := s.Ports[name].GetSpec().GetMacAddress(); len(mac) > 1 {
+		return int(mac[1])
+	}
+	return 0
+}
+
+// portAutoVlan returns the vlan allocated to the BridgePort name, if it was created without one
+func (s *server) portAutoVlan(name string) (int, bool) {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	vlan, ok := s.autoVlans[name]
+	return vlan, ok
 }
 
-// isBridgePortPresent checks if the bridge port is present
-func isBridgePortPresent(srv server, brPortName string) bool {
-	_, ok := srv.Ports[brPortName]
-	return ok
+// getPortQos returns the QoS the BridgePort name was created with
+func (s *server) getPortQos(name string) portQos {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	return s.portQos[name]
 }
 
-func isOuterVlanSetup(uplinkInterface string) bool {
+// getOuterVlanIntfName returns the name of the outer vlan interface of stag on uplinkInterface
+func getOuterVlanIntfName(uplinkInterface string, stag int) string {
 	// Assume that the uplink interface name is something like enp0s1f0d3
 	// take only the last two characters from the name to avoid long names limit
-	outerVlanIntfName := fmt.Sprintf("%v.%d", uplinkInterface[len(uplinkInterface)-2:], outerVlanId)
+	return fmt.Sprintf("%v.%d", uplinkInterface[len(uplinkInterface)-2:], stag)
+}
+
+// getInnerVlanIntfName returns the name of the inner vlan interface of vlanId in the outer vlan of stag on
+// uplinkInterface
+func getInnerVlanIntfName(uplinkInterface string, stag, vlanId int) string {
+	return fmt.Sprintf("%v.%d.%d", uplinkInterface[len(uplinkInterface)-2:], stag, vlanId)
+}
 
-	_, err := linkByNameFn(outerVlanIntfName)
+func isOuterVlanSetup(uplinkInterface string, stag int) bool {
+	_, err := linkByNameFn(getOuterVlanIntfName(uplinkInterface, stag))
 
 	return err == nil
 }
 
-func createAndSetUpOuterVlan(uplinkInterface string) (string, error) {
+func createAndSetUpOuterVlan(ctx context.Context, uplinkInterface string, stag int) (string, error) {
 	upLink, err := linkByNameFn(uplinkInterface)
 	if err != nil {
 		return "", fmt.Errorf("unable to find uplink interface: %s, because: %w", uplinkInterface, err)
 	}
 
-	// Assume that the uplink interface name is something like enp0s1f0d3
-	// take only the last two characters from the name to avoid long names limit
-	vlanIntfName := fmt.Sprintf("%v.%d", uplinkInterface[len(uplinkInterface)-2:], outerVlanId)
+	vlanIntfName := getOuterVlanIntfName(uplinkInterface, stag)
 
-	if err := createOuterVlanInterface(upLink, vlanIntfName, outerVlanId); err != nil {
+	if err := createOuterVlanInterface(ctx, upLink, vlanIntfName, stag); err != nil {
 		return "", err
 	}
 
 	return vlanIntfName, nil
 }
 
-func createOuterVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId int) error {
+func createOuterVlanInterface(ctx context.Context, upLink netlink.Link, vlanIntfName string, vlanId int) error {
+	logger := tracing.Logger(ctx)
 
 	link, err := linkByNameFn(vlanIntfName)
 	if err == nil {
@@ -143,7 +441,7 @@ func createOuterVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId i
 		if _, ok := link.(*netlink.Vlan); !ok {
 			return fmt.Errorf("an interface %s is found but not a VLAN device", vlanIntfName)
 		}
-		log.Debugf("outer vlan interface %s already exist", vlanIntfName)
+		logger.Debugf("outer vlan interface %s already exist", vlanIntfName)
 		return nil
 	}
 
@@ -153,42 +451,34 @@ func createOuterVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId i
 	vlan.VlanId = vlanId
 	vlan.VlanProtocol = netlink.VLAN_PROTOCOL_8021AD
 
-	log.Debugf("creating a new outer vlan interface %s", vlanIntfName)
+	logger.Debugf("creating a new outer vlan interface %s", vlanIntfName)
 	if err := linkAddFn(&vlan); err != nil {
 		return fmt.Errorf("error creating outer vlan interface %s: %s", vlanIntfName, err)
 	}
-	log.Debugf("outer vlan interface %s is created", vlanIntfName)
+	logger.Debugf("outer vlan interface %s is created", vlanIntfName)
 
 	return nil
 }
 
-func createAndSetUpInnerVlan(uplinkInterface string, bridges []string) (string, error) {
-	// Assume that the uplink interface name is something like enp0s1f0d3
-	// take only the last two characters from the name to avoid long names limit
-	outerVlanIntfName := fmt.Sprintf("%v.%d", uplinkInterface[len(uplinkInterface)-2:], outerVlanId)
+func createAndSetUpInnerVlan(ctx context.Context, uplinkInterface string, stag, innerVlanId int) (string, error) {
+	outerVlanIntfName := getOuterVlanIntfName(uplinkInterface, stag)
 
 	upLink, err := linkByNameFn(outerVlanIntfName)
 	if err != nil {
 		return "", fmt.Errorf("unable to find the outer vlan interface: %s, because: %w", outerVlanIntfName, err)
 	}
 
-	innerVlanId, err := strconv.Atoi(bridges[0])
-	if err != nil {
-		return "", fmt.Errorf("unable to parse vlan ID: %s, because: %w", bridges[0], err)
-	}
+	vlanIntfName := getInnerVlanIntfName(uplinkInterface, stag, innerVlanId)
 
-	// Assume that the uplink interface name is something like enp0s1f0d3
-	// take only the last two characters from the name to avoid long names limit
-	vlanIntfName := fmt.Sprintf("%v.%d.%d", uplinkInterface[len(uplinkInterface)-2:], outerVlanId, innerVlanId)
-
-	if err := createInnerVlanInterface(upLink, vlanIntfName, innerVlanId); err != nil {
+	if err := createInnerVlanInterface(ctx, upLink, vlanIntfName, innerVlanId); err != nil {
 		return "", err
 	}
 
 	return vlanIntfName, nil
 }
 
-func createInnerVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId int) error {
+func createInnerVlanInterface(ctx context.Context, upLink netlink.Link, vlanIntfName string, vlanId int) error {
+	logger := tracing.Logger(ctx)
 
 	link, err := linkByNameFn(vlanIntfName)
 	if err == nil {
@@ -196,7 +486,7 @@ func createInnerVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId i
 		if _, ok := link.(*netlink.Vlan); !ok {
 			return fmt.Errorf("an interface %s is found but not a VLAN device", vlanIntfName)
 		}
-		log.Debugf("inner vlan interface %s already exist", vlanIntfName)
+		logger.Debugf("inner vlan interface %s already exist", vlanIntfName)
 		return nil
 	}
 
@@ -206,22 +496,22 @@ func createInnerVlanInterface(upLink netlink.Link, vlanIntfName string, vlanId i
 	vlan.VlanId = vlanId
 	vlan.VlanProtocol = netlink.VLAN_PROTOCOL_8021Q
 
-	log.Debugf("creating a new inner vlan interface %s", vlanIntfName)
+	logger.Debugf("creating a new inner vlan interface %s", vlanIntfName)
 	if err := linkAddFn(&vlan); err != nil {
 		return fmt.Errorf("error creating vlan interface %s: %s", vlanIntfName, err)
 	}
-	log.Debugf("inner vlan interface %s is created", vlanIntfName)
+	logger.Debugf("inner vlan interface %s is created", vlanIntfName)
 
 	return nil
 }
 
-func removeVlanInterface(vlanIntfName string) error {
+func removeVlanInterface(ctx context.Context, vlanIntfName string) error {
 	// Look up interface if it exists
 	// If the vlan interface does not exist then do nothing and return
 	link, err := linkByNameFn(vlanIntfName)
 	if err != nil {
 		// Link for vlan interface is found, nothing to do
-		log.WithField("vlan", vlanIntfName).Info("interface not found to remove, may have been removed already")
+		tracing.Logger(ctx).WithField("vlan", vlanIntfName).Info("interface not found to remove, may have been removed already")
 		return nil
 	}
 
@@ -232,61 +522,152 @@ func removeVlanInterface(vlanIntfName string) error {
 	return nil
 }
 
-// DeleteBridgePort deletes a port
-func (s *server) DeleteBridgePort(_ context.Context, in *pb.DeleteBridgePortRequest) (*emptypb.Empty, error) {
-	s.log.WithField("DeleteBridgePortRequest", in).Info("DeleteBridgePort")
+// DeleteBridgePort deletes a port. Deleting a port which does not exist fails with NotFound, unless AllowMissing
+// is set.
+func (s *server) DeleteBridgePort(ctx context.Context, in *pb.DeleteBridgePortRequest) (*emptypb.Empty, error) {
+	logger := tracing.Logger(ctx)
+	logger.WithField("DeleteBridgePortRequest", in).Info("DeleteBridgePort")
+
+	defer s.portLocks.lock(in.Name)()
 
-	var portInfo *pb.BridgePort
-	portInfo, ok := s.Ports[in.Name]
+	portInfo, ok := s.getPort(in.Name)
 	if !ok {
-		s.log.WithField("interface name", in.Name).Info("port info is not found")
-		// in such case avoid delete call loop from CNI Agent which otherwise will repeatedly call DeleteBridgePort as retry
-		return &emptypb.Empty{}, nil
+		logger.WithField("interface name", in.Name).Info("port info is not found")
+		// The CNI sets AllowMissing so that a DEL retried after the port is gone succeeds
+		if in.AllowMissing {
+			return &emptypb.Empty{}, nil
+		}
+		return nil, notFound(bridgePortResource, in.Name)
 	}
 
-	vlan := s.getFirstVlanID(portInfo.Spec.LogicalBridges)
-	vlanIntfName := fmt.Sprintf("%v.%d.%d", s.uplinkInterface[len(s.uplinkInterface)-2:], outerVlanId, vlan)
-
-	if err := s.bridgeCtlr.DeletePort(vlanIntfName); err != nil {
-		log.Error("unable to remove port from bridge", err)
-		return nil, fmt.Errorf("failed to delete port from bridge: %v", err)
+	// The vlans the port was created on are removed, whatever its logical bridges became since
+	pv, err := s.getPortVlans(in.Name)
+	if err != nil {
+		return nil, failedPrecondition("VLAN", in.Name, fmt.Sprintf("unable to tell the vlans of bridge port %s: %v", in.Name, err))
+	}
+	// The tc rules are on the vlan interfaces, so the QoS is removed first and applied again when the port is kept
+	qos := s.getPortQos(in.Name)
+	s.removePortQos(ctx, s.portVsi(in.Name), pv.Stag, pv.Vlans, qos)
+	// The port is kept when one of its vlans could not be removed so that the delete can be retried
+	if err := s.removePortVlans(ctx, portInfo, s.portVsi(in.Name), pv.Stag, pv.Vlans); err != nil {
+		if qosErr := s.addPortQos(ctx, s.portVsi(in.Name), pv.Stag, pv.Vlans, qos); qosErr != nil {
+			logger.WithField("error", qosErr).Error("unable to apply the qos of the bridge port again")
+		}
+		return nil, internalError(reasonNetlink, err)
 	}
 
-	if err := removeVlanInterface(vlanIntfName); err != nil {
-		log.Error("unable to remove remove interface from host", err)
-		return nil, fmt.Errorf("failed to remove interface from host: %v", err)
+	s.deletePort(in.Name)
+	s.persistState(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+// removeInnerVlan deletes the inner vlan interface of vlan in the outer vlan of stag from the bridge and the
+// host, unless another BridgePort than name is still on it
+func (s *server) removeInnerVlan(ctx context.Context, name string, stag, vlan int) error {
+	logger := tracing.Logger(ctx)
+	vlanIntfName := getInnerVlanIntfName(s.uplinkInterface, stag, vlan)
+	defer s.intfLocks.lock(vlanIntfName)()
+
+	if s.vlanInUse(name, stag, vlan) {
+		return nil
+	}
+	if _, err := linkByNameFn(vlanIntfName); err != nil {
+		// Removed by an earlier attempt
+		logger.WithField("vlan", vlanIntfName).Info("interface not found to remove, may have been removed already")
+		return nil
 	}
 
-	// Delete FXP rules
-	s.p4RtClient.DeleteRules(portInfo.Spec.MacAddress, vlan)
+	if err := s.bridgeCtlr.DeletePort(ctx, vlanIntfName); err != nil {
+		logger.WithField("error", err).Error("unable to remove port from bridge")
+		return fmt.Errorf("failed to delete port from bridge: %v", err)
+	}
 
-	delete(s.Ports, in.Name)
-	return &emptypb.Empty{}, nil
+	if err := removeVlanInterface(ctx, vlanIntfName); err != nil {
+		logger.WithField("error", err).Error("unable to remove interface from host")
+		return fmt.Errorf("failed to remove interface from host: %v", err)
+	}
+	return nil
 }
 
-// UpdateBridgePort updates an Nvme Subsystem
-func (s *server) UpdateBridgePort(_ context.Context, in *pb.UpdateBridgePortRequest) (*pb.BridgePort, error) {
-	s.log.WithField("UpdateBridgePortRequest", in).Info("UpdateBridgePort")
+// UpdateBridgePort is not supported yet, the request is only logged and an empty BridgePort returned
+func (s *server) UpdateBridgePort(ctx context.Context, in *pb.UpdateBridgePortRequest) (*pb.BridgePort, error) {
+	tracing.Logger(ctx).WithField("UpdateBridgePortRequest", in).Info("UpdateBridgePort")
 	return &pb.BridgePort{}, nil
 }
 
 // GetBridgePort gets an BridgePort
-func (s *server) GetBridgePort(_ context.Context, in *pb.GetBridgePortRequest) (*pb.BridgePort, error) {
-	s.log.WithField("GetBridgePortRequest", in).Info("GetBridgePort")
-	return &pb.BridgePort{Name: in.Name, Spec: &pb.BridgePortSpec{}}, nil
+func (s *server) GetBridgePort(ctx context.Context, in *pb.GetBridgePortRequest) (*pb.BridgePort, error) {
+	tracing.Logger(ctx).WithField("GetBridgePortRequest", in).Info("GetBridgePort")
+	port, ok := s.getPort(in.Name)
+	if !ok {
+		return nil, notFound(bridgePortResource, in.Name)
+	}
+	return proto.Clone(port).(*pb.BridgePort), nil
 }
 
-// GetBridgePort gets an BridgePort
-func (s *server) ListBridgePorts(_ context.Context, in *pb.ListBridgePortsRequest) (*pb.ListBridgePortsResponse, error) {
-	s.log.WithField("ListBridgePortsRequest", in).Info("ListBridgePorts")
-	return &pb.ListBridgePortsResponse{}, nil
+// ListBridgePorts lists the BridgePorts ordered by name. The page token is the name of the last BridgePort of
+// the previous page.
+func (s *server) ListBridgePorts(ctx context.Context, in *pb.ListBridgePortsRequest) (*pb.ListBridgePortsResponse, error) {
+	tracing.Logger(ctx).WithField("ListBridgePortsRequest", in).Info("ListBridgePorts")
+	if err := validatePageSize(in.PageSize); err != nil {
+		return nil, err
+	}
+	names, next := paginate(s.portNames(), in.PageSize, in.PageToken)
+	resp := &pb.ListBridgePortsResponse{NextPageToken: next}
+	for _, name := range names {
+		if port, ok := s.getPort(name); ok {
+			resp.BridgePorts = append(resp.BridgePorts, proto.Clone(port).(*pb.BridgePort))
+		}
+	}
+	return resp, nil
 }
 
-func (s *server) getFirstVlanID(bridges []string) int {
-	vlanId, err := strconv.Atoi(bridges[0])
-	if err != nil {
-		s.log.Errorf("unable to parse vlan ID %s. conversion error %s", bridges[0], err)
-		return 0
+// getVlanIDs returns the vlans of the logical bridges, other than the "stag=<id>" one. A logical bridge is either
+// the name of a LogicalBridge, e.g.; "logicalBridges/blue", or a vlan id.
+func (s *server) getVlanIDs(bridges []string) ([]int, error) {
+	var vlans []int
+	for _, bridge := range bridges {
+		if strings.HasPrefix(bridge, stagPrefix) {
+			continue
+		}
+		var vlan int
+		if strings.HasPrefix(bridge, logicalBridgePrefix) {
+			lb, ok := s.logicalBridges.get(bridge)
+			if !ok {
+				return nil, notFound(logicalBridgeResource, bridge)
+			}
+			vlan = int(lb.Spec.VlanId)
+		} else {
+			var err error
+			if vlan, err = strconv.Atoi(bridge); err != nil {
+				return nil, fmt.Errorf("unable to parse vlan ID %s: %w", bridge, err)
+			}
+		}
+		if vlan < 2 || vlan > 4094 {
+			return nil, fmt.Errorf("invalid vlan %d, vlan must be within 2-4094 range", vlan)
+		}
+		if slices.Contains(vlans, vlan) {
+			return nil, fmt.Errorf("vlan %d is given more than once", vlan)
+		}
+		vlans = append(vlans, vlan)
+	}
+	if len(vlans) == 0 {
+		return nil, fmt.Errorf("vlan id is not provided")
+	}
+	return vlans, nil
+}
+
+// getStag returns the S-tag given by a "stag=<id>" logical bridge, or the configured S-tag when there is none
+func (s *server) getStag(bridges []string) (int, error) {
+	for _, bridge := range bridges {
+		if !strings.HasPrefix(bridge, stagPrefix) {
+			continue
+		}
+		stag, err := strconv.Atoi(strings.TrimPrefix(bridge, stagPrefix))
+		if err != nil || stag < 0 || stag > 4094 {
+			return 0, fmt.Errorf("invalid s-tag %q, s-tag must be within 0-4094 range", bridge)
+		}
+		return stag, nil
 	}
-	return vlanId
+	return s.stag, nil
 }
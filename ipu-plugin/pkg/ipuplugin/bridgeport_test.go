@@ -16,12 +16,21 @@ package ipuplugin
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var _ = Describe("bridgeport", Serial, func() {
@@ -30,7 +39,7 @@ var _ = Describe("bridgeport", Serial, func() {
 			It("should return error", func() {
 				linkByNameFn = fakeLinkByName // it returns a dummy netlink instance; not a valid vlan interface
 				dummyMasterLink := &netlink.Dummy{}
-				err := createInnerVlanInterface(dummyMasterLink, "dummyVlanIntf", 100)
+				err := createInnerVlanInterface(context.TODO(), dummyMasterLink, "dummyVlanIntf", 100)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -43,7 +52,7 @@ var _ = Describe("bridgeport", Serial, func() {
 				}
 				linkAddFn = fakeLinkAdd
 				dummyMasterLink := &netlink.Dummy{}
-				err := createInnerVlanInterface(dummyMasterLink, "dummyVlanIntf", 100)
+				err := createInnerVlanInterface(context.TODO(), dummyMasterLink, "dummyVlanIntf", 100)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -52,7 +61,7 @@ var _ = Describe("bridgeport", Serial, func() {
 				linkByNameFn = fakeLinkByNameWithErr
 				linkAddFn = fakeLinkAdd
 				dummyMasterLink := &netlink.Dummy{}
-				err := createInnerVlanInterface(dummyMasterLink, "dummyVlanIntf", 100)
+				err := createInnerVlanInterface(context.TODO(), dummyMasterLink, "dummyVlanIntf", 100)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -61,7 +70,7 @@ var _ = Describe("bridgeport", Serial, func() {
 				linkByNameFn = fakeLinkByNameWithErr
 				linkAddFn = fakeLinkAddWithErr
 				dummyMasterLink := &netlink.Dummy{}
-				err := createInnerVlanInterface(dummyMasterLink, "dummyVlanIntf", 100)
+				err := createInnerVlanInterface(context.TODO(), dummyMasterLink, "dummyVlanIntf", 100)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error creating vlan interface"))
 			})
@@ -181,4 +190,482 @@ var _ = Describe("bridgeport", Serial, func() {
 			})
 		})
 	})
+
+	Describe("concurrent CreateBridgePort and DeleteBridgePort", Serial, func() {
+		const (
+			numPorts      = 8
+			numIterations = 20
+		)
+		var (
+			ipuServer *server
+			links     *fakeLinkStore
+		)
+
+		BeforeEach(func() {
+			links = newFakeLinkStore("enp0s1f0d3", "br-test")
+			linkByNameFn = links.linkByName
+			linkAddFn = links.linkAdd
+			linkDelFn = links.linkDel
+			linkSetMasterFn = fakeLinkSetMaster
+			linkSetNoMasterFn = fakeLinkSetNoMaster
+			linkSetUpFn = fakeLinkSetUp
+			linkSetDownFn = fakeLinkSetDown
+			executeScriptFn = func(string) (string, error) { return "", nil }
+			DeferCleanup(func() {
+				linkDelFn = netlink.LinkDel
+				executeScriptFn = utils.ExecuteScript
+			})
+
+			ipuServer = &server{
+				uplinkInterface: "enp0s1f0d3",
+				bridgeCtlr:      NewLinuxBridgeController("br-test"),
+				p4RtClient:      &mockP4rtClient{},
+				log:             log.WithField("pkg", "bridgeport_test.go"),
+			}
+		})
+
+		newPort := func(i int) *pb.BridgePort {
+			return &pb.BridgePort{
+				Name: fmt.Sprintf("port%d", i),
+				Spec: &pb.BridgePortSpec{
+					MacAddress:     []byte{0x00, byte(i + 1), 0x00, 0x00, 0x03, 0x14},
+					LogicalBridges: []string{fmt.Sprint(100 + i)},
+				},
+			}
+		}
+
+		It("should create the outer vlan once and keep the ports consistent", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, numPorts*numIterations*3)
+			for i := 0; i < numPorts; i++ {
+				port := newPort(i)
+				// Two clients creating the same port, e.g.; a retried CNI ADD, and one deleting it
+				for c := 0; c < 2; c++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for n := 0; n < numIterations; n++ {
+							_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: port})
+							errs <- err
+						}
+					}()
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for n := 0; n < numIterations; n++ {
+						_, err := ipuServer.DeleteBridgePort(context.TODO(),
+							&pb.DeleteBridgePortRequest{Name: port.Name, AllowMissing: true})
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(links.added("d3.0")).To(Equal(1))
+			for i := 0; i < numPorts; i++ {
+				vlanIntfName := fmt.Sprintf("d3.0.%d", 100+i)
+				_, exists := ipuServer.getPort(fmt.Sprintf("port%d", i))
+				Expect(links.exists(vlanIntfName)).To(Equal(exists), "vlan interface %s of port%d", vlanIntfName, i)
+			}
+
+			// Deleting all the ports leaves only the shared interfaces
+			for i := 0; i < numPorts; i++ {
+				_, err := ipuServer.DeleteBridgePort(context.TODO(),
+					&pb.DeleteBridgePortRequest{Name: fmt.Sprintf("port%d", i), AllowMissing: true})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(ipuServer.Ports).To(BeEmpty())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+			Expect(ipuServer.portLocks.locks).To(BeEmpty())
+			Expect(ipuServer.intfLocks.locks).To(BeEmpty())
+		})
+	})
+
+	Describe("trunk ports", Serial, func() {
+		var (
+			ipuServer  *server
+			links      *fakeLinkStore
+			p4rtClient *mockP4rtClient
+			macs       map[string][]byte
+		)
+
+		BeforeEach(func() {
+			macs = map[string][]byte{}
+			links = newFakeLinkStore("enp0s1f0d3", "br-test")
+			linkByNameFn = links.linkByName
+			linkAddFn = links.linkAdd
+			linkDelFn = links.linkDel
+			linkSetMasterFn = fakeLinkSetMaster
+			linkSetNoMasterFn = fakeLinkSetNoMaster
+			linkSetUpFn = fakeLinkSetUp
+			linkSetDownFn = fakeLinkSetDown
+			executeScriptFn = func(string) (string, error) { return "", nil }
+			DeferCleanup(func() {
+				linkDelFn = netlink.LinkDel
+				executeScriptFn = utils.ExecuteScript
+			})
+
+			p4rtClient = &mockP4rtClient{}
+			ipuServer = &server{
+				uplinkInterface: "enp0s1f0d3",
+				bridgeCtlr:      NewLinuxBridgeController("br-test"),
+				p4RtClient:      p4rtClient,
+				log:             log.WithField("pkg", "bridgeport_test.go"),
+			}
+		})
+
+		newPort := func(name string, bridges ...string) *pb.BridgePort {
+			// Each port is on a VF of its own
+			if _, ok := macs[name]; !ok {
+				macs[name] = []byte{0x00, byte(8 + len(macs)), 0x00, 0x00, 0x03, 0x14}
+			}
+			return &pb.BridgePort{
+				Name: name,
+				Spec: &pb.BridgePortSpec{
+					MacAddress:     macs[name],
+					LogicalBridges: bridges,
+				},
+			}
+		}
+		createPort := func(name string, bridges ...string) error {
+			_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: newPort(name, bridges...)})
+			return err
+		}
+		ruleVlans := func(specs []types.FxpRuleSpec) []int {
+			vlans := []int{}
+			for _, spec := range specs {
+				vlans = append(vlans, spec.Vlan)
+			}
+			return vlans
+		}
+
+		It("should add every vlan of the port", func() {
+			Expect(createPort("router", "100", "101", "102")).To(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0", "d3.0.100", "d3.0.101", "d3.0.102"))
+			Expect(ruleVlans(p4rtClient.added)).To(Equal([]int{100, 101, 102}))
+
+			_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "router"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+			Expect(ruleVlans(p4rtClient.deleted)).To(Equal([]int{100, 101, 102}))
+		})
+
+		It("should reject invalid or repeated vlans", func() {
+			Expect(createPort("router", "100", "1")).NotTo(Succeed())
+			Expect(createPort("router", "100", "abc")).NotTo(Succeed())
+			Expect(createPort("router", "100", "100")).NotTo(Succeed())
+			Expect(ipuServer.Ports).To(BeEmpty())
+		})
+
+		It("should roll back the vlans added before a failure", func() {
+			linkSetMasterFn = func(link netlink.Link, master netlink.Link) error {
+				if link.Attrs().Name == "d3.0.102" {
+					return fmt.Errorf("fake error")
+				}
+				return nil
+			}
+			Expect(createPort("router", "100", "101", "102")).NotTo(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+			Expect(ruleVlans(p4rtClient.added)).To(Equal([]int{100, 101}))
+			Expect(ruleVlans(p4rtClient.deleted)).To(Equal([]int{100, 101}))
+			Expect(ipuServer.Ports).To(BeEmpty())
+		})
+
+		It("should keep the vlan interfaces still used by other ports", func() {
+			ipuServer.logicalBridges.set("logicalBridges/blue", &pb.LogicalBridge{
+				Name: "logicalBridges/blue",
+				Spec: &pb.LogicalBridgeSpec{VlanId: 101},
+			})
+			Expect(createPort("access", "logicalBridges/blue")).To(Succeed())
+			Expect(createPort("router", "100", "logicalBridges/blue")).To(Succeed())
+
+			_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "router"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.exists("d3.0.100")).To(BeFalse())
+			Expect(links.exists("d3.0.101")).To(BeTrue())
+		})
+
+		It("should return the ports with all their vlans", func() {
+			for i, name := range []string{"port-c", "port-a", "port-b"} {
+				Expect(createPort(name, fmt.Sprint(100+i), fmt.Sprint(200+i))).To(Succeed())
+			}
+
+			port, err := ipuServer.GetBridgePort(context.TODO(), &pb.GetBridgePortRequest{Name: "port-a"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(port.Spec.LogicalBridges).To(Equal([]string{"101", "201"}))
+			Expect(port.Status.OperStatus).To(Equal(pb.BPOperStatus_BP_OPER_STATUS_UP))
+			_, err = ipuServer.GetBridgePort(context.TODO(), &pb.GetBridgePortRequest{Name: "port-d"})
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+			_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port-d"})
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+			_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port-d", AllowMissing: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := ipuServer.ListBridgePorts(context.TODO(), &pb.ListBridgePortsRequest{PageSize: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.BridgePorts).To(HaveLen(2))
+			Expect(resp.BridgePorts[0].Name).To(Equal("port-a"))
+			Expect(resp.BridgePorts[1].Name).To(Equal("port-b"))
+			Expect(resp.BridgePorts[1].Spec.LogicalBridges).To(Equal([]string{"102", "202"}))
+			Expect(resp.NextPageToken).To(Equal("port-b"))
+
+			resp, err = ipuServer.ListBridgePorts(context.TODO(), &pb.ListBridgePortsRequest{PageSize: 2, PageToken: resp.NextPageToken})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.BridgePorts).To(HaveLen(1))
+			Expect(resp.BridgePorts[0].Name).To(Equal("port-c"))
+			Expect(resp.NextPageToken).To(BeEmpty())
+
+			resp, err = ipuServer.ListBridgePorts(context.TODO(), &pb.ListBridgePortsRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.BridgePorts).To(HaveLen(3))
+		})
+	})
+
+	Describe("s-tags", Serial, func() {
+		var (
+			ipuServer  *server
+			links      *fakeLinkStore
+			p4rtClient *mockP4rtClient
+		)
+
+		BeforeEach(func() {
+			links = newFakeLinkStore("enp0s1f0d3", "br-test")
+			linkByNameFn = links.linkByName
+			linkAddFn = links.linkAdd
+			linkDelFn = links.linkDel
+			linkSetMasterFn = fakeLinkSetMaster
+			linkSetNoMasterFn = fakeLinkSetNoMaster
+			linkSetUpFn = fakeLinkSetUp
+			linkSetDownFn = fakeLinkSetDown
+			executeScriptFn = func(string) (string, error) { return "", nil }
+			DeferCleanup(func() {
+				linkDelFn = netlink.LinkDel
+				executeScriptFn = utils.ExecuteScript
+			})
+
+			p4rtClient = &mockP4rtClient{}
+			ipuServer = &server{
+				uplinkInterface: "enp0s1f0d3",
+				stag:            200,
+				bridgeCtlr:      NewLinuxBridgeController("br-test"),
+				p4RtClient:      p4rtClient,
+				log:             log.WithField("pkg", "bridgeport_test.go"),
+			}
+		})
+
+		createPort := func(name string, bridges ...string) error {
+			_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+				Name: name,
+				Spec: &pb.BridgePortSpec{
+					// port<i> is on the VF with VSI 8+i
+					MacAddress:     []byte{0x00, 0x08 + name[len(name)-1] - '0', 0x00, 0x00, 0x03, 0x14},
+					LogicalBridges: bridges,
+				},
+			}})
+			return err
+		}
+
+		It("should create one outer vlan per s-tag", func() {
+			Expect(createPort("port0", "100")).To(Succeed())
+			Expect(createPort("port1", "100", "stag=300")).To(Succeed())
+			Expect(createPort("port2", "stag=300", "101")).To(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.200", "d3.200.100", "d3.300", "d3.300.100",
+				"d3.300.101"))
+			outer, err := links.linkByName("d3.300")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outer.(*netlink.Vlan).VlanId).To(Equal(300))
+			Expect(outer.(*netlink.Vlan).VlanProtocol).To(Equal(netlink.VLAN_PROTOCOL_8021AD))
+			Expect(p4rtClient.added).To(Equal([]types.FxpRuleSpec{
+				{MacAddr: []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, Vsi: 8, Vlan: 100, Stag: 200},
+				{MacAddr: []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, Vsi: 9, Vlan: 100, Stag: 300},
+				{MacAddr: []byte{0x00, 0x0a, 0x00, 0x00, 0x03, 0x14}, Vsi: 10, Vlan: 101, Stag: 300},
+			}))
+
+			_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.exists("d3.300.100")).To(BeFalse())
+			Expect(links.exists("d3.200.100")).To(BeTrue())
+			Expect(p4rtClient.deleted).To(Equal([]types.FxpRuleSpec{
+				{MacAddr: []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, Vsi: 9, Vlan: 100, Stag: 300},
+			}))
+
+			// The teardown removes the outer vlans of all the s-tags
+			Expect(ipuServer.teardown(context.TODO())).To(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+		})
+
+		It("should reject an invalid s-tag", func() {
+			Expect(createPort("port0", "100", "stag=4095")).NotTo(Succeed())
+			Expect(createPort("port0", "100", "stag=")).NotTo(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+		})
+	})
+
+	Describe("vlan filtering bridge", Serial, func() {
+		var (
+			ipuServer *server
+			links     *fakeLinkStore
+			trunk     map[uint16]bool
+			scripts   []string
+		)
+
+		BeforeEach(func() {
+			links = newFakeLinkStore("enp0s1f0d3", "br-test")
+			linkByNameFn = links.linkByName
+			linkAddFn = links.linkAdd
+			linkSetMasterFn = fakeLinkSetMaster
+			linkSetUpFn = fakeLinkSetUp
+			trunk = map[uint16]bool{}
+			bridgeVlanAddFn = func(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+				Expect(link.Attrs().Name).To(Equal("d3.0"))
+				Expect(untagged).To(BeFalse())
+				trunk[vid] = true
+				return nil
+			}
+			bridgeVlanListFn = func() (map[int32][]*nl.BridgeVlanInfo, error) {
+				infos := []*nl.BridgeVlanInfo{}
+				for vid := range trunk {
+					infos = append(infos, &nl.BridgeVlanInfo{Vid: vid})
+				}
+				return map[int32][]*nl.BridgeVlanInfo{0: infos}, nil
+			}
+			bridgeVlanDelFn = func(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+				delete(trunk, vid)
+				return nil
+			}
+			scripts = nil
+			executeScriptFn = func(script string) (string, error) {
+				scripts = append(scripts, script)
+				return "", nil
+			}
+			DeferCleanup(func() {
+				bridgeVlanAddFn = netlink.BridgeVlanAdd
+				bridgeVlanListFn = netlink.BridgeVlanList
+				bridgeVlanDelFn = netlink.BridgeVlanDel
+				executeScriptFn = utils.ExecuteScript
+			})
+
+			ipuServer = &server{
+				uplinkInterface: "enp0s1f0d3",
+				bridgeCtlr:      NewLinuxVlanBridgeController("br-test"),
+				bridgeType:      types.LinuxVlanBridge,
+				p4RtClient:      &mockP4rtClient{},
+				log:             log.WithField("pkg", "bridgeport_test.go"),
+			}
+		})
+
+		createPort := func(name string, vsi byte, vlan string) {
+			_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+				Name: name,
+				Spec: &pb.BridgePortSpec{
+					MacAddress:     []byte{0x00, vsi, 0x00, 0x00, 0x03, 0x14},
+					LogicalBridges: []string{vlan},
+				},
+			}})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		deletePort := func(name string) {
+			_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("should add the vlans to the trunk port instead of creating vlan interfaces", func() {
+			ipuServer.logicalBridges.set("logicalBridges/blue", &pb.LogicalBridge{
+				Name: "logicalBridges/blue",
+				Spec: &pb.LogicalBridgeSpec{VlanId: 100},
+			})
+			createPort("port0", 1, "logicalBridges/blue")
+			createPort("port1", 2, "logicalBridges/blue")
+			createPort("port2", 3, "200")
+
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+			Expect(trunk).To(Equal(map[uint16]bool{100: true, 200: true}))
+			Expect(scripts).To(Equal([]string{"bridge link set dev d3.0 learning off hairpin on"}))
+
+			// A vlan is only removed from the trunk with its last port
+			deletePort("port2")
+			deletePort("port0")
+			Expect(trunk).To(Equal(map[uint16]bool{100: true}))
+			deletePort("port1")
+			Expect(trunk).To(BeEmpty())
+			Expect(ipuServer.Ports).To(BeEmpty())
+		})
+	})
 })
+
+// fakeLinkStore is a thread-safe fake of the netlink links, for the concurrency tests
+type fakeLinkStore struct {
+	mu      sync.Mutex
+	links   map[string]netlink.Link
+	addCnts map[string]int
+}
+
+func newFakeLinkStore(uplink, bridge string) *fakeLinkStore {
+	f := &fakeLinkStore{links: map[string]netlink.Link{}, addCnts: map[string]int{}}
+	up := &netlink.Device{}
+	up.Name, up.Index = uplink, 1
+	br := &netlink.Bridge{}
+	br.Name, br.Index = bridge, 2
+	f.links[uplink], f.links[bridge] = up, br
+	return f
+}
+
+func (f *fakeLinkStore) linkByName(name string) (netlink.Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if link, ok := f.links[name]; ok {
+		return link, nil
+	}
+	return nil, fmt.Errorf("link %s not found", name)
+}
+
+func (f *fakeLinkStore) linkAdd(link netlink.Link) error {
+	// Widen the window between a lookup and the add, as a netlink round trip would
+	time.Sleep(time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := link.Attrs().Name
+	if _, ok := f.links[name]; ok {
+		return syscall.EEXIST
+	}
+	f.links[name] = link
+	f.addCnts[name]++
+	return nil
+}
+
+func (f *fakeLinkStore) linkDel(link netlink.Link) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := link.Attrs().Name
+	if _, ok := f.links[name]; !ok {
+		return syscall.ENODEV
+	}
+	delete(f.links, name)
+	return nil
+}
+
+func (f *fakeLinkStore) added(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addCnts[name]
+}
+
+func (f *fakeLinkStore) exists(name string) bool {
+	_, err := f.linkByName(name)
+	return err == nil
+}
+
+func (f *fakeLinkStore) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.links))
+	for name := range f.links {
+		names = append(names, name)
+	}
+	return names
+}
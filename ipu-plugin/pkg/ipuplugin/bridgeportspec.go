@@ -0,0 +1,216 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Abstract the IMC VSI table query for unit tests
+var imcVsiForMacFn = utils.ImcQueryfindVsiGivenMacAddr
+
+// bridgePortSpec is the validated spec of a BridgePort
+type bridgePortSpec struct {
+	mac net.HardwareAddr
+	// vsi is the VSI of the VF with the BridgePort MAC address
+	vsi   int
+	stag  int
+	vlans []int
+	// ownVlans are the vlans given by id rather than by a LogicalBridge, which no other BridgePort may use
+	ownVlans []int
+	// autoVlan tells that the BridgePort gives no vlan, one is allocated to it
+	autoVlan bool
+	qos      portQos
+}
+
+// validateBridgePort checks a BridgePort to create and resolves its VSI. The invalid fields are returned as the
+// BadRequest details of an InvalidArgument status, a logical bridge which does not exist as NotFound and a QoS
+// which can't be applied as FailedPrecondition.
+func (s *server) validateBridgePort(ctx context.Context, port *pb.BridgePort) (*bridgePortSpec, error) {
+	if port == nil {
+		return nil, invalidArgument(fieldViolation("bridge_port", "bridge port is not provided"))
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	if port.Name == "" {
+		violations = append(violations, fieldViolation("bridge_port.name", "bridge port name is not provided"))
+	}
+	mac := net.HardwareAddr(port.Spec.GetMacAddress())
+	spec := &bridgePortSpec{mac: mac}
+	if err := validateMacAddress(mac); err != nil {
+		violations = append(violations, fieldViolation("bridge_port.spec.mac_address", err.Error()))
+	} else if spec.vsi = s.resolveVsi(ctx, mac); spec.vsi < 1 {
+		violations = append(violations, fieldViolation("bridge_port.spec.mac_address",
+			fmt.Sprintf("mac address %s is not in the IMC VSI table and its second octet is not a valid VSI", mac)))
+	}
+
+	bridges := port.Spec.GetLogicalBridges()
+	var err error
+	// Without a vlan, one is allocated once the port is known not to exist yet
+	spec.autoVlan = !hasVlans(bridges)
+	spec.ownVlans = getOwnVlans(bridges)
+	if !spec.autoVlan {
+		spec.vlans, err = s.getVlanIDs(bridges)
+		if status.Code(err) == codes.NotFound {
+			return nil, err
+		}
+		if err != nil {
+			violations = append(violations, fieldViolation("bridge_port.spec.logical_bridges", err.Error()))
+		} else {
+			// The vlans of the LogicalBridges were checked when they were created
+			for _, vlan := range spec.ownVlans {
+				if err := s.vlanAlloc.check(vlan); err != nil {
+					violations = append(violations, fieldViolation("bridge_port.spec.logical_bridges", err.Error()))
+				}
+			}
+		}
+	}
+	if spec.stag, err = s.getStag(bridges); err != nil {
+		violations = append(violations, fieldViolation("bridge_port.spec.logical_bridges", err.Error()))
+	}
+	// The QoS is given by the request metadata as the BridgePort has no field for it
+	var qosViolations []*errdetails.BadRequest_FieldViolation
+	spec.qos, qosViolations = getQos(ctx)
+	violations = append(violations, qosViolations...)
+	if len(violations) > 0 {
+		return nil, invalidArgument(violations...)
+	}
+	if err := s.checkQos(spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// getOwnVlans returns the vlans of the logical bridges given by id, e.g.; "100", rather than by the name of a
+// LogicalBridge. The invalid ones are left out.
+func getOwnVlans(bridges []string) []int {
+	var vlans []int
+	for _, bridge := range bridges {
+		if strings.HasPrefix(bridge, stagPrefix) || strings.HasPrefix(bridge, logicalBridgePrefix) {
+			continue
+		}
+		if vlan, err := strconv.Atoi(bridge); err == nil {
+			vlans = append(vlans, vlan)
+		}
+	}
+	return vlans
+}
+
+// claimKeys returns the keys of the claimLocks of the VSI and the vlans the BridgePort claims, in lock order
+func (spec *bridgePortSpec) claimKeys() []string {
+	keys := []string{fmt.Sprintf("vsi/%d", spec.vsi)}
+	for _, vlan := range spec.ownVlans {
+		keys = append(keys, fmt.Sprintf("vlan/%d/%d", spec.stag, vlan))
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// checkClaims checks that no other BridgePort than name has the MAC address, the VSI or one of the vlans given
+// by id of the BridgePort to create. BridgePorts share a vlan by referring to the same LogicalBridge.
+func (s *server) checkClaims(name string, spec *bridgePortSpec) error {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	for other, port := range s.Ports {
+		if other == name {
+			continue
+		}
+		otherSpec := port.Spec
+		if bytes.Equal(otherSpec.MacAddress, spec.mac) {
+			return alreadyExists(bridgePortResource, other,
+				fmt.Sprintf("mac address %s is already used by bridge port %s", spec.mac, other))
+		}
+		if vsi, ok := s.vsis[other]; ok && vsi == spec.vsi {
+			return alreadyExists(bridgePortResource, other,
+				fmt.Sprintf("VSI %d of mac address %s is already used by bridge port %s", spec.vsi, spec.mac, other))
+		}
+		if stag, err := s.getStag(otherSpec.LogicalBridges); err != nil || stag != spec.stag {
+			continue
+		}
+		for _, vlan := range getOwnVlans(otherSpec.LogicalBridges) {
+			if slices.Contains(spec.ownVlans, vlan) {
+				return alreadyExists(bridgePortResource, other, fmt.Sprintf(
+					"vlan %d is already used by bridge port %s, a vlan is shared through a LogicalBridge", vlan, other))
+			}
+		}
+	}
+	return nil
+}
+
+// specDiff returns the fields which differ between the stored spec of a BridgePort and the requested one, e.g.;
+// `logical_bridges: ["100"] -> ["101"]`, or "" when they are the same
+func specDiff(stored, requested *pb.BridgePortSpec) string {
+	var diffs []string
+	if stored.GetPtype() != requested.GetPtype() {
+		diffs = append(diffs, fmt.Sprintf("ptype: %s -> %s", stored.GetPtype(), requested.GetPtype()))
+	}
+	if !bytes.Equal(stored.GetMacAddress(), requested.GetMacAddress()) {
+		diffs = append(diffs, fmt.Sprintf("mac_address: %s -> %s", net.HardwareAddr(stored.GetMacAddress()),
+			net.HardwareAddr(requested.GetMacAddress())))
+	}
+	if !slices.Equal(stored.GetLogicalBridges(), requested.GetLogicalBridges()) {
+		diffs = append(diffs, fmt.Sprintf("logical_bridges: %q -> %q", stored.GetLogicalBridges(),
+			requested.GetLogicalBridges()))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// validateMacAddress checks that mac is the full unicast MAC address of a VF
+func validateMacAddress(mac net.HardwareAddr) error {
+	if len(mac) != 6 {
+		return fmt.Errorf("invalid mac address %v, a mac address has 6 bytes", []byte(mac))
+	}
+	if mac[0]&0x01 != 0 {
+		return fmt.Errorf("invalid mac address %s, a multicast address cannot be a VF address", mac)
+	}
+	if strings.Trim(string(mac), "\x00") == "" {
+		return fmt.Errorf("invalid mac address %s", mac)
+	}
+	return nil
+}
+
+// resolveVsi returns the VSI of the VF with the MAC address mac from the IMC VSI table. When the IMC does not
+// know the address, or cannot be reached, the VSI is taken from the second octet of the address, e.g.; 0x08 for
+// 00:08:00:00:03:14.
+func (s *server) resolveVsi(ctx context.Context, mac net.HardwareAddr) int {
+	logger := tracing.Logger(ctx).WithField("mac", mac.String())
+	out, err := imcVsiForMacFn(s.mode, mac.String())
+	if err == nil {
+		vsi, parseErr := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(out), "0x"), 16, 32)
+		if parseErr == nil && vsi > 0 {
+			if int(vsi) != int(mac[1]) {
+				logger.WithField("vsi", vsi).Warn("the VSI of the IMC VSI table is not the second octet of the mac address")
+			}
+			return int(vsi)
+		}
+		err = fmt.Errorf("invalid VSI %q in the IMC VSI table", out)
+	}
+	logger.WithFields(log.Fields{"error": err, "vsi": mac[1]}).
+		Info("unable to find the VSI in the IMC VSI table, using the second octet of the mac address")
+	return int(mac[1])
+}
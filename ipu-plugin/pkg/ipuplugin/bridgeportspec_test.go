@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("bridge port validation", Serial, func() {
+	var (
+		ipuServer  *server
+		p4rtClient *mockP4rtClient
+	)
+
+	BeforeEach(func() {
+		links := newFakeLinkStore("enp0s1f0d3", "br-test")
+		linkByNameFn = links.linkByName
+		linkAddFn = links.linkAdd
+		linkDelFn = links.linkDel
+		linkSetMasterFn = fakeLinkSetMaster
+		linkSetNoMasterFn = fakeLinkSetNoMaster
+		linkSetUpFn = fakeLinkSetUp
+		linkSetDownFn = fakeLinkSetDown
+		executeScriptFn = func(string) (string, error) { return "", nil }
+		imcVsiFn := imcVsiForMacFn
+		DeferCleanup(func() {
+			linkDelFn = netlink.LinkDel
+			executeScriptFn = utils.ExecuteScript
+			imcVsiForMacFn = imcVsiFn
+		})
+
+		p4rtClient = &mockP4rtClient{}
+		ipuServer = &server{
+			uplinkInterface: "enp0s1f0d3",
+			bridgeCtlr:      NewLinuxBridgeController("br-test"),
+			p4RtClient:      p4rtClient,
+			log:             log.WithField("pkg", "bridgeportspec_test.go"),
+		}
+	})
+
+	createPort := func(name string, mac []byte, bridges ...string) error {
+		_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+			Name: name,
+			Spec: &pb.BridgePortSpec{MacAddress: mac, LogicalBridges: bridges},
+		}})
+		return err
+	}
+	violatedFields := func(err error) []string {
+		fields := []string{}
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		return fields
+	}
+
+	It("should reject the mac addresses which are not a full unicast address", func() {
+		for _, mac := range [][]byte{
+			{0x00, 0x08},
+			{0x00, 0x08, 0x00, 0x00, 0x03, 0x14, 0x00},
+			{0x01, 0x08, 0x00, 0x00, 0x03, 0x14},
+			{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		} {
+			err := createPort("port0", mac, "100")
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument), "mac %v", mac)
+			Expect(violatedFields(err)).To(Equal([]string{"bridge_port.spec.mac_address"}), "mac %v", mac)
+		}
+		Expect(p4rtClient.added).To(BeEmpty())
+	})
+
+	It("should report all the invalid fields", func() {
+		err := createPort("", []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x14}, "1", "stag=5000")
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(violatedFields(err)).To(Equal([]string{"bridge_port.name", "bridge_port.spec.mac_address",
+			"bridge_port.spec.logical_bridges", "bridge_port.spec.logical_bridges"}))
+	})
+
+	It("should take the VSI from the IMC VSI table", func() {
+		imcVsiForMacFn = func(_ string, mac string) (string, error) {
+			Expect(mac).To(Equal("00:08:00:00:03:14"))
+			return "0x15\n", nil
+		}
+		Expect(createPort("port0", []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, "100")).To(Succeed())
+		Expect(p4rtClient.added).To(HaveLen(1))
+		Expect(p4rtClient.added[0].Vsi).To(Equal(0x15))
+
+		// The port is deleted with the VSI it was created with
+		imcVsiForMacFn = func(string, string) (string, error) { return "0x16", nil }
+		_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p4rtClient.deleted).To(HaveLen(1))
+		Expect(p4rtClient.deleted[0].Vsi).To(Equal(0x15))
+	})
+
+	It("should fall back to the second octet of the mac address", func() {
+		Expect(createPort("port0", []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, "100")).To(Succeed())
+		Expect(p4rtClient.added[0].Vsi).To(Equal(8))
+
+		err := createPort("port1", []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x14}, "100")
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(violatedFields(err)).To(Equal([]string{"bridge_port.spec.mac_address"}))
+	})
+
+	It("should return the port created by an identical request and reject another spec", func() {
+		mac := []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}
+		Expect(createPort("port0", mac, "100")).To(Succeed())
+		Expect(createPort("port0", mac, "100")).To(Succeed())
+		Expect(p4rtClient.added).To(HaveLen(1))
+
+		err := createPort("port0", []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, "101")
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		Expect(status.Convert(err).Message()).To(HaveSuffix(
+			`mac_address: 00:08:00:00:03:14 -> 00:09:00:00:03:14, logical_bridges: ["100"] -> ["101"]`))
+		Expect(p4rtClient.added).To(HaveLen(1))
+	})
+
+	It("should reject a port claiming the VF or a vlan of another port", func() {
+		Expect(createPort("port0", []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, "100")).To(Succeed())
+
+		err := createPort("port1", []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, "101")
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		Expect(status.Convert(err).Message()).To(ContainSubstring("mac address 00:08:00:00:03:14 is already used"))
+		err = createPort("port1", []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, "100")
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		Expect(status.Convert(err).Message()).To(ContainSubstring("vlan 100 is already used by bridge port port0"))
+
+		// The vlan of another s-tag is another vlan
+		Expect(createPort("port1", []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, "100", "stag=300")).To(Succeed())
+
+		imcVsiForMacFn = func(string, string) (string, error) { return "0x08", nil }
+		err = createPort("port2", []byte{0x00, 0x0a, 0x00, 0x00, 0x03, 0x14}, "102")
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+		Expect(status.Convert(err).Message()).To(ContainSubstring("VSI 8 of mac address 00:0a:00:00:03:14 is already used"))
+		Expect(ipuServer.Ports).To(HaveLen(2))
+	})
+
+	It("should let the ports share the vlan of a logical bridge", func() {
+		ipuServer.logicalBridges.set("logicalBridges/blue", &pb.LogicalBridge{
+			Name: "logicalBridges/blue",
+			Spec: &pb.LogicalBridgeSpec{VlanId: 100},
+		})
+		Expect(createPort("port0", []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}, "logicalBridges/blue")).To(Succeed())
+		Expect(createPort("port1", []byte{0x00, 0x09, 0x00, 0x00, 0x03, 0x14}, "logicalBridges/blue")).To(Succeed())
+	})
+})
@@ -2,16 +2,23 @@ package ipuplugin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/openshift/dpu-operator/dpu-api/gen"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
@@ -19,43 +26,119 @@ import (
 
 type DevicePluginService struct {
 	pb.UnimplementedDeviceServiceServer
-	mode string
+	mode    string
+	watcher *DeviceWatcher
+	// vfWatcher resyncs the FXP rules of the VFs once SetNumVfs changed them, nil when the plugin does not
+	// watch the VFs
+	vfWatcher *VfWatcher
+	poolsMu   sync.RWMutex
+	pools     map[string]*resourcePool
 }
 
 var (
-	exclude          = []string{"enp0s1f0", "enp0s1f0d1", "enp0s1f0d2", "enp0s1f0d3", "enp0s1f0d4"}
+	// DefaultExcludedInterfaces are the ACC interfaces used by the IPU infrastructure which are never exposed as devices
+	DefaultExcludedInterfaces = []string{"enp0s1f0", "enp0s1f0d1", "enp0s1f0d2", "enp0s1f0d3", "enp0s1f0d4"}
+
 	sysClassNet      = "/sys/class/net"
 	sysBusPciDevices = "/sys/bus/pci/devices"
 	deviceCode       = "0x1452"
 	deviceCodeVf     = "0x145c"
 	intelVendor      = "0x8086"
-	maxVfsSupported  = 64
 )
 
-func NewDevicePluginService(mode string) *DevicePluginService {
-	return &DevicePluginService{mode: mode}
+const (
+	// gRPC metadata used to select the PFs a SetNumVfs request applies to; a PCI address or netdev
+	// name, several PFs can be given comma separated.
+	pfSelectorKey = "ipu-pf"
+	// gRPC response header carrying the JSON encoded result of a SetNumVfs request, one value per PF; it is
+	// the only place the errors of the failed PFs are returned when some other PFs succeeded
+	pfResultKey = "ipu-pf-result"
+	// gRPC metadata used to select the resource pool a GetDevices request applies to
+	resourcePoolKey = "ipu-resource-pool"
+	// gRPC response header carrying the JSON encoded details of each device returned by GetDevices
+	deviceInfoKey = "ipu-device-info"
+)
+
+// NewDevicePluginService returns the DeviceService serving the devices known by watcher, pools are the
+// resource pools that can be requested through the "ipu-resource-pool" request metadata.
+func NewDevicePluginService(mode string, watcher *DeviceWatcher, pools []ResourcePool) (*DevicePluginService, error) {
+	resPools, err := newResourcePools(pools)
+	if err != nil {
+		return nil, err
+	}
+	return &DevicePluginService{mode: mode, watcher: watcher, pools: resPools}, nil
 }
 
-func (s *DevicePluginService) GetDevices(context.Context, *pb.Empty) (*pb.DeviceListResponse, error) {
+// setResourcePools replaces the resource pools, they are left unchanged when pools is invalid
+func (s *DevicePluginService) setResourcePools(pools []ResourcePool) error {
+	resPools, err := newResourcePools(pools)
+	if err != nil {
+		return err
+	}
+	s.poolsMu.Lock()
+	defer s.poolsMu.Unlock()
+	s.pools = resPools
+	return nil
+}
+
+// GetDevices returns the devices of the resource pool requested with the "ipu-resource-pool" metadata, or all
+// the devices when no pool is requested. The details of each device are returned in the "ipu-device-info" header.
+func (s *DevicePluginService) GetDevices(ctx context.Context, _ *pb.Empty) (*pb.DeviceListResponse, error) {
+	logger := tracing.Logger(ctx)
+
+	var pool *resourcePool
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if poolNames := md.Get(resourcePoolKey); len(poolNames) > 0 {
+			s.poolsMu.RLock()
+			pool, ok = s.pools[poolNames[0]]
+			s.poolsMu.RUnlock()
+			if !ok {
+				return &pb.DeviceListResponse{}, invalidArgument(fieldViolation(resourcePoolKey,
+					fmt.Sprintf("unknown resource pool %s", poolNames[0])))
+			}
+		}
+	}
 
-	devices, err := discoverHostDevices(s.mode)
+	infos, err := s.watcher.Devices()
 	if err != nil {
-		return &pb.DeviceListResponse{}, err
+		return &pb.DeviceListResponse{}, internalError(reasonHostConfig, err)
+	}
+
+	devices := make(map[string]*pb.Device)
+	hdr := metadata.MD{}
+	for name, info := range infos {
+		if pool != nil && !pool.matches(info) {
+			continue
+		}
+		devices[name] = &pb.Device{ID: name, Health: pluginapi.Healthy, Topology: getTopologyInfo(info.NumaNode)}
+		if infoJson, err := json.Marshal(info); err == nil {
+			hdr.Append(deviceInfoKey, string(infoJson))
+		}
+	}
+	if err := grpc.SetHeader(ctx, hdr); err != nil {
+		logger.Debugf("GetDevices unable to set device info header: %v\n", err)
+	}
+	healthCounts := map[string]int{pluginapi.Healthy: 0, pluginapi.Unhealthy: 0}
+	for _, dev := range devices {
+		healthCounts[dev.Health]++
+	}
+	for health, cnt := range healthCounts {
+		metrics.Devices.WithLabelValues(health).Set(float64(cnt))
 	}
 
 	response := &pb.DeviceListResponse{
 		Devices: devices,
 	}
 
-	log.Debugf("GetDevices, response->%v\n", response)
+	logger.Debugf("GetDevices, response->%v\n", response)
 	return response, nil
 }
 
 // Note: This function below was taken from open-source sriov plugin, which
 // is also under the same apache license, with few additional changes.
-// Returns a List containing PCI addr for all VF discovered in a given PF
-func GetVFList(pf string) (vfList []string, err error) {
-	vfList = make([]string, 0)
+// Returns a List containing PCI addr, NUMA node and local CPUs for all VF discovered in a given PF
+func GetVFList(pf string) (vfList []VfTopology, err error) {
+	vfList = make([]VfTopology, 0)
 	pfDir := filepath.Join(sysBusPciDevices, pf)
 	_, err = os.Lstat(pfDir)
 	if err != nil {
@@ -78,8 +161,11 @@ func GetVFList(pf string) (vfList []string, err error) {
 		if err == nil && (dirInfo.Mode()&os.ModeSymlink != 0) {
 			linkName, err := filepath.EvalSymlinks(dir)
 			if err == nil {
-				vfLink := filepath.Base(linkName)
-				vfList = append(vfList, vfLink)
+				vfList = append(vfList, VfTopology{
+					PciAddr:   filepath.Base(linkName),
+					NumaNode:  getPciNumaNode(linkName),
+					LocalCpus: getPciLocalCpus(linkName),
+				})
 			}
 		}
 	}
@@ -97,69 +183,57 @@ func GetVfDeviceCount(pciAddr string) (int, error) {
 	return length, nil
 }
 
-// Recommended to wait upto 2 seconds, for the max VFs(64) currently supported.
-func pollToCheckVfDevices(pciAddr string, count int) error {
-	var err error
-	cnt := 0
-	ticker := time.NewTicker(time.Second / 3)
-	done := make(chan bool, 1)
-
-	go func() {
-		for _ = range ticker.C {
-			cnt, err = GetVfDeviceCount(pciAddr)
-			if cnt == count && err == nil {
-				ticker.Stop()
-				break
-			}
-		}
-		done <- true
-	}()
-
-	timer := time.NewTimer(time.Second * 2)
-	select {
-	case <-done:
-		log.Debugf("ticker Done")
-		timer.Stop()
-	case <-timer.C:
-		log.Debugf("timer.C Done")
-		ticker.Stop()
+// GetTotalVfs returns the maximum number of VFs the PF supports, as reported by sriov_totalvfs
+func GetTotalVfs(pciAddr string) (int32, error) {
+	totalVfsByte, err := os.ReadFile(filepath.Join(sysBusPciDevices, pciAddr, "sriov_totalvfs"))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read sriov_totalvfs for PF %s: %v", pciAddr, err)
 	}
-
-	if cnt == count && err == nil {
-		log.Debugf("pollToCheckVfDevices: match expected count, %v\n", cnt)
-		return nil
+	totalVfs, err := strconv.Atoi(strings.TrimSpace(string(totalVfsByte)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid sriov_totalvfs for PF %s: %v", pciAddr, err)
 	}
-	log.Debugf("pollToCheckVfDevices: Timed out:attempt->%v, result->%v, err->%v\n", count, cnt, err)
-	return fmt.Errorf("pollToCheckVfDevices: Timed out:attempt->%v, result->%v, err->%v\n", count, cnt, err)
+	return int32(totalVfs), nil
 }
 
-func SetNumSriovVfs(mode string, pciAddr string, vfCnt int32) error {
+// SetNumSriovVfs sets vfCnt VFs on PF pciAddr and waits for them to be created with watcher.
+func SetNumSriovVfs(mode string, pciAddr string, vfCnt int32, watcher *DeviceWatcher) error {
+
+	totalVfs, err := GetTotalVfs(pciAddr)
+	if err != nil {
+		return fmt.Errorf("SetNumSriovVfs(): %v", err)
+	}
 
-	//Note: Upto 64 VFs have been validated.
-	if vfCnt <= 0 || vfCnt > int32(maxVfsSupported) {
-		return fmt.Errorf("SetNumSriovVfs(): Invalid/unsupported, vfCnt->%v \n", vfCnt)
+	// A count of 0 resets the PF, i.e.; removes all of its VFs
+	if vfCnt < 0 || vfCnt > totalVfs {
+		return fmt.Errorf("SetNumSriovVfs(): Invalid/unsupported, vfCnt->%v, PF %s supports up to %v VFs\n", vfCnt, pciAddr, totalVfs)
 	}
 
 	pathToNumVfsFile := filepath.Join(sysBusPciDevices, pciAddr, "sriov_numvfs")
 
 	//Need to first write 0 for num of VFs, before updating it.
-	err := os.WriteFile(pathToNumVfsFile, []byte("0"), 0644)
+	err = os.WriteFile(pathToNumVfsFile, []byte("0"), 0644)
 	if err != nil {
 		return fmt.Errorf("SetNumSriovVfs(): reset fail %s: %v", pathToNumVfsFile, err)
 	}
 	zeroVfs := 0
-	err = pollToCheckVfDevices(pciAddr, zeroVfs)
+	err = watcher.WaitForVfs(pciAddr, zeroVfs)
 	if err != nil {
 		return fmt.Errorf("cli-client query failed count->%v, err->%v\n", zeroVfs, err)
 	}
 
+	if vfCnt == 0 {
+		log.Debugf("SetNumSriovVfs(): reset file->%s, sriov_numvfs to 0\n", pathToNumVfsFile)
+		return nil
+	}
+
 	// Note: Post-writing, it can take some time for the VFs to be created.
 	err = os.WriteFile(pathToNumVfsFile, []byte(strconv.Itoa(int(vfCnt))), 0644)
 	if err != nil {
 		return fmt.Errorf("SetNumSriovVfs():error in updating %s: %v", pathToNumVfsFile, err)
 	}
 
-	err = pollToCheckVfDevices(pciAddr, int(vfCnt))
+	err = watcher.WaitForVfs(pciAddr, int(vfCnt))
 	if err != nil {
 		return fmt.Errorf("cli-client query failed count->%v, err->%v\n", vfCnt, err)
 	}
@@ -169,20 +243,48 @@ func SetNumSriovVfs(mode string, pciAddr string, vfCnt int32) error {
 	return nil
 }
 
-func SetNumVfs(mode string, numVfs int32) (int32, error) {
-	deviceVfsSet := false
+// PfVfResult is the outcome of a SetNumVfs request for one PF
+type PfVfResult struct {
+	PciAddr  string
+	NetDev   string
+	NumVfs   int32
+	TotalVfs int32
+	Err      error
+}
 
-	if mode != types.HostMode {
-		return 0, fmt.Errorf("setNumVfs(): only supported on host: mode %s\n", mode)
+func (r PfVfResult) String() string {
+	res := fmt.Sprintf("pf=%s netdev=%s vfs=%d totalvfs=%d", r.PciAddr, r.NetDev, r.NumVfs, r.TotalVfs)
+	if r.Err != nil {
+		res += fmt.Sprintf(" error=%q", r.Err.Error())
 	}
+	return res
+}
+
+// pfResultInfo is the JSON encoding of a PfVfResult in the pfResultKey response header
+type pfResultInfo struct {
+	PciAddr  string `json:"pciAddress"`
+	NetDev   string `json:"netdev,omitempty"`
+	NumVfs   int32  `json:"numVfs"`
+	TotalVfs int32  `json:"totalVfs,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
 
-	log.Debugf("setNumVfs(): requested num of VFs->%v\n", numVfs)
+func (r PfVfResult) info() pfResultInfo {
+	info := pfResultInfo{PciAddr: r.PciAddr, NetDev: r.NetDev, NumVfs: r.NumVfs, TotalVfs: r.TotalVfs}
+	if r.Err != nil {
+		info.Error = r.Err.Error()
+	}
+	return info
+}
 
+// getIdpfPFs returns the PCI addresses of all the IPU PFs on the host
+func getIdpfPFs() ([]string, error) {
 	files, err := os.ReadDir(sysBusPciDevices)
 	if err != nil {
-		return 0, fmt.Errorf("setNumVfs(): error-> %v\n", err)
+		return nil, fmt.Errorf("getIdpfPFs(): error-> %v\n", err)
 	}
 
+	pfs := []string{}
 	for _, file := range files {
 		deviceByte, err := os.ReadFile(filepath.Join(sysBusPciDevices, file.Name(), "device"))
 		if err != nil {
@@ -200,65 +302,199 @@ func SetNumVfs(mode string, numVfs int32) (int32, error) {
 		vendorId := strings.TrimSpace(string(vendorByte))
 
 		if deviceId == deviceCode && vendorId == intelVendor {
-			err = SetNumSriovVfs(mode, file.Name(), numVfs)
+			pfs = append(pfs, file.Name())
+		}
+	}
+	return pfs, nil
+}
+
+// getPfNetDev returns the first netdev name of a PCI device, or an empty string if it has none
+func getPfNetDev(pciAddr string) string {
+	netDirs, err := os.ReadDir(filepath.Join(sysBusPciDevices, pciAddr, "net"))
+	if err != nil || len(netDirs) == 0 {
+		return ""
+	}
+	return netDirs[0].Name()
+}
+
+// resolvePf maps a PF given either by PCI address or by netdev name to its PCI address,
+// making sure it is one of the IPU PFs.
+func resolvePf(target string, pfs []string) (string, error) {
+	pciAddr := target
+	if !slices.Contains(pfs, pciAddr) {
+		devLink, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, target, "device"))
+		if err != nil {
+			return "", fmt.Errorf("%s is neither an IPU PF PCI address nor a netdev: %v", target, err)
+		}
+		pciAddr = filepath.Base(devLink)
+	}
+	if !slices.Contains(pfs, pciAddr) {
+		return "", fmt.Errorf("%s (%s) is not an IPU PF with device code %s", target, pciAddr, deviceCode)
+	}
+	return pciAddr, nil
+}
+
+// SetNumVfs sets numVfs VFs on each of the targeted PFs, given by PCI address or netdev name. When no target
+// is given, only the first IPU PF on the host is configured. A count of 0 removes all the VFs of the PFs. The
+// errors are gRPC statuses: InvalidArgument for an unknown PF or a count above the VFs a PF supports, Internal
+// with the error of each failed PF in the ErrorInfo metadata otherwise.
+func SetNumVfs(mode string, numVfs int32, targets []string, watcher *DeviceWatcher) ([]PfVfResult, error) {
+
+	if mode != types.HostMode {
+		return nil, failedPrecondition("MODE", "mode", fmt.Sprintf("setNumVfs(): only supported on host: mode %s", mode))
+	}
+
+	log.Debugf("setNumVfs(): requested num of VFs->%v, on PFs->%v\n", numVfs, targets)
+
+	pfs, err := getIdpfPFs()
+	if err != nil {
+		return nil, internalError(reasonHostConfig, fmt.Errorf("setNumVfs(): %v", err))
+	}
+	if len(pfs) == 0 {
+		return nil, failedPrecondition("PF", deviceCode,
+			fmt.Sprintf("setNumVfs(): unable to set VFs for device->%s, no PF found", deviceCode))
+	}
+
+	pciAddrs := pfs[:1]
+	if len(targets) > 0 {
+		pciAddrs = make([]string, 0, len(targets))
+		for _, target := range targets {
+			pciAddr, err := resolvePf(target, pfs)
 			if err != nil {
-				return 0, fmt.Errorf("setNumVfs(): error from SetSriovNumVfs-> %v", err)
+				return nil, invalidArgument(fieldViolation(pfSelectorKey, fmt.Sprintf("setNumVfs(): %v", err)))
+			}
+			pciAddrs = append(pciAddrs, pciAddr)
+		}
+	}
+
+	results := make([]PfVfResult, 0, len(pciAddrs))
+	failed := []string{}
+	failedErrs := map[string]string{}
+	// tooMany counts the PFs which failed because they do not support that many VFs
+	tooMany := 0
+	for _, pciAddr := range pciAddrs {
+		res := PfVfResult{PciAddr: pciAddr, NetDev: getPfNetDev(pciAddr)}
+		res.TotalVfs, _ = GetTotalVfs(pciAddr)
+		if res.Err = SetNumSriovVfs(mode, pciAddr, numVfs, watcher); res.Err != nil {
+			failed = append(failed, pciAddr)
+			failedErrs[pciAddr] = res.Err.Error()
+			if res.TotalVfs > 0 && numVfs > res.TotalVfs {
+				tooMany++
 			}
-			deviceVfsSet = true
-			break
+		} else {
+			res.NumVfs = numVfs
 		}
+		results = append(results, res)
+	}
+
+	if len(failed) > 0 && tooMany == len(failed) {
+		return results, invalidArgument(fieldViolation("vf_cnt",
+			fmt.Sprintf("setNumVfs(): %d VFs is more than PFs->%v support", numVfs, failed)))
+	}
+	if len(failed) > 0 {
+		return results, newStatus(codes.Internal, fmt.Sprintf("setNumVfs(): unable to set VFs for PFs->%v", failed),
+			&errdetails.ErrorInfo{Reason: reasonHostConfig, Domain: errorDomain, Metadata: failedErrs})
+	}
+	return results, nil
+}
+
+// getPfTargets returns the PFs requested through the pfSelectorKey gRPC metadata, if any
+func getPfTargets(ctx context.Context) []string {
+	targets := []string{}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return targets
 	}
-	if deviceVfsSet == true {
-		return numVfs, nil
-	} else {
-		return 0, fmt.Errorf("setNumVfs(): unable to set VFs for device->%s", deviceCode)
+	for _, val := range md.Get(pfSelectorKey) {
+		for _, target := range strings.Split(val, ",") {
+			if target = strings.TrimSpace(target); target != "" {
+				targets = append(targets, target)
+			}
+		}
 	}
+	return targets
 }
 
+// SetNumVfs configures the VFs on the PFs selected with the "ipu-pf" request metadata (the first PF when not set).
+// The returned VfCount is the total number of VFs set on the PFs which were configured. The "ipu-pf-result"
+// response header is the per PF result: one JSON value per selected PF, with the error of the PFs which failed.
+// When only some of the PFs fail, the request succeeds and their errors are only reported in that header; it
+// fails when none of the PFs could be configured. Only the host side VFs are set, the ACC is not asked to
+// allocate or agree on a VF count, which is out of scope of this service.
 func (s *DevicePluginService) SetNumVfs(ctx context.Context, vfCountReq *pb.VfCount) (*pb.VfCount, error) {
-	var res *pb.VfCount
-	numVfs, err := SetNumVfs(types.HostMode, vfCountReq.VfCnt)
+	logger := tracing.Logger(ctx)
+	results, err := SetNumVfs(types.HostMode, vfCountReq.VfCnt, getPfTargets(ctx), s.watcher)
+	if len(results) > 0 {
+		// The VFs changed, update their FXP rules now rather than on the next periodic resync
+		s.vfWatcher.Trigger()
+	}
 
-	log.Debugf("setNumVfs(): requested VFs->%v, allocated VFs->%v, err->%v\n", vfCountReq.VfCnt, numVfs, err)
-	if err != nil {
-		res = &pb.VfCount{VfCnt: 0}
-	} else {
-		res = &pb.VfCount{VfCnt: numVfs}
+	logger.Debugf("setNumVfs(): requested VFs->%v, results->%v, err->%v\n", vfCountReq.VfCnt, results, err)
+	res := &pb.VfCount{}
+	configured := 0
+	for _, r := range results {
+		if r.Err == nil {
+			res.VfCnt += r.NumVfs
+			configured++
+		}
+	}
+	if err != nil && configured > 0 {
+		logger.WithField("error", err).Warnf("SetNumVfs only configured %d of %d PFs", configured, len(results))
+		err = nil
+	}
+
+	if len(results) > 0 {
+		hdr := metadata.MD{}
+		for _, r := range results {
+			infoJson, jsonErr := json.Marshal(r.info())
+			if jsonErr != nil {
+				logger.WithField("error", jsonErr).Errorf("SetNumVfs unable to encode the result of PF %s", r.PciAddr)
+				continue
+			}
+			hdr.Append(pfResultKey, string(infoJson))
+		}
+		if hdrErr := grpc.SetHeader(ctx, hdr); hdrErr != nil {
+			logger.WithField("error", hdrErr).Error("SetNumVfs unable to set the per PF results header")
+		}
 	}
 
-	log.Debugf("SetNumVfs res->%v\n", res)
+	logger.Debugf("SetNumVfs res->%v\n", res)
 	return res, err
 }
 
-func discoverHostDevices(mode string) (map[string]*pb.Device, error) {
+func discoverHostDevices(mode string, exclude []string) (map[string]*deviceInfo, error) {
 
-	devices := make(map[string]*pb.Device)
+	devices := make(map[string]*deviceInfo)
 
 	files, err := os.ReadDir(sysClassNet)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return make(map[string]*pb.Device), nil
+			return devices, nil
 		}
+		return nil, fmt.Errorf("unable to read %s: %v", sysClassNet, err)
 	}
 
 	for _, file := range files {
-		deviceCodeByte, err := os.ReadFile(filepath.Join(sysClassNet, file.Name(), "device/device"))
-		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-		}
-
-		device_code := strings.TrimSpace(string(deviceCodeByte))
-		if mode == types.IpuMode {
-			if device_code == deviceCode {
-				if !slices.Contains(exclude, file.Name()) {
-					devices[file.Name()] = &pb.Device{ID: file.Name(), Health: pluginapi.Healthy}
-				}
-			}
-		} else if mode == types.HostMode {
-			if device_code == deviceCodeVf {
-				devices[file.Name()] = &pb.Device{ID: file.Name(), Health: pluginapi.Healthy}
-			}
+		if isHostDevice(mode, exclude, file.Name()) {
+			devices[file.Name()] = getDeviceInfo(file.Name())
 		}
 	}
 	return devices, nil
 }
+
+// isHostDevice returns whether netdev is exposed as a device in the given mode
+func isHostDevice(mode string, exclude []string, netdev string) bool {
+	deviceCodeByte, err := os.ReadFile(filepath.Join(sysClassNet, netdev, "device/device"))
+	if err != nil {
+		// not a PCI netdev, e.g.; a bridge or a VLAN interface
+		return false
+	}
+
+	device_code := strings.TrimSpace(string(deviceCodeByte))
+	if mode == types.IpuMode {
+		return device_code == deviceCode && !slices.Contains(exclude, netdev)
+	} else if mode == types.HostMode {
+		return device_code == deviceCodeVf
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/openshift/dpu-operator/dpu-api/gen"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// createFakePciDevice creates a fake sysfs PCI device with an optional netdev under sysClassNet
+func createFakePciDevice(pciAddr, device, netdev string, totalVfs string) {
+	devDir := filepath.Join(sysBusPciDevices, pciAddr)
+	Expect(os.MkdirAll(devDir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(devDir, "device"), []byte(device+"\n"), 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(devDir, "vendor"), []byte(intelVendor+"\n"), 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(devDir, "numa_node"), []byte("0\n"), 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(devDir, "sriov_numvfs"), []byte("0\n"), 0644)).To(Succeed())
+	if totalVfs != "" {
+		Expect(os.WriteFile(filepath.Join(devDir, "sriov_totalvfs"), []byte(totalVfs+"\n"), 0644)).To(Succeed())
+	}
+	if netdev != "" {
+		Expect(os.MkdirAll(filepath.Join(devDir, "net", netdev), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(sysClassNet, netdev), 0755)).To(Succeed())
+		Expect(os.Symlink(devDir, filepath.Join(sysClassNet, netdev, "device"))).To(Succeed())
+	}
+}
+
+// createFakeVf creates VF vfIndex of the fake PF pfPciAddr, with a netdev and a driver
+func createFakeVf(pfPciAddr, vfPciAddr string, vfIndex int, netdev, mac, driver string) {
+	createFakePciDevice(vfPciAddr, deviceCodeVf, netdev, "")
+	pfDir := filepath.Join(sysBusPciDevices, pfPciAddr)
+	vfDir := filepath.Join(sysBusPciDevices, vfPciAddr)
+	Expect(os.Symlink(pfDir, filepath.Join(vfDir, "physfn"))).To(Succeed())
+	Expect(os.Symlink(vfDir, filepath.Join(pfDir, fmt.Sprintf("virtfn%d", vfIndex)))).To(Succeed())
+
+	driverDir := filepath.Join(filepath.Dir(sysBusPciDevices), "drivers", driver)
+	Expect(os.MkdirAll(driverDir, 0755)).To(Succeed())
+	Expect(os.Symlink(driverDir, filepath.Join(vfDir, "driver"))).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(sysClassNet, netdev, "address"), []byte(mac+"\n"), 0644)).To(Succeed())
+}
+
+// setFakeNumaNode sets the NUMA node and local CPUs of a fake PCI device
+func setFakeNumaNode(pciAddr, numaNode, localCpus string) {
+	devDir := filepath.Join(sysBusPciDevices, pciAddr)
+	Expect(os.WriteFile(filepath.Join(devDir, "numa_node"), []byte(numaNode+"\n"), 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(devDir, "local_cpulist"), []byte(localCpus+"\n"), 0644)).To(Succeed())
+}
+
+// fakeServerTransportStream captures the headers set by a service handler
+type fakeServerTransportStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (f *fakeServerTransportStream) Method() string { return "fake" }
+
+func (f *fakeServerTransportStream) SetHeader(md metadata.MD) error {
+	f.header = metadata.Join(f.header, md)
+	return nil
+}
+
+var _ = Describe("DevicePluginService", Serial, func() {
+	var origSysBusPciDevices, origSysClassNet string
+
+	BeforeEach(func() {
+		origSysBusPciDevices, origSysClassNet = sysBusPciDevices, sysClassNet
+		tmpDir := GinkgoT().TempDir()
+		sysBusPciDevices = filepath.Join(tmpDir, "bus/pci/devices")
+		sysClassNet = filepath.Join(tmpDir, "class/net")
+
+		createFakePciDevice("0000:af:00.0", deviceCode, "ens5f0", "16")
+		createFakePciDevice("0000:b0:00.0", deviceCode, "ens6f0", "8")
+		createFakePciDevice("0000:18:00.0", "0x1592", "ens1f0", "64")
+	})
+
+	AfterEach(func() {
+		sysBusPciDevices, sysClassNet = origSysBusPciDevices, origSysClassNet
+	})
+
+	Describe("SetNumVfs", func() {
+		It("should reject a count above the sriov_totalvfs of the PF", func() {
+			results, err := SetNumVfs(types.HostMode, 32, []string{"0000:af:00.0"}, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].TotalVfs).To(Equal(int32(16)))
+			Expect(results[0].Err.Error()).To(ContainSubstring("supports up to 16 VFs"))
+		})
+
+		It("should only configure the first IPU PF when no PF is targeted", func() {
+			results, err := SetNumVfs(types.HostMode, 0, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].PciAddr).To(Equal("0000:af:00.0"))
+			Expect(results[0].NetDev).To(Equal("ens5f0"))
+		})
+
+		It("should succeed with the errors of the failed PFs in the header when another PF was configured", func() {
+			// sriov_numvfs cannot be written when it is a directory
+			numVfsFile := filepath.Join(sysBusPciDevices, "0000:b0:00.0", "sriov_numvfs")
+			Expect(os.Remove(numVfsFile)).To(Succeed())
+			Expect(os.Mkdir(numVfsFile, 0755)).To(Succeed())
+
+			stream := &fakeServerTransportStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(pfSelectorKey, "ens5f0,ens6f0"))
+			svc := &DevicePluginService{mode: types.HostMode}
+			res, err := svc.SetNumVfs(ctx, &pb.VfCount{VfCnt: 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.VfCnt).To(Equal(int32(0)))
+
+			infos := stream.header.Get(pfResultKey)
+			Expect(infos).To(HaveLen(2))
+			results := []pfResultInfo{}
+			for _, infoJson := range infos {
+				info := pfResultInfo{}
+				Expect(json.Unmarshal([]byte(infoJson), &info)).To(Succeed())
+				results = append(results, info)
+			}
+			Expect(results[0]).To(Equal(pfResultInfo{PciAddr: "0000:af:00.0", NetDev: "ens5f0", TotalVfs: 16}))
+			Expect(results[1].PciAddr).To(Equal("0000:b0:00.0"))
+			Expect(results[1].Error).To(ContainSubstring("reset fail"))
+		})
+
+		It("should fail with the error of each PF when none of them was configured", func() {
+			for _, pciAddr := range []string{"0000:af:00.0", "0000:b0:00.0"} {
+				numVfsFile := filepath.Join(sysBusPciDevices, pciAddr, "sriov_numvfs")
+				Expect(os.Remove(numVfsFile)).To(Succeed())
+				Expect(os.Mkdir(numVfsFile, 0755)).To(Succeed())
+			}
+
+			stream := &fakeServerTransportStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(pfSelectorKey, "ens5f0,ens6f0"))
+			svc := &DevicePluginService{mode: types.HostMode}
+			_, err := svc.SetNumVfs(ctx, &pb.VfCount{VfCnt: 0})
+			Expect(status.Code(err)).To(Equal(codes.Internal))
+			Expect(status.Convert(err).Details()).To(ContainElement(WithTransform(func(d interface{}) map[string]string {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					return info.Metadata
+				}
+				return nil
+			}, And(HaveKey("0000:af:00.0"), HaveKey("0000:b0:00.0")))))
+			Expect(stream.header.Get(pfResultKey)).To(HaveLen(2))
+		})
+
+		It("should resolve a PF given by netdev name", func() {
+			results, err := SetNumVfs(types.HostMode, 0, []string{"ens6f0"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].PciAddr).To(Equal("0000:b0:00.0"))
+			Expect(results[0].TotalVfs).To(Equal(int32(8)))
+		})
+
+		It("should reject a PF which is not an IPU PF", func() {
+			_, err := SetNumVfs(types.HostMode, 0, []string{"ens1f0"}, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("is not an IPU PF"))
+		})
+
+		It("should only be supported in host mode", func() {
+			_, err := SetNumVfs(types.IpuMode, 4, nil, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("GetDevices", func() {
+		var (
+			svc    *DevicePluginService
+			stream *fakeServerTransportStream
+		)
+
+		getDevices := func(pool string) (map[string]*pb.Device, error) {
+			stream = &fakeServerTransportStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			if pool != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(resourcePoolKey, pool))
+			}
+			res, err := svc.GetDevices(ctx, &pb.Empty{})
+			return res.Devices, err
+		}
+
+		BeforeEach(func() {
+			createFakeVf("0000:af:00.0", "0000:af:00.1", 0, "ens5f0v0", "00:11:00:00:03:14", "idpf")
+			createFakeVf("0000:af:00.0", "0000:af:00.2", 1, "ens5f0v1", "00:12:00:00:03:14", "vfio-pci")
+			createFakeVf("0000:b0:00.0", "0000:b0:00.1", 0, "ens6f0v0", "00:21:00:00:03:14", "idpf")
+			setFakeNumaNode("0000:af:00.2", "0", "0-15")
+			setFakeNumaNode("0000:b0:00.1", "-1", "")
+
+			var err error
+			svc, err = NewDevicePluginService(types.HostMode, NewDeviceWatcher(types.HostMode, nil, 0), []ResourcePool{
+				{Name: "dpdk", Drivers: []string{"vfio-pci"}},
+				{Name: "pf0", PfNames: []string{"ens5f0"}, VfIndexRange: "0-0"},
+				{Name: "mac", MacPattern: "^00:2"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should return all the VFs with their details when no pool is requested", func() {
+			devices, err := getDevices("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveLen(3))
+
+			infos := stream.header.Get(deviceInfoKey)
+			Expect(infos).To(HaveLen(3))
+			found := false
+			for _, infoJson := range infos {
+				info := deviceInfo{}
+				Expect(json.Unmarshal([]byte(infoJson), &info)).To(Succeed())
+				if info.Name == "ens5f0v1" {
+					found = true
+					Expect(info).To(Equal(deviceInfo{Name: "ens5f0v1", PciAddr: "0000:af:00.2", Driver: "vfio-pci",
+						Mac: "00:12:00:00:03:14", Vsi: 0x12, NumaNode: 0, LocalCpus: "0-15", PfPciAddr: "0000:af:00.0", PfName: "ens5f0", VfIndex: 1}))
+				}
+			}
+			Expect(found).To(BeTrue())
+		})
+
+		It("should return a topology hint for the VFs on a known NUMA node", func() {
+			devices, err := getDevices("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices["ens5f0v1"].Topology).NotTo(BeNil())
+			Expect(devices["ens5f0v1"].Topology.Node).To(Equal("0"))
+			Expect(devices["ens6f0v0"].Topology).To(BeNil())
+		})
+
+		It("should report the topology of the VFs of a PF", func() {
+			vfs, err := GetVFList("0000:af:00.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(ConsistOf(
+				VfTopology{PciAddr: "0000:af:00.1", NumaNode: 0},
+				VfTopology{PciAddr: "0000:af:00.2", NumaNode: 0, LocalCpus: "0-15"},
+			))
+		})
+
+		It("should only return the VFs matching the requested pool", func() {
+			devices, err := getDevices("dpdk")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("ens5f0v1"))
+			Expect(devices).To(HaveLen(1))
+
+			devices, err = getDevices("pf0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("ens5f0v0"))
+			Expect(devices).To(HaveLen(1))
+
+			devices, err = getDevices("mac")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveKey("ens6f0v0"))
+			Expect(devices).To(HaveLen(1))
+		})
+
+		It("should reject an unknown pool", func() {
+			_, err := getDevices("unknown")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid pool definitions", func() {
+			_, err := NewDevicePluginService(types.HostMode, NewDeviceWatcher(types.HostMode, nil, 0), []ResourcePool{{Name: "a"}, {Name: "a"}})
+			Expect(err).To(HaveOccurred())
+			_, err = NewDevicePluginService(types.HostMode, NewDeviceWatcher(types.HostMode, nil, 0), []ResourcePool{{Name: "a", VfIndexRange: "7-1"}})
+			Expect(err).To(HaveOccurred())
+			_, err = NewDevicePluginService(types.HostMode, NewDeviceWatcher(types.HostMode, nil, 0), []ResourcePool{{Name: "a", MacPattern: "("}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
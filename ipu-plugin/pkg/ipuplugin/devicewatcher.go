@@ -0,0 +1,289 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Time to wait for VFs to be created when no timeout is configured. 2 seconds were enough for the
+	// max VFs(64) validated so far, but is too short on some kernels.
+	defaultVfCreateTimeout = 10 * time.Second
+	// Interval at which the VF count is re-read while waiting for VFs, in case no netlink event is received,
+	// e.g.; for VFs bound to vfio-pci which do not have a netdev
+	vfPollInterval = time.Second / 3
+	// Delay before subscribing again to netlink after the subscription failed
+	resubscribeDelay = time.Second
+)
+
+// Abstract the netlink subscription for unit tests
+var linkSubscribeFn = netlink.LinkSubscribeWithOptions
+
+// DeviceWatcher maintains a cache of the IDPF netdevs exposed by the DeviceService from netlink link
+// events, so that sysfs does not have to be rescanned on every GetDevices request. It also lets
+// SetNumSriovVfs wait for the VFs to be created instead of polling with a fixed deadline.
+type DeviceWatcher struct {
+	mode      string
+	exclude   []string
+	vfTimeout time.Duration
+	log       *log.Entry
+
+	mu sync.Mutex
+	// devices is only valid while synced is true
+	devices map[string]*deviceInfo
+	// netdev name of each link index in devices, to handle renames
+	names   map[int]string
+	synced  bool
+	changed chan struct{}
+	stopCh  chan struct{}
+}
+
+// NewDeviceWatcher returns a watcher for the devices of the given mode. Interfaces in exclude are never
+// reported, vfTimeout is how long SetNumSriovVfs waits for the VFs of a PF to be created or removed.
+func NewDeviceWatcher(mode string, exclude []string, vfTimeout time.Duration) *DeviceWatcher {
+	return &DeviceWatcher{
+		mode:      mode,
+		exclude:   exclude,
+		vfTimeout: vfTimeout,
+		log:       log.WithField("pkg", "devicewatcher"),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Start subscribes to the netlink link events. Until the subscription succeeds, the devices are read from sysfs.
+func (w *DeviceWatcher) Start() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopCh != nil {
+		return
+	}
+	w.stopCh = make(chan struct{})
+	go w.run(w.stopCh)
+}
+
+// Stop ends the netlink subscription, the devices are then read from sysfs again.
+func (w *DeviceWatcher) Stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopCh != nil {
+		close(w.stopCh)
+		w.stopCh = nil
+	}
+	w.synced = false
+}
+
+// SetExclude updates the interfaces that are never reported, the cached devices are rescanned
+func (w *DeviceWatcher) SetExclude(exclude []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.exclude = exclude
+	if !w.synced {
+		return nil
+	}
+	devices, err := discoverHostDevices(w.mode, exclude)
+	if err != nil {
+		// read from sysfs until the next subscription
+		w.synced = false
+		w.notify()
+		return err
+	}
+	w.devices = devices
+	w.names = getLinkNames(devices)
+	w.notify()
+	return nil
+}
+
+// Devices returns the current devices, keyed by netdev name.
+func (w *DeviceWatcher) Devices() (map[string]*deviceInfo, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.synced {
+		return maps.Clone(w.devices), nil
+	}
+	return discoverHostDevices(w.mode, w.exclude)
+}
+
+// WaitForVfs waits until PF pciAddr has count VFs. The VF count is checked on every link event, and
+// periodically for VFs which do not have a netdev.
+func (w *DeviceWatcher) WaitForVfs(pciAddr string, count int) error {
+	timeout := defaultVfCreateTimeout
+	if w != nil && w.vfTimeout > 0 {
+		timeout = w.vfTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(vfPollInterval)
+	defer poll.Stop()
+
+	for {
+		// get the channel before reading the count so that no event is missed in between
+		changed := w.changedCh()
+		cnt, err := GetVfDeviceCount(pciAddr)
+		if cnt == count && err == nil {
+			log.Debugf("WaitForVfs: match expected count, %v\n", cnt)
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-deadline.C:
+			return fmt.Errorf("WaitForVfs: timed out after %v: attempt->%v, result->%v, err->%v", timeout, count, cnt, err)
+		}
+	}
+}
+
+// changedCh returns a channel that is closed on the next device change, or nil if the watcher is not running
+func (w *DeviceWatcher) changedCh() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.synced {
+		return nil
+	}
+	return w.changed
+}
+
+func (w *DeviceWatcher) run(stopCh <-chan struct{}) {
+	for {
+		if err := w.watch(stopCh); err != nil {
+			w.log.WithField("error", err).Warn("netlink subscription failed, reading devices from sysfs")
+		}
+		w.setUnsynced()
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// watch subscribes to the link events and updates the device cache until stopCh is closed or the subscription fails.
+func (w *DeviceWatcher) watch(stopCh <-chan struct{}) error {
+	updates := make(chan netlink.LinkUpdate, 256)
+	done := make(chan struct{})
+	defer close(done)
+
+	errCh := make(chan error, 1)
+	err := linkSubscribeFn(updates, done, netlink.LinkSubscribeOptions{
+		ErrorCallback: func(err error) {
+			select {
+			case errCh <- err:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to link updates: %w", err)
+	}
+
+	// Events received from now on are applied on top of this scan
+	w.mu.Lock()
+	exclude := w.exclude
+	w.mu.Unlock()
+	devices, err := discoverHostDevices(w.mode, exclude)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.devices = devices
+	w.names = getLinkNames(devices)
+	w.synced = true
+	w.notify()
+	w.mu.Unlock()
+	w.log.Infof("device watcher synced with %d devices", len(devices))
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case err := <-errCh:
+			return err
+		case update, ok := <-updates:
+			if !ok {
+				return fmt.Errorf("link update channel closed")
+			}
+			w.handleUpdate(update)
+		}
+	}
+}
+
+// getLinkNames returns the netdev name of the devices keyed by their link index, the devices whose link
+// cannot be read are left out
+func getLinkNames(devices map[string]*deviceInfo) map[int]string {
+	names := make(map[int]string, len(devices))
+	for name := range devices {
+		link, err := linkByNameFn(name)
+		if err != nil {
+			log.WithField("netdev", name).WithField("error", err).Debug("unable to read the link index of the device")
+			continue
+		}
+		names[link.Attrs().Index] = name
+	}
+	return names
+}
+
+func (w *DeviceWatcher) handleUpdate(update netlink.LinkUpdate) {
+	attrs := update.Link.Attrs()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if oldName, ok := w.names[attrs.Index]; ok && (oldName != attrs.Name || update.Header.Type == syscall.RTM_DELLINK) {
+		delete(w.devices, oldName)
+		delete(w.names, attrs.Index)
+		w.log.WithField("netdev", oldName).Debug("device removed")
+	}
+	if update.Header.Type == syscall.RTM_NEWLINK {
+		if isHostDevice(w.mode, w.exclude, attrs.Name) {
+			w.devices[attrs.Name] = getDeviceInfo(attrs.Name)
+			w.names[attrs.Index] = attrs.Name
+			w.log.WithField("netdev", attrs.Name).Debug("device updated")
+		} else if _, ok := w.devices[attrs.Name]; ok {
+			// discovered from sysfs while its link index could not be read
+			delete(w.devices, attrs.Name)
+		}
+	} else if update.Header.Type == syscall.RTM_DELLINK {
+		delete(w.devices, attrs.Name)
+	}
+	w.notify()
+}
+
+func (w *DeviceWatcher) setUnsynced() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.synced = false
+	w.notify()
+}
+
+// notify wakes up the goroutines waiting for a change, must be called with mu held
+func (w *DeviceWatcher) notify() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+func fakeLinkUpdate(msgType uint16, name string, index int) netlink.LinkUpdate {
+	update := netlink.LinkUpdate{Link: &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name, Index: index}}}
+	update.Header.Type = msgType
+	return update
+}
+
+var _ = Describe("DeviceWatcher", Serial, func() {
+	var (
+		origSysBusPciDevices, origSysClassNet string
+		origLinkSubscribeFn                   = linkSubscribeFn
+		origLinkByNameFn                      = linkByNameFn
+		updates                               chan<- netlink.LinkUpdate
+		watcher                               *DeviceWatcher
+	)
+
+	BeforeEach(func() {
+		origSysBusPciDevices, origSysClassNet = sysBusPciDevices, sysClassNet
+		tmpDir := GinkgoT().TempDir()
+		sysBusPciDevices = filepath.Join(tmpDir, "bus/pci/devices")
+		sysClassNet = filepath.Join(tmpDir, "class/net")
+
+		createFakePciDevice("0000:af:00.0", deviceCode, "ens5f0", "16")
+		createFakeVf("0000:af:00.0", "0000:af:00.1", 0, "ens5f0v0", "00:11:00:00:03:14", "idpf")
+
+		linkByNameFn = func(name string) (netlink.Link, error) {
+			if name != "ens5f0v0" {
+				return nil, netlink.LinkNotFoundError{}
+			}
+			return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: name, Index: 11}}, nil
+		}
+		subscribed := make(chan chan<- netlink.LinkUpdate, 1)
+		linkSubscribeFn = func(ch chan<- netlink.LinkUpdate, _ <-chan struct{}, _ netlink.LinkSubscribeOptions) error {
+			subscribed <- ch
+			return nil
+		}
+		watcher = NewDeviceWatcher(types.HostMode, nil, time.Second)
+		watcher.Start()
+		Eventually(subscribed).Should(Receive(&updates))
+		Eventually(watcher.changedCh).ShouldNot(BeNil())
+	})
+
+	AfterEach(func() {
+		watcher.Stop()
+		linkSubscribeFn = origLinkSubscribeFn
+		linkByNameFn = origLinkByNameFn
+		sysBusPciDevices, sysClassNet = origSysBusPciDevices, origSysClassNet
+	})
+
+	It("should serve the devices found at startup from the cache", func() {
+		devices, err := watcher.Devices()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveKey("ens5f0v0"))
+
+		// sysfs is not read again without a link event
+		Expect(os.RemoveAll(filepath.Join(sysClassNet, "ens5f0v0"))).To(Succeed())
+		devices, err = watcher.Devices()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(HaveKey("ens5f0v0"))
+	})
+
+	It("should add and remove devices on link events", func() {
+		createFakeVf("0000:af:00.0", "0000:af:00.2", 1, "ens5f0v1", "00:12:00:00:03:14", "idpf")
+		updates <- fakeLinkUpdate(syscall.RTM_NEWLINK, "ens5f0v1", 12)
+		Eventually(func() map[string]*deviceInfo {
+			devices, _ := watcher.Devices()
+			return devices
+		}).Should(HaveKey("ens5f0v1"))
+
+		// renamed by udev
+		Expect(os.Rename(filepath.Join(sysClassNet, "ens5f0v1"), filepath.Join(sysClassNet, "ens5f0v9"))).To(Succeed())
+		updates <- fakeLinkUpdate(syscall.RTM_NEWLINK, "ens5f0v9", 12)
+		Eventually(func() map[string]*deviceInfo {
+			devices, _ := watcher.Devices()
+			return devices
+		}).Should(And(HaveKey("ens5f0v9"), Not(HaveKey("ens5f0v1"))))
+
+		updates <- fakeLinkUpdate(syscall.RTM_DELLINK, "ens5f0v9", 12)
+		Eventually(func() map[string]*deviceInfo {
+			devices, _ := watcher.Devices()
+			return devices
+		}).ShouldNot(HaveKey("ens5f0v9"))
+	})
+
+	It("should handle the renames of the devices found at startup", func() {
+		Expect(os.Rename(filepath.Join(sysClassNet, "ens5f0v0"), filepath.Join(sysClassNet, "ens5f0v8"))).To(Succeed())
+		updates <- fakeLinkUpdate(syscall.RTM_NEWLINK, "ens5f0v8", 11)
+		Eventually(func() map[string]*deviceInfo {
+			devices, _ := watcher.Devices()
+			return devices
+		}).Should(And(HaveKey("ens5f0v8"), Not(HaveKey("ens5f0v0"))))
+	})
+
+	It("should ignore netdevs which are not IPU VFs", func() {
+		Expect(os.MkdirAll(filepath.Join(sysClassNet, "br0"), 0755)).To(Succeed())
+		updates <- fakeLinkUpdate(syscall.RTM_NEWLINK, "br0", 20)
+		Consistently(func() map[string]*deviceInfo {
+			devices, _ := watcher.Devices()
+			return devices
+		}, "100ms").ShouldNot(HaveKey("br0"))
+	})
+
+	It("should wait for the VFs to be created", func() {
+		errCh := make(chan error, 1)
+		go func() { errCh <- watcher.WaitForVfs("0000:af:00.0", 2) }()
+		Consistently(errCh, "100ms").ShouldNot(Receive())
+
+		createFakeVf("0000:af:00.0", "0000:af:00.2", 1, "ens5f0v1", "00:12:00:00:03:14", "idpf")
+		updates <- fakeLinkUpdate(syscall.RTM_NEWLINK, "ens5f0v1", 12)
+		Eventually(errCh).Should(Receive(BeNil()))
+	})
+
+	It("should time out when the VFs are not created", func() {
+		err := watcher.WaitForVfs("0000:af:00.0", 4)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("timed out"))
+	})
+})
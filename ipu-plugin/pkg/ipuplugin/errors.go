@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// The errors returned by the services of the plugin are gRPC statuses, so that the CNI and the dpu-daemon can
+// tell the requests to fix (InvalidArgument, NotFound, AlreadyExists, FailedPrecondition) from the ones to
+// retry (Unavailable when the IMC cannot be reached, Internal when netlink or the FXP could not be programmed).
+// Each status has details telling which field, resource or precondition is at fault.
+
+const (
+	// errorDomain is the domain of the ErrorInfo details
+	errorDomain = "ipu-plugin.intel.com"
+
+	// The reasons of the ErrorInfo details of the Unavailable and Internal statuses
+	reasonImcUnreachable = "IMC_UNREACHABLE"
+	reasonNetlink        = "NETLINK_FAILURE"
+	reasonHostConfig     = "HOST_CONFIG_FAILURE"
+
+	// imcRetryDelay is the delay suggested before retrying a request which failed to reach the IMC
+	imcRetryDelay = 5 * time.Second
+)
+
+// The resource types of the NotFound and AlreadyExists ResourceInfo details
+const (
+	bridgePortResource    = "bridge port"
+	logicalBridgeResource = "logical bridge"
+	vrfResource           = "vrf"
+)
+
+func fieldViolation(field, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: description}
+}
+
+// invalidArgument returns an InvalidArgument status with the field violations as BadRequest details
+func invalidArgument(violations ...*errdetails.BadRequest_FieldViolation) error {
+	descriptions := make([]string, 0, len(violations))
+	for _, v := range violations {
+		descriptions = append(descriptions, v.Field+": "+v.Description)
+	}
+	return newStatus(codes.InvalidArgument, strings.Join(descriptions, "; "),
+		&errdetails.BadRequest{FieldViolations: violations})
+}
+
+// notFound returns a NotFound status for the resource name of type resourceType
+func notFound(resourceType, name string) error {
+	return newStatus(codes.NotFound, fmt.Sprintf("%s %s not found", resourceType, name),
+		&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: name, Description: "not found"})
+}
+
+// alreadyExists returns an AlreadyExists status for the resource name of type resourceType, which conflicts with
+// the requested one as told by description
+func alreadyExists(resourceType, name, description string) error {
+	return newStatus(codes.AlreadyExists, description,
+		&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: name, Description: description})
+}
+
+// failedPrecondition returns a FailedPrecondition status with a PreconditionFailure violation of type
+// violationType, e.g.; "IN_USE", on subject
+func failedPrecondition(violationType, subject, description string) error {
+	return newStatus(codes.FailedPrecondition, description, &errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: violationType, Subject: subject, Description: description},
+		},
+	})
+}
+
+// imcUnavailable returns an Unavailable status for a request which failed to reach the IMC, it can be retried
+// after the RetryInfo delay
+func imcUnavailable(err error) error {
+	return newStatus(codes.Unavailable, fmt.Sprintf("unable to reach the IMC: %v", err),
+		&errdetails.ErrorInfo{Reason: reasonImcUnreachable, Domain: errorDomain},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(imcRetryDelay)})
+}
+
+// internalError returns an Internal status for err, with reason as ErrorInfo. err is returned as is when it is
+// already a status.
+func internalError(reason string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return newStatus(codes.Internal, err.Error(), &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain})
+}
+
+func newStatus(code codes.Code, msg string, details ...protoadapt.MessageV1) error {
+	st := status.New(code, msg)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("errors", func() {
+	It("should tell the clients to retry when the IMC cannot be reached", func() {
+		st := status.Convert(imcUnavailable(errors.New("ssh: connect to host 192.168.0.1 port 22: No route to host")))
+		Expect(st.Code()).To(Equal(codes.Unavailable))
+		Expect(st.Message()).To(ContainSubstring("No route to host"))
+		Expect(st.Details()).To(HaveLen(2))
+		Expect(st.Details()[0].(*errdetails.ErrorInfo).Reason).To(Equal(reasonImcUnreachable))
+		Expect(st.Details()[1].(*errdetails.RetryInfo).RetryDelay.AsDuration()).To(Equal(5 * time.Second))
+	})
+
+	It("should only make an Internal status of the errors which are not a status", func() {
+		err := internalError(reasonNetlink, errors.New("file exists"))
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Expect(status.Convert(err).Details()[0].(*errdetails.ErrorInfo).Reason).To(Equal(reasonNetlink))
+
+		err = notFound(logicalBridgeResource, "logicalBridges/blue")
+		Expect(internalError(reasonNetlink, err)).To(Equal(err))
+		info := status.Convert(err).Details()[0].(*errdetails.ResourceInfo)
+		Expect(info.ResourceType).To(Equal(logicalBridgeResource))
+		Expect(info.ResourceName).To(Equal("logicalBridges/blue"))
+	})
+
+	It("should report the precondition which failed", func() {
+		st := status.Convert(logicalBridgeInUse("logicalBridges/blue", "port0"))
+		Expect(st.Code()).To(Equal(codes.FailedPrecondition))
+		violation := st.Details()[0].(*errdetails.PreconditionFailure).Violations[0]
+		Expect(violation.Type).To(Equal("IN_USE"))
+		Expect(violation.Subject).To(Equal("logicalBridges/blue"))
+	})
+})
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"time"
+
+	pb2 "github.com/openshift/dpu-operator/dpu-api/gen"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Interval at which the bridge port health is re-evaluated
+const healthCheckInterval = 10 * time.Second
+
+// Full gRPC names of the services, as used by the health service
+var (
+	lifeCycleServiceFullName       = pb2.LifeCycleService_ServiceDesc.ServiceName
+	bridgePortServiceFullName      = pb.BridgePortService_ServiceDesc.ServiceName
+	networkFunctionServiceFullName = pb2.NetworkFunctionService_ServiceDesc.ServiceName
+	deviceServiceFullName          = pb2.DeviceService_ServiceDesc.ServiceName
+	logicalBridgeServiceFullName   = pb.LogicalBridgeService_ServiceDesc.ServiceName
+	vrfServiceFullName             = pb.VrfService_ServiceDesc.ServiceName
+)
+
+// healthStatus reports the status of the plugin services through the grpc.health.v1 service. The overall
+// status, i.e.; the one of the "" service, is SERVING while the plugin is running. It is safe to use when nil.
+type healthStatus struct {
+	srv *health.Server
+	log *log.Entry
+}
+
+func newHealthStatus() *healthStatus {
+	srv := health.NewServer()
+	// The health server reports SERVING for "" from the start, only do once the listeners are up
+	srv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &healthStatus{srv: srv, log: log.WithField("pkg", "health")}
+}
+
+// setServing updates the status of service, given by its full gRPC name
+func (h *healthStatus) setServing(service string, serving bool) {
+	if h == nil {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	h.log.WithField("service", service).Debugf("health status %s", status)
+	h.srv.SetServingStatus(service, status)
+}
+
+// shutdown sets all the services to NOT_SERVING
+func (h *healthStatus) shutdown() {
+	if h == nil {
+		return
+	}
+	h.srv.Shutdown()
+}
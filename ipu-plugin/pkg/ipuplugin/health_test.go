@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var _ = Describe("Health", Serial, func() {
+	var (
+		s            *server
+		healthClient healthpb.HealthClient
+		uplinkExists bool
+		bridgeExists bool
+	)
+
+	checkHealth := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		Expect(err).NotTo(HaveOccurred())
+		return res.Status
+	}
+
+	BeforeEach(func() {
+		uplinkExists, bridgeExists = false, true
+		linkByNameFn = func(name string) (netlink.Link, error) {
+			if (name == "br-infra" && !bridgeExists) || (name == "enp0s1f0d3" && !uplinkExists) {
+				return fakeLinkByNameWithErr(name)
+			}
+			return fakeLinkByName(name)
+		}
+
+		sockPath := filepath.Join(GinkgoT().TempDir(), "plugin.sock")
+		runnable, err := NewIpuPlugin(nil, nil, Config{
+			Bridge:           "br-infra",
+			Interface:        "enp0s1f0d3",
+			Mode:             types.IpuMode,
+			Listeners:        []ListenerConfig{{Proto: "unix", Addr: sockPath}},
+			EnableReflection: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		s = runnable.(*server)
+		s.registerServices()
+		Expect(s.listeners[0].listen(make(chan error, 1))).To(Succeed())
+		s.health.setServing("", true)
+		DeferCleanup(s.Stop)
+
+		conn, err := grpc.NewClient("unix://"+sockPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		healthClient = healthpb.NewHealthClient(conn)
+	})
+
+	It("should report the LifeCycleService as not serving until Init has succeeded", func() {
+		Expect(checkHealth("")).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		Expect(checkHealth(deviceServiceFullName)).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		Expect(checkHealth(lifeCycleServiceFullName)).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	It("should report the BridgePortService as serving once the uplink exists", func() {
+		stopCh := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.watchBridgePortHealth(stopCh)
+		}()
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return checkHealth(bridgePortServiceFullName)
+		}).Should(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		close(stopCh)
+		<-done
+
+		uplinkExists = true
+		stopCh = make(chan struct{})
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			s.watchBridgePortHealth(stopCh)
+		}()
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return checkHealth(bridgePortServiceFullName)
+		}).Should(Equal(healthpb.HealthCheckResponse_SERVING))
+		close(stopCh)
+		<-done
+	})
+
+	It("should report the BridgePortService as not serving once the bridge is gone", func() {
+		uplinkExists = true
+		stopCh := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.watchBridgePortHealth(stopCh)
+		}()
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return checkHealth(bridgePortServiceFullName)
+		}).Should(Equal(healthpb.HealthCheckResponse_SERVING))
+		close(stopCh)
+		<-done
+
+		bridgeExists = false
+		stopCh = make(chan struct{})
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			s.watchBridgePortHealth(stopCh)
+		}()
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return checkHealth(bridgePortServiceFullName)
+		}).Should(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		close(stopCh)
+		<-done
+	})
+
+	It("should report all the services as not serving once stopped", func() {
+		s.health.shutdown()
+		Expect(checkHealth("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		Expect(checkHealth(deviceServiceFullName)).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	It("should register the reflection service when enabled", func() {
+		Expect(s.listeners[0].grpcSrvr.GetServiceInfo()).To(HaveKey("grpc.reflection.v1.ServerReflection"))
+	})
+})
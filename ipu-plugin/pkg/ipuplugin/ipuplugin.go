@@ -15,144 +15,337 @@
 package ipuplugin
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"os"
-	"os/signal"
-	"path/filepath"
-	"syscall"
+	"net/http"
+	"sync"
+	"time"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	pb2 "github.com/openshift/dpu-operator/dpu-api/gen"
 
 	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 type server struct {
 	pb.UnimplementedBridgePortServiceServer
-	servingAddr     string
-	servingPort     int
-	servingProto    string
+	pb.UnimplementedLogicalBridgeServiceServer
+	pb.UnimplementedVrfServiceServer
 	bridgeName      string
 	uplinkInterface string
-	grpcSrvr        *grpc.Server
-	listener        net.Listener
+	stag            int // S-tag of the outer vlan of the ports which do not set one
+	listeners       []*listener
 	log             *log.Entry
 	p4cpInstall     string
+	portsMu         sync.RWMutex // guards Ports
 	Ports           map[string]*pb.BridgePort
+	vsis            map[string]int       // VSI of the VF of each BridgePort, guarded by portsMu
+	autoVlans       map[string]int       // vlan allocated to each BridgePort created without one, guarded by portsMu
+	portVlans       map[string]portVlans // S-tag and vlans each BridgePort was created on, guarded by portsMu
+	portQos         map[string]portQos   // QoS of each BridgePort created with one, guarded by portsMu
+	vlanAlloc       vlanAllocator        // checks the vlans of the ports and allocates them when not given
+	portLocks       keyedMutex           // serializes the operations on a BridgePort
+	intfLocks       keyedMutex           // serializes the changes to a vlan interface, shared by the ports
+	claimLocks      keyedMutex           // serializes the creation of the ports claiming the same VSI or vlan
+	lbMu            sync.RWMutex         // read-held while a BridgePort is created, write-held while a LogicalBridge changes
+	logicalBridges  registry[*pb.LogicalBridge]
+	vrfs            registry[*pb.Vrf]
 	bridgeCtlr      types.BridgeController
+	bridgeType      types.BridgeType
 	p4RtClient      types.P4RTClient
 	mode            string
 	daemonHostIp    string
 	daemonIpuIp     string
 	daemonPort      int
 	p4rtbin         string
+	vfWatcher       *VfWatcher
+	deviceWatcher   *DeviceWatcher
+	deviceService   *DevicePluginService
+	health          *healthStatus
+	reflection      bool
+	metricsAddr     string
+	metricsSrvr     *http.Server
+	cfgMu           sync.Mutex // guards shutdown
+	shutdown        ShutdownConfig
+	stateMu         sync.Mutex // serializes the writes of the state file
+	reloadFn        ReloadFunc
+	stopCh          chan struct{}
+	stopOnce        sync.Once
 }
 
-func NewIpuPlugin(port int, brCtlr types.BridgeController, p4rtbin string,
-	p4Client types.P4RTClient, servingAddr, servingProto, bridge, intf, p4cpInstall, mode, daemonHostIp, daemonIpuIp string, daemonPort int) types.Runnable {
+// Config is the configuration of the IPU plugin
+type Config struct {
+	// Bridge is the name of the bridge the plugin manages and Interface its uplink interface
+	Bridge     string
+	BridgeType types.BridgeType
+	Interface  string
+	// P4rtBin is the p4rt-ctl binary used to program the FXP rules
+	P4rtBin     string
+	P4cpInstall string
+	// Mode is either types.IpuMode or types.HostMode
+	Mode         string
+	DaemonHostIp string
+	DaemonIpuIp  string
+	DaemonPort   int
+	// Stag is the S-tag of the outer vlan of the ports which do not set one
+	Stag  int
+	Vlans VlanConfig
+	// VfWatchInterval is the interval at which the host VFs are re-read from the IMC, 0 disables the periodic resync
+	VfWatchInterval   time.Duration
+	ExcludeInterfaces []string
+	ResourcePools     []ResourcePool
+	VfCreateTimeout   time.Duration
+	Listeners         []ListenerConfig
+	EnableReflection  bool
+	// MetricsAddr is the address of the Prometheus metrics endpoint, the metrics are not served when empty
+	MetricsAddr string
+	Shutdown    ShutdownConfig
+	// ReloadFn returns the configuration to apply on reload, the plugin cannot be reloaded when nil
+	ReloadFn ReloadFunc
+}
+
+// NewIpuPlugin returns the IPU plugin configured by cfg, which manages the bridge through brCtlr and programs
+// the FXP rules through p4Client
+func NewIpuPlugin(brCtlr types.BridgeController, p4Client types.P4RTClient, cfg Config) (types.Runnable, error) {
+	if len(cfg.Listeners) == 0 {
+		return nil, fmt.Errorf("no listener configured")
+	}
+	if err := cfg.Shutdown.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Stag < 0 || cfg.Stag > 4094 {
+		return nil, fmt.Errorf("invalid s-tag %d, s-tag must be within 0-4094 range", cfg.Stag)
+	}
+	ranges, err := cfg.Vlans.parse()
+	if err != nil {
+		return nil, err
+	}
+	listeners := make([]*listener, 0, len(cfg.Listeners))
+	stopListeners := func() {
+		for _, l := range listeners {
+			l.stop()
+		}
+	}
+	names := map[string]bool{}
+	for _, lCfg := range cfg.Listeners {
+		l, err := newListener(lCfg)
+		if err != nil {
+			stopListeners()
+			return nil, err
+		}
+		if names[l.cfg.Name] {
+			stopListeners()
+			return nil, fmt.Errorf("listener %s is defined more than once", l.cfg.Name)
+		}
+		names[l.cfg.Name] = true
+		listeners = append(listeners, l)
+	}
+
+	deviceWatcher := NewDeviceWatcher(cfg.Mode, cfg.ExcludeInterfaces, cfg.VfCreateTimeout)
+	deviceService, err := NewDevicePluginService(cfg.Mode, deviceWatcher, cfg.ResourcePools)
+	if err != nil {
+		stopListeners()
+		return nil, fmt.Errorf("invalid device plugin configuration: %w", err)
+	}
+	var vfWatcher *VfWatcher
+	if cfg.Mode == types.IpuMode {
+		vfWatcher = NewVfWatcher(cfg.P4rtBin, cfg.VfWatchInterval)
+	}
+	deviceService.vfWatcher = vfWatcher
 	return &server{
-		servingAddr:     servingAddr,
-		servingPort:     port,
-		servingProto:    servingProto,
-		bridgeName:      bridge,
-		uplinkInterface: intf,
-		grpcSrvr:        grpc.NewServer(),
+		bridgeName:      cfg.Bridge,
+		uplinkInterface: cfg.Interface,
+		stag:            cfg.Stag,
+		vlanAlloc:       vlanAllocator{vlanRanges: ranges},
+		listeners:       listeners,
 		log:             log.WithField("pkg", "ipuplugin"),
-		p4cpInstall:     p4cpInstall,
+		p4cpInstall:     cfg.P4cpInstall,
 		Ports:           make(map[string]*pb.BridgePort),
 		bridgeCtlr:      brCtlr,
+		bridgeType:      cfg.BridgeType,
 		p4RtClient:      p4Client,
-		mode:            mode,
-		daemonHostIp:    daemonHostIp,
-		daemonIpuIp:     daemonIpuIp,
-		daemonPort:      daemonPort,
-		p4rtbin:         p4rtbin,
-	}
+		mode:            cfg.Mode,
+		daemonHostIp:    cfg.DaemonHostIp,
+		daemonIpuIp:     cfg.DaemonIpuIp,
+		daemonPort:      cfg.DaemonPort,
+		p4rtbin:         cfg.P4rtBin,
+		vfWatcher:       vfWatcher,
+		deviceWatcher:   deviceWatcher,
+		deviceService:   deviceService,
+		health:          newHealthStatus(),
+		reflection:      cfg.EnableReflection,
+		metricsAddr:     cfg.MetricsAddr,
+		shutdown:        cfg.Shutdown,
+		reloadFn:        cfg.ReloadFn,
+		stopCh:          make(chan struct{}),
+	}, nil
 }
 
-func (s *server) Run() error {
-	var err error
-	signalChannel := make(chan os.Signal, 2)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
-
-	listen, err := s.getListener()
-	if err != nil {
-		return fmt.Errorf("unable to run IPU plugin")
-	}
-
+// Run serves until ctx is done or Stop is called, then stops the plugin. An error is returned when the plugin
+// fails to start or one of its servers fails.
+func (s *server) Run(ctx context.Context) error {
 	if err := s.bridgeCtlr.EnsureBridgeExists(); err != nil {
-		log.Fatalf("error while checking host bridge existance: %v", err)
-		return fmt.Errorf("host bridge error")
+		return fmt.Errorf("unable to run IPU plugin: error while checking host bridge existence: %w", err)
 	}
 
-	pb2.RegisterLifeCycleServiceServer(s.grpcSrvr, NewLifeCycleService(s.daemonHostIp, s.daemonIpuIp, s.daemonPort, s.mode, s.p4rtbin))
 	if s.mode == types.IpuMode {
-		pb.RegisterBridgePortServiceServer(s.grpcSrvr, s)
-		pb2.RegisterNetworkFunctionServiceServer(s.grpcSrvr, NewNetworkFunctionService(s.p4rtbin))
+		// The vlan interfaces and FXP rules of these ports were preserved by the previous run
+		if err := s.restoreState(s.getShutdownConfig().StateFile); err != nil {
+			s.log.Warnf("unable to restore state: %v", err)
+		}
 	}
-	pb2.RegisterDeviceServiceServer(s.grpcSrvr, NewDevicePluginService(s.mode))
+	s.registerServices()
+	s.deviceWatcher.Start()
 
-	s.log.WithField("addr", listen.Addr().String()).Info("IPU plugin server listening on at:")
-	go func() {
-		if err = s.grpcSrvr.Serve(listen); err != nil {
-			log.Fatalf("IPU plugin failed to serve: %v", err)
-			return
+	// Each server sends at most one error
+	serveErrs := make(chan error, len(s.listeners)+1)
+	for _, l := range s.listeners {
+		if err := l.listen(serveErrs); err != nil {
+			s.Stop()
+			return fmt.Errorf("unable to run IPU plugin: %w", err)
 		}
-	}()
+	}
+	if err := s.startMetricsServer(serveErrs); err != nil {
+		s.Stop()
+		return fmt.Errorf("unable to run IPU plugin: %w", err)
+	}
+	s.health.setServing("", true)
+	if s.mode == types.IpuMode {
+		go s.watchBridgePortHealth(s.stopCh)
+	}
 
-	// Wait for SIGTERM signal
-	<-signalChannel
-	s.log.Infof("SIGINT received, exiting")
+	var err error
+	select {
+	case <-ctx.Done():
+		s.log.Info("IPU plugin cancelled, exiting")
+	case <-s.stopCh:
+	case err = <-serveErrs:
+		err = fmt.Errorf("IPU plugin stopped: %w", err)
+	}
 	s.Stop()
-	return nil
+	return err
 }
 
-func (s *server) Stop() {
-	s.log.Info("Stopping IPU plugin")
-	s.grpcSrvr.GracefulStop()
-	if s.listener != nil {
-		s.listener.Close()
-		_ = s.cleanUp()
+// registerServices registers the services of the mode on the listeners exposing them, along with the health
+// service and optionally the reflection service. The service instances are shared by all the listeners.
+func (s *server) registerServices() {
+	lifeCycleService := NewLifeCycleService(s.daemonHostIp, s.daemonIpuIp, s.daemonPort, s.mode, s.p4rtbin, s.vfWatcher, s.health)
+	// The LifeCycleService is only ready once Init has succeeded
+	s.health.setServing(lifeCycleServiceFullName, false)
+	var nfService *NetworkFunctionServiceServer
+	if s.mode == types.IpuMode {
+		nfService = NewNetworkFunctionService(s.p4rtbin, s.vfWatcher)
+		s.health.setServing(networkFunctionServiceFullName, true)
+		// The BridgePortService is only ready once the uplink exists, see watchBridgePortHealth
+		s.health.setServing(bridgePortServiceFullName, false)
+		s.health.setServing(logicalBridgeServiceFullName, true)
+		s.health.setServing(vrfServiceFullName, true)
+		s.health.setServing(vfEventServiceFullName, true)
 	}
-	s.log.Info("IPU plugin has stopped")
-}
+	s.health.setServing(deviceServiceFullName, true)
 
-func (s *server) getListener() (net.Listener, error) {
-	if s.servingProto == "unix" {
-		// Do clean up first
-		if err := s.cleanUp(); err != nil {
-			return nil, err
+	for _, l := range s.listeners {
+		var registered []string
+		if l.serves(LifeCycleServiceName) {
+			pb2.RegisterLifeCycleServiceServer(l.grpcSrvr, lifeCycleService)
+			registered = append(registered, LifeCycleServiceName)
+		}
+		if s.mode == types.IpuMode {
+			if l.serves(BridgePortServiceName) {
+				pb.RegisterBridgePortServiceServer(l.grpcSrvr, s)
+				registered = append(registered, BridgePortServiceName)
+			}
+			if l.serves(NetworkFunctionServiceName) {
+				pb2.RegisterNetworkFunctionServiceServer(l.grpcSrvr, nfService)
+				registered = append(registered, NetworkFunctionServiceName)
+			}
+			if l.serves(LogicalBridgeServiceName) {
+				pb.RegisterLogicalBridgeServiceServer(l.grpcSrvr, s)
+				registered = append(registered, LogicalBridgeServiceName)
+			}
+			if l.serves(VrfServiceName) {
+				pb.RegisterVrfServiceServer(l.grpcSrvr, s)
+				registered = append(registered, VrfServiceName)
+			}
+			if l.serves(VfEventServiceName) {
+				registerVfEventService(l.grpcSrvr, s.vfWatcher)
+				registered = append(registered, VfEventServiceName)
+			}
 		}
-		socketDir := filepath.Dir(s.servingAddr)
-		if err := os.MkdirAll(socketDir, 0600); err != nil {
-			log.Fatalf("failed to create plugin socket directory: %v", err)
-			return nil, fmt.Errorf("unable to create socket directory")
+		if l.serves(DeviceServiceName) {
+			pb2.RegisterDeviceServiceServer(l.grpcSrvr, s.deviceService)
+			registered = append(registered, DeviceServiceName)
 		}
+		healthpb.RegisterHealthServer(l.grpcSrvr, s.health.srv)
+		if s.reflection {
+			reflection.Register(l.grpcSrvr)
+		}
+		l.log.WithField("services", registered).Info("services registered")
+	}
+}
 
-		listen, err := net.Listen(s.servingProto, s.servingAddr)
-		if err != nil {
-			log.Fatalf("failed to open unix socket listener: %v", err)
-			return nil, fmt.Errorf("unable to open unix socket")
+// startMetricsServer serves the Prometheus metrics over HTTP on metricsAddr, it is disabled when empty. The error
+// stopping the server early is sent to serveErrs.
+func (s *server) startMetricsServer(serveErrs chan<- error) error {
+	if s.metricsAddr == "" {
+		return nil
+	}
+	netListener, err := net.Listen("tcp", s.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("unable to open metrics socket: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	s.metricsSrvr = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.log.WithField("addr", netListener.Addr().String()).Info("IPU plugin metrics listening on at:")
+	go func() {
+		if err := s.metricsSrvr.Serve(netListener); err != nil && err != http.ErrServerClosed {
+			serveErrs <- fmt.Errorf("failed to serve metrics: %w", err)
 		}
-		return listen, nil
-	} else if s.servingProto == "tcp" {
-		listen, err := net.Listen(s.servingProto, fmt.Sprintf("%s:%d", s.servingAddr, s.servingPort))
-		if err != nil {
-			log.Fatalf("failed to open TCP socket listener: %v", err)
-			return nil, fmt.Errorf("unable to open TCP socket")
+	}()
+	return nil
+}
+
+// watchBridgePortHealth reports the BridgePortService as SERVING while both the bridge and the uplink interface
+// exist, e.g.; it is NOT_SERVING once the bridge was deleted from under the plugin.
+func (s *server) watchBridgePortHealth(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		_, err := linkByNameFn(s.bridgeName)
+		if err == nil {
+			_, err = linkByNameFn(s.uplinkInterface)
+		}
+		s.health.setServing(bridgePortServiceFullName, err == nil)
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
 		}
-		return listen, nil
 	}
-	return nil, fmt.Errorf("unsupported serving protocol %s", s.servingProto)
+}
 
+func (s *server) Stop() {
+	s.stopOnce.Do(s.stop)
 }
 
-func (s *server) cleanUp() error {
-	if err := os.Remove(s.servingAddr); err != nil && !os.IsNotExist(err) {
-		return err
+func (s *server) stop() {
+	s.log.Info("Stopping IPU plugin")
+	s.health.shutdown()
+	close(s.stopCh)
+	drainListeners(s.listeners, s.getShutdownConfig().Timeout)
+	if s.mode == types.IpuMode {
+		s.applyShutdownPolicy()
 	}
-	return nil
+	if s.metricsSrvr != nil {
+		_ = s.metricsSrvr.Close()
+	}
+	s.vfWatcher.Stop()
+	s.deviceWatcher.Stop()
+	s.log.Info("IPU plugin has stopped")
 }
@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	newPlugin := func(brCtlr types.BridgeController, listeners ...ListenerConfig) *server {
+		s, err := NewIpuPlugin(brCtlr, nil, Config{Mode: types.HostMode, Listeners: listeners})
+		Expect(err).NotTo(HaveOccurred())
+		return s.(*server)
+	}
+
+	It("should return the bridge error instead of exiting", func() {
+		bridgeErr := fmt.Errorf("bridge br-tenant not found")
+		s := newPlugin(&mockBrCtlr{fnCalled: "EnsureBridgeExists", retValues: []interface{}{bridgeErr}},
+			ListenerConfig{Proto: "unix", Addr: filepath.Join(GinkgoT().TempDir(), "plugin.sock")})
+		err := s.Run(context.Background())
+		Expect(errors.Is(err, bridgeErr)).To(BeTrue())
+	})
+
+	It("should return the listener error instead of exiting", func() {
+		inUse, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer inUse.Close()
+		s := newPlugin(&mockBrCtlr{}, ListenerConfig{Proto: "tcp", Addr: "127.0.0.1",
+			Port: inUse.Addr().(*net.TCPAddr).Port, AllowInsecureTCP: true})
+		err = s.Run(context.Background())
+		Expect(errors.Is(err, syscall.EADDRINUSE)).To(BeTrue(), "%v", err)
+	})
+
+	It("should stop when the context is cancelled", func() {
+		sockPath := filepath.Join(GinkgoT().TempDir(), "plugin.sock")
+		s := newPlugin(&mockBrCtlr{}, ListenerConfig{Proto: "unix", Addr: sockPath})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+		Eventually(sockPath).Should(BeAnExistingFile())
+
+		cancel()
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sockPath).NotTo(BeAnExistingFile())
+	})
+})
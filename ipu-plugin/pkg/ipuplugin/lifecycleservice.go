@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/p4rtclient"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	pb "github.com/openshift/dpu-operator/dpu-api/gen"
@@ -34,8 +35,6 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/crypto/ssh"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type LifeCycleServiceServer struct {
@@ -45,6 +44,8 @@ type LifeCycleServiceServer struct {
 	daemonPort   int
 	mode         string
 	p4rtbin      string
+	vfWatcher    *VfWatcher
+	health       *healthStatus
 }
 
 const (
@@ -57,13 +58,16 @@ const (
 	last_byte_mac_range = 239
 )
 
-func NewLifeCycleService(daemonHostIp, daemonIpuIp string, daemonPort int, mode string, p4rtbin string) *LifeCycleServiceServer {
+func NewLifeCycleService(daemonHostIp, daemonIpuIp string, daemonPort int, mode string, p4rtbin string, vfWatcher *VfWatcher,
+	health *healthStatus) *LifeCycleServiceServer {
 	return &LifeCycleServiceServer{
 		daemonHostIp: daemonHostIp,
 		daemonIpuIp:  daemonIpuIp,
 		daemonPort:   daemonPort,
 		mode:         mode,
 		p4rtbin:      p4rtbin,
+		vfWatcher:    vfWatcher,
+		health:       health,
 	}
 }
 
@@ -99,7 +103,7 @@ func (fs *FileSystemHandlerImpl) GetVendor(iface string) ([]byte, error) {
 }
 
 type ExecutableHandler interface {
-	validate() bool
+	validate(ctx context.Context) bool
 	nmcliSetupIpAddress(link netlink.Link, ipStr string, ipAddr *netlink.Addr) error
 }
 
@@ -112,7 +116,7 @@ type SSHHandler interface {
 type SSHHandlerImpl struct{}
 
 type FXPHandler interface {
-	configureFXP(p4rtbin string) error
+	configureFXP(ctx context.Context, p4rtbin string) ([]string, error)
 }
 
 type FXPHandlerImpl struct{}
@@ -387,26 +391,27 @@ func checkIdpfNetDevices(mode string) {
 	}
 }
 
-func configureChannel(mode, daemonHostIp, daemonIpuIp string) error {
+func configureChannel(ctx context.Context, mode, daemonHostIp, daemonIpuIp string) error {
+	logger := tracing.Logger(ctx)
 
 	var pfList []netlink.Link
 
 	if err := GetFilteredPFs(&pfList); err != nil {
-		fmt.Printf("configureChannel: err->%v from GetFilteredPFs", err)
-		return status.Error(codes.Internal, err.Error())
+		logger.Errorf("configureChannel: err->%v from GetFilteredPFs", err)
+		return internalError(reasonHostConfig, err)
 	}
 
 	pf, err := getCommPf(mode, pfList)
 
 	if pf == nil {
 		// Address already set - we don't proceed with setting the ip
-		fmt.Printf("configureChannel: pf nil from getCommPf\n")
+		logger.Infof("configureChannel: pf nil from getCommPf")
 		return nil
 	}
 
 	if err != nil {
-		fmt.Printf("configureChannel: err->%v from getCommPf\n", err)
-		return status.Error(codes.Internal, err.Error())
+		logger.Errorf("configureChannel: err->%v from getCommPf", err)
+		return internalError(reasonHostConfig, err)
 	}
 
 	var ip string
@@ -418,8 +423,8 @@ func configureChannel(mode, daemonHostIp, daemonIpuIp string) error {
 	}
 
 	if err := setIP(pf, ip); err != nil {
-		fmt.Printf("configureChannel: err->%v from setIP", err)
-		return status.Error(codes.Internal, err.Error())
+		logger.Errorf("configureChannel: err->%v from setIP", err)
+		return internalError(reasonHostConfig, err)
 	}
 
 	return nil
@@ -649,68 +654,73 @@ func checkIfMACIsSet() (bool, string) {
 	}
 }
 
-func (e *ExecutableHandlerImpl) validate() bool {
+func (e *ExecutableHandlerImpl) validate(ctx context.Context) bool {
+	logger := tracing.Logger(ctx)
 
 	if numAPFs := countAPFDevices(); numAPFs < apfNumber {
-		fmt.Printf("Not enough APFs %v", numAPFs)
+		logger.Warnf("Not enough APFs %v", numAPFs)
 		return false
 	}
 
 	if macPreFix, mac := checkIfMACIsSet(); !macPreFix {
-		fmt.Printf("incorrect Mac assigned : %v\n", mac)
+		logger.Warnf("incorrect Mac assigned : %v", mac)
 		return false
 	}
 
 	return true
 }
 
-func (s *FXPHandlerImpl) configureFXP(p4rtbin string) error {
-	vfMacList, err := utils.GetVfMacList()
-
+// configureFXP programs the point-to-point rules between host VFs and returns the VFs it programmed
+func (s *FXPHandlerImpl) configureFXP(ctx context.Context, p4rtbin string) ([]string, error) {
+	vfMacList, err := getVfMacList()
 	if err != nil {
-		return fmt.Errorf("unable to reach the IMC %v", err)
-	}
-
-	if len(vfMacList) == 0 {
-		return fmt.Errorf("no NFs initialized on the host")
+		return nil, err
 	}
 
-	p4rtclient.DeletePointToPointVFRules(p4rtbin, vfMacList)
-	p4rtclient.CreatePointToPointVFRules(p4rtbin, vfMacList)
+	p4rtclient.DeletePointToPointVFRules(ctx, p4rtbin, vfMacList)
+	p4rtclient.CreatePointToPointVFRules(ctx, p4rtbin, vfMacList)
 
-	return nil
+	return vfMacList, nil
 }
 
 func (s *LifeCycleServiceServer) Init(ctx context.Context, in *pb.InitRequest) (*pb.IpPort, error) {
 	InitHandlers()
+	logger := tracing.Logger(ctx)
 
 	if in.DpuMode && s.mode != types.IpuMode || !in.DpuMode && s.mode != types.HostMode {
-		return nil, status.Errorf(codes.Internal, "Ipu plugin running in %s mode", s.mode)
+		return nil, failedPrecondition("MODE", "dpu_mode", fmt.Sprintf("Ipu plugin running in %s mode", s.mode))
 	}
 
 	if in.DpuMode {
-		if val := executableHandler.validate(); !val {
-			log.Info("forcing state")
+		if val := executableHandler.validate(ctx); !val {
+			logger.Info("forcing state")
 			if err := sshHandler.sshFunc(); err != nil {
-				return nil, fmt.Errorf("error calling sshFunc %s", err)
+				return nil, imcUnavailable(fmt.Errorf("error calling sshFunc %s", err))
 			}
 		} else {
-			log.Info("not forcing state")
+			logger.Info("not forcing state")
 		}
 
 		// Preconfigure the FXP with point-to-point rules between host VFs
-		if err := fxpHandler.configureFXP(s.p4rtbin); err != nil {
-			return nil, status.Errorf(codes.Internal, "Error when preconfiguring the FXP: %v", err)
+		resume := s.vfWatcher.pause()
+		vfMacList, err := fxpHandler.configureFXP(ctx, s.p4rtbin)
+		resume()
+		if err != nil {
+			return nil, internalError(reasonHostConfig, err)
 		}
+
+		// Keep the FXP rules in sync with VFs created or removed after Init
+		s.vfWatcher.Start(vfMacList)
 	}
 
 	checkIdpfNetDevices(s.mode)
 
-	if err := configureChannel(s.mode, s.daemonHostIp, s.daemonIpuIp); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := configureChannel(ctx, s.mode, s.daemonHostIp, s.daemonIpuIp); err != nil {
+		return nil, err
 	}
 
 	response := &pb.IpPort{Ip: s.daemonIpuIp, Port: int32(s.daemonPort)}
+	s.health.setServing(lifeCycleServiceFullName, true)
 
 	return response, nil
 }
@@ -23,6 +23,8 @@ import (
 	. "github.com/onsi/gomega"
 	pb "github.com/openshift/dpu-operator/dpu-api/gen"
 	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var _ = Describe("basic functionality", Serial, func() {
@@ -70,7 +72,7 @@ var _ = Describe("basic functionality", Serial, func() {
 				Expect(err.Error()).To(ContainSubstring("Method added for test purposes"))
 			})
 			It("it should configure the communication channel without any errors", func() {
-				err := configureChannel("ipu", "192.168.1.1", "192.168.1.2")
+				err := configureChannel(context.TODO(), "ipu", "192.168.1.1", "192.168.1.2")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("Method added for test purposes"))
 			})
@@ -78,7 +80,7 @@ var _ = Describe("basic functionality", Serial, func() {
 
 				networkHandler = &MockNetworkHandler2Impl{}
 
-				err := configureChannel("host", "192.168.1.1", "192.168.1.2")
+				err := configureChannel(context.TODO(), "host", "192.168.1.1", "192.168.1.2")
 				Expect(err).ToNot(HaveOccurred())
 
 				// reset the network handler
@@ -97,7 +99,7 @@ var _ = Describe("basic functionality", Serial, func() {
 			It("the server should return a valid response", func() {
 
 				// create valid licycle service
-				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "ipu", "fakebinary")
+				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "ipu", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
@@ -108,19 +110,20 @@ var _ = Describe("basic functionality", Serial, func() {
 			It("the server should return an error if the plugin runs in a different mode", func() {
 
 				// create valid licycle service
-				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "host", "fakebinary")
+				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "host", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("Ipu plugin running in host mode"))
+				Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
 			})
 		})
 		Context("and a request is made to a misconfigured LifeCycleService", func() {
 			It("the server should return a not a valid IPv4 address when daemonIpuIp is invalid", func() {
 
 				// create invalid licycle service
-				service := NewLifeCycleService("", "192.168.1", 50151, "ipu", "fakebinary")
+				service := NewLifeCycleService("", "192.168.1", 50151, "ipu", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
@@ -140,7 +143,7 @@ var _ = Describe("basic functionality", Serial, func() {
 			It("the server should return a valid response", func() {
 
 				// create valid licycle service
-				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "host", "fakebinary")
+				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "host", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
@@ -151,19 +154,20 @@ var _ = Describe("basic functionality", Serial, func() {
 			It("the server should return an error if the plugin runs in a different mode", func() {
 
 				// create valid licycle service
-				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "ipu", "fakebinary")
+				service := NewLifeCycleService("192.168.1.1", "192.168.1.2", 50151, "ipu", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("Ipu plugin running in ipu mode"))
+				Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
 			})
 		})
 		Context("and a request is made to a misconfigured LifeCycleService", func() {
 			It("the server should return a not a valid IPv4 address as daemonHostIp is invalid", func() {
 
 				// create invalid licycle service
-				service := NewLifeCycleService("192.168.1", "", 50151, "host", "fakebinary")
+				service := NewLifeCycleService("192.168.1", "", 50151, "host", "fakebinary", nil, nil)
 
 				_, err := service.Init(context.Background(), request)
 
@@ -254,7 +258,7 @@ func (fs *MockFileSystemHandlerImpl) GetVendor(name string) ([]byte, error) {
 
 type MockExecutableHandlerImpl struct{}
 
-func (m *MockExecutableHandlerImpl) validate() bool {
+func (m *MockExecutableHandlerImpl) validate(ctx context.Context) bool {
 	return true
 }
 
@@ -264,6 +268,6 @@ func (e *MockExecutableHandlerImpl) nmcliSetupIpAddress(link netlink.Link, ipStr
 
 type MockFXPHandlerImpl struct{}
 
-func (m *MockFXPHandlerImpl) configureFXP(p4rtbin string) error {
-	return nil
+func (m *MockFXPHandlerImpl) configureFXP(ctx context.Context, p4rtbin string) ([]string, error) {
+	return []string{}, nil
 }
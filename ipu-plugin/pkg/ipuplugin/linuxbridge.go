@@ -15,8 +15,11 @@
 package ipuplugin
 
 import (
+	"context"
 	"fmt"
+	"net"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -24,17 +27,23 @@ import (
 
 var (
 	// Abstract netlink functions for unit tests
-	linkByNameFn      = netlink.LinkByName
-	linkAddFn         = netlink.LinkAdd
-	linkDelFn         = netlink.LinkDel
-	linkSetUpFn       = netlink.LinkSetUp
-	linkSetDownFn     = netlink.LinkSetDown
-	linkSetMasterFn   = netlink.LinkSetMaster
-	linkSetNoMasterFn = netlink.LinkSetNoMaster
+	linkByNameFn             = netlink.LinkByName
+	linkAddFn                = netlink.LinkAdd
+	linkDelFn                = netlink.LinkDel
+	linkSetUpFn              = netlink.LinkSetUp
+	linkSetDownFn            = netlink.LinkSetDown
+	linkSetMasterFn          = netlink.LinkSetMaster
+	linkSetNoMasterFn        = netlink.LinkSetNoMaster
+	linkListFn               = netlink.LinkList
+	bridgeVlanAddFn          = netlink.BridgeVlanAdd
+	bridgeVlanListFn         = netlink.BridgeVlanList
+	bridgeVlanDelFn          = netlink.BridgeVlanDel
+	bridgeSetVlanFilteringFn = netlink.BridgeSetVlanFiltering
 )
 
 type linuxBridge struct {
-	brName string
+	brName        string
+	vlanFiltering bool
 }
 
 func NewLinuxBridgeController(bridge string) types.BridgeController {
@@ -43,6 +52,15 @@ func NewLinuxBridgeController(bridge string) types.BridgeController {
 	}
 }
 
+// NewLinuxVlanBridgeController returns a controller of a linux bridge with vlan_filtering enabled, whose
+// BridgePorts are VLANs of a single trunk port instead of one VLAN sub-interface each
+func NewLinuxVlanBridgeController(bridge string) types.BridgeController {
+	return &linuxBridge{
+		brName:        bridge,
+		vlanFiltering: true,
+	}
+}
+
 func (b *linuxBridge) EnsureBridgeExists() error {
 	if b.brName == "" {
 		return fmt.Errorf("bridge name is empty")
@@ -50,8 +68,12 @@ func (b *linuxBridge) EnsureBridgeExists() error {
 	br, err := linkByNameFn(b.brName)
 	if err == nil {
 		if br.Type() == "bridge" {
-			// Bridge exists; nothing to do
 			log.Infof("bridge %s found", b.brName)
+			if b.vlanFiltering {
+				if err := bridgeSetVlanFilteringFn(br, true); err != nil {
+					return fmt.Errorf("error enabling vlan filtering on bridge %s: %w", b.brName, err)
+				}
+			}
 			return nil
 		} else {
 			// a link is found with same name but not a bridge
@@ -68,6 +90,9 @@ func (b *linuxBridge) createBridge() error {
 
 	br := &netlink.Bridge{}
 	br.Name = b.brName
+	if b.vlanFiltering {
+		br.VlanFiltering = &b.vlanFiltering
+	}
 
 	if err := linkAddFn(br); err != nil {
 		return fmt.Errorf("error creating bridge %s: %s", b.brName, err.Error())
@@ -80,15 +105,14 @@ func (b *linuxBridge) createBridge() error {
 	return nil
 }
 
-func (b *linuxBridge) AddPort(portName string) error {
+func (b *linuxBridge) AddPort(ctx context.Context, portName string) error {
 	link, err := linkByNameFn(portName)
 	if err != nil {
 		return fmt.Errorf("unable to find vlan interface: %s, because: %w", portName, err)
 	}
 
-	vLink, ok := link.(*netlink.Vlan)
-	if !ok {
-		return fmt.Errorf("interface %s type is not vlan type", portName)
+	if err := checkPortType(link); err != nil {
+		return err
 	}
 
 	br, err := linkByNameFn(b.brName)
@@ -96,38 +120,143 @@ func (b *linuxBridge) AddPort(portName string) error {
 		return fmt.Errorf("unable to find bridge %s: %w", b.brName, err)
 	}
 
-	if err := linkSetMasterFn(vLink, br); err != nil {
+	if err := linkSetMasterFn(link, br); err != nil {
 		return fmt.Errorf("error adding vlan interface %s to bridge %s: %s", portName, b.brName, err.Error())
 	}
 
-	if err := linkSetUpFn(vLink); err != nil {
+	if err := linkSetUpFn(link); err != nil {
 		return fmt.Errorf("error bringing interface %s up: %s", portName, err.Error())
 	}
-	log.WithField("portName", portName).Infof("port added to linux bridge %s", b.brName)
+	tracing.Logger(ctx).WithField("portName", portName).Infof("port added to linux bridge %s", b.brName)
 
 	return nil
 }
 
-func (b *linuxBridge) DeletePort(portName string) error {
+func (b *linuxBridge) DeletePort(ctx context.Context, portName string) error {
 
 	link, err := linkByNameFn(portName)
 	if err != nil {
 		return fmt.Errorf("unable to find vlan interface: %s, because: %w", portName, err)
 	}
 
-	vLink, ok := link.(*netlink.Vlan)
-	if !ok {
-		return fmt.Errorf("interface %s type is not vlan type", portName)
+	if err := checkPortType(link); err != nil {
+		return err
 	}
 
-	if err := linkSetNoMasterFn(vLink); err != nil {
+	if err := linkSetNoMasterFn(link); err != nil {
 		return fmt.Errorf("error removing vlan interface %s from bridge %s: %s", portName, b.brName, err.Error())
 	}
 
-	if err := linkSetDownFn(vLink); err != nil {
+	if err := linkSetDownFn(link); err != nil {
 		return fmt.Errorf("error bringing interface %s down: %s", portName, err.Error())
 	}
-	log.WithField("portName", portName).Infof("port deleted from linux bridge %s", b.brName)
+	tracing.Logger(ctx).WithField("portName", portName).Infof("port deleted from linux bridge %s", b.brName)
+
+	return nil
+}
+
+// checkPortType returns an error unless link is a vlan interface, or the VXLAN device of a logical bridge
+func checkPortType(link netlink.Link) error {
+	switch link.(type) {
+	case *netlink.Vlan, *netlink.Vxlan:
+		return nil
+	}
+	return fmt.Errorf("interface %s type is not vlan type", link.Attrs().Name)
+}
+
+func (b *linuxBridge) ListPorts(ctx context.Context) ([]string, error) {
+	br, err := linkByNameFn(b.brName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find bridge %s: %w", b.brName, err)
+	}
+	links, err := linkListFn()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list links: %w", err)
+	}
+	ports := []string{}
+	for _, link := range links {
+		if link.Attrs().MasterIndex == br.Attrs().Index {
+			ports = append(ports, link.Attrs().Name)
+		}
+	}
+	return ports, nil
+}
+
+func (b *linuxBridge) HasPort(ctx context.Context, portName string) (bool, error) {
+	br, err := linkByNameFn(b.brName)
+	if err != nil {
+		return false, fmt.Errorf("unable to find bridge %s: %w", b.brName, err)
+	}
+	link, err := linkByNameFn(portName)
+	if err != nil {
+		// The interface does not exist
+		return false, nil
+	}
+	return link.Attrs().MasterIndex == br.Attrs().Index, nil
+}
 
+// SetPortVlan adds the VLAN to the port, it is only enforced once vlan_filtering is enabled on the bridge. An
+// untagged VLAN becomes the PVID of the port.
+func (b *linuxBridge) SetPortVlan(ctx context.Context, portName string, vid int, tagged bool) error {
+	if vid < 1 || vid > 4094 {
+		return fmt.Errorf("invalid vlan %d, vlan must be within 1-4094 range", vid)
+	}
+	link, err := linkByNameFn(portName)
+	if err != nil {
+		return fmt.Errorf("unable to find interface: %s, because: %w", portName, err)
+	}
+	if err := bridgeVlanAddFn(link, uint16(vid), !tagged, !tagged, false, true); err != nil {
+		return fmt.Errorf("error adding vlan %d to port %s: %w", vid, portName, err)
+	}
+	tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid, "tagged": tagged}).
+		Infof("vlan set on linux bridge %s port", b.brName)
 	return nil
 }
+
+// RemovePortVlan removes the VLAN from the port, removing a VLAN the port is not a member of is not an error
+func (b *linuxBridge) RemovePortVlan(ctx context.Context, portName string, vid int) error {
+	link, err := linkByNameFn(portName)
+	if err != nil {
+		return fmt.Errorf("unable to find interface: %s, because: %w", portName, err)
+	}
+	vlans, err := bridgeVlanListFn()
+	if err != nil {
+		return fmt.Errorf("unable to list bridge vlans: %w", err)
+	}
+	for _, info := range vlans[int32(link.Attrs().Index)] {
+		if int(info.Vid) != vid {
+			continue
+		}
+		if err := bridgeVlanDelFn(link, uint16(vid), info.PortVID(), info.EngressUntag(), false, true); err != nil {
+			return fmt.Errorf("error removing vlan %d from port %s: %w", vid, portName, err)
+		}
+		tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid}).
+			Infof("vlan removed from linux bridge %s port", b.brName)
+	}
+	return nil
+}
+
+func (b *linuxBridge) PortStatus(ctx context.Context, portName string) (*types.PortStatus, error) {
+	link, err := linkByNameFn(portName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find interface: %s, because: %w", portName, err)
+	}
+	attrs := link.Attrs()
+	status := &types.PortStatus{
+		Name:    portName,
+		AdminUp: attrs.Flags&net.FlagUp != 0,
+		OperUp:  attrs.OperState == netlink.OperUp,
+	}
+	vlans, err := bridgeVlanListFn()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list bridge vlans: %w", err)
+	}
+	for _, info := range vlans[int32(attrs.Index)] {
+		if info.PortVID() && info.EngressUntag() {
+			status.AccessVlan = int(info.Vid)
+		} else if !info.EngressUntag() {
+			status.TaggedVlans = append(status.TaggedVlans, int(info.Vid))
+		}
+	}
+	return status, nil
+}
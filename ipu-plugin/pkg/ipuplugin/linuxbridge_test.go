@@ -15,11 +15,15 @@
 package ipuplugin
 
 import (
+	"context"
 	"fmt"
+	"net"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 )
 
 // Run tests specs in serial as we cannot share netlink function pointers across different specs
@@ -68,7 +72,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 		Context("when vport interface look up returns error", func() {
 			It("should return error", func() {
 				linkByNameFn = fakeLinkByNameWithErr
-				Expect(brCtlr.AddPort("fakeVlan")).To(HaveOccurred())
+				Expect(brCtlr.AddPort(context.TODO(), "fakeVlan")).To(HaveOccurred())
 			})
 		})
 
@@ -79,7 +83,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 					link.Name = name
 					return link, nil
 				}
-				err := brCtlr.AddPort("fakeVlan")
+				err := brCtlr.AddPort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("type is not vlan type"))
 			})
@@ -93,7 +97,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 					return link, nil
 				}
 				linkSetMasterFn = fakeLinkSetMasterWithErr
-				err := brCtlr.AddPort("fakeVlan")
+				err := brCtlr.AddPort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error adding vlan interface fakeVlan to bridge fakeBr"))
 			})
@@ -108,7 +112,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 				}
 				linkSetMasterFn = fakeLinkSetMaster
 				linkSetUpFn = fakeLinkSetUpWithErr
-				err := brCtlr.AddPort("fakeVlan")
+				err := brCtlr.AddPort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error bringing interface fakeVlan up"))
 			})
@@ -123,7 +127,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 				}
 				linkSetMasterFn = fakeLinkSetMaster
 				linkSetUpFn = fakeLinkSetUp
-				err := brCtlr.AddPort("fakeVlan")
+				err := brCtlr.AddPort(context.TODO(), "fakeVlan")
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -137,7 +141,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 		Context("when vport interface look up returns error", func() {
 			It("should return error", func() {
 				linkByNameFn = fakeLinkByNameWithErr
-				Expect(brCtlr.DeletePort("fakeVlan")).To(HaveOccurred())
+				Expect(brCtlr.DeletePort(context.TODO(), "fakeVlan")).To(HaveOccurred())
 			})
 		})
 
@@ -148,7 +152,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 					link.Name = name
 					return link, nil
 				}
-				err := brCtlr.DeletePort("fakeVlan")
+				err := brCtlr.DeletePort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("type is not vlan type"))
 			})
@@ -162,7 +166,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 					return link, nil
 				}
 				linkSetNoMasterFn = fakeLinkSetNoMasterWithErr
-				err := brCtlr.DeletePort("fakeVlan")
+				err := brCtlr.DeletePort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error removing vlan interface fakeVlan from bridge fakeBr"))
 			})
@@ -177,7 +181,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 				}
 				linkSetNoMasterFn = fakeLinkSetNoMaster
 				linkSetDownFn = fakeLinkSetDownWithErr
-				err := brCtlr.DeletePort("fakeVlan")
+				err := brCtlr.DeletePort(context.TODO(), "fakeVlan")
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring("error bringing interface fakeVlan down"))
 			})
@@ -192,7 +196,7 @@ var _ = Describe("linuxBridge", Serial, func() {
 				}
 				linkSetNoMasterFn = fakeLinkSetNoMaster
 				linkSetDownFn = fakeLinkSetDown
-				err := brCtlr.DeletePort("fakeVlan")
+				err := brCtlr.DeletePort(context.TODO(), "fakeVlan")
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
@@ -244,6 +248,129 @@ var _ = Describe("linuxBridge", Serial, func() {
 			})
 		})
 	})
+	Describe("vlan filtering", Serial, func() {
+		brCtlr := NewLinuxVlanBridgeController("fakeBr").(*linuxBridge)
+		var filtering []bool
+
+		BeforeEach(func() {
+			filtering = nil
+			bridgeSetVlanFilteringFn = func(link netlink.Link, on bool) error {
+				filtering = append(filtering, on)
+				return nil
+			}
+			DeferCleanup(func() { bridgeSetVlanFilteringFn = netlink.BridgeSetVlanFiltering })
+		})
+
+		It("should create the bridge with vlan filtering", func() {
+			var created netlink.Link
+			linkByNameFn = fakeLinkByNameWithErr
+			linkAddFn = func(link netlink.Link) error {
+				created = link
+				return nil
+			}
+			linkSetUpFn = fakeLinkSetUp
+			Expect(brCtlr.EnsureBridgeExists()).To(Succeed())
+			Expect(created.(*netlink.Bridge).VlanFiltering).To(HaveValue(BeTrue()))
+		})
+
+		It("should enable vlan filtering on an existing bridge", func() {
+			linkByNameFn = func(name string) (netlink.Link, error) {
+				return &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}, nil
+			}
+			Expect(brCtlr.EnsureBridgeExists()).To(Succeed())
+			Expect(filtering).To(Equal([]bool{true}))
+
+			// The subinterface mode leaves the bridge as it is
+			Expect(NewLinuxBridgeController("fakeBr").EnsureBridgeExists()).To(Succeed())
+			Expect(filtering).To(Equal([]bool{true}))
+		})
+	})
+	Describe("port queries", Serial, func() {
+		brCtlr := &linuxBridge{
+			brName: "fakeBr",
+		}
+		type vlanAdd struct {
+			name           string
+			vid            uint16
+			pvid, untagged bool
+		}
+		var added []vlanAdd
+
+		BeforeEach(func() {
+			br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "fakeBr", Index: 2}}
+			port := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "d3.0.100", Index: 10, MasterIndex: 2,
+				Flags: net.FlagUp, OperState: netlink.OperUp}}
+			other := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "enp0s1f0d3", Index: 1}}
+			links := []netlink.Link{br, port, other}
+			linkByNameFn = func(name string) (netlink.Link, error) {
+				for _, l := range links {
+					if l.Attrs().Name == name {
+						return l, nil
+					}
+				}
+				return nil, fmt.Errorf("link %s not found", name)
+			}
+			linkListFn = func() ([]netlink.Link, error) { return links, nil }
+			added = nil
+			bridgeVlanAddFn = func(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+				added = append(added, vlanAdd{link.Attrs().Name, vid, pvid, untagged})
+				return nil
+			}
+			bridgeVlanListFn = func() (map[int32][]*nl.BridgeVlanInfo, error) {
+				return map[int32][]*nl.BridgeVlanInfo{10: {
+					{Vid: 100, Flags: nl.BRIDGE_VLAN_INFO_PVID | nl.BRIDGE_VLAN_INFO_UNTAGGED},
+					{Vid: 200},
+					{Vid: 300},
+				}}, nil
+			}
+			DeferCleanup(func() {
+				linkListFn = netlink.LinkList
+				bridgeVlanAddFn = netlink.BridgeVlanAdd
+				bridgeVlanListFn = netlink.BridgeVlanList
+			})
+		})
+
+		It("should list the links enslaved to the bridge", func() {
+			Expect(brCtlr.ListPorts(context.TODO())).To(Equal([]string{"d3.0.100"}))
+			Expect(brCtlr.HasPort(context.TODO(), "d3.0.100")).To(BeTrue())
+			Expect(brCtlr.HasPort(context.TODO(), "enp0s1f0d3")).To(BeFalse())
+			Expect(brCtlr.HasPort(context.TODO(), "d3.0.101")).To(BeFalse())
+		})
+
+		It("should add the vlan to the port as its pvid unless tagged", func() {
+			Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.100", 100, false)).To(Succeed())
+			Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.100", 200, true)).To(Succeed())
+			Expect(added).To(Equal([]vlanAdd{{"d3.0.100", 100, true, true}, {"d3.0.100", 200, false, false}}))
+			Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.100", 4095, true)).NotTo(Succeed())
+		})
+
+		It("should remove the vlan from the port with its flags", func() {
+			type vlanDel struct {
+				vid            uint16
+				pvid, untagged bool
+			}
+			var deleted []vlanDel
+			bridgeVlanDelFn = func(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+				deleted = append(deleted, vlanDel{vid, pvid, untagged})
+				return nil
+			}
+			DeferCleanup(func() { bridgeVlanDelFn = netlink.BridgeVlanDel })
+
+			Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.100", 100)).To(Succeed())
+			Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.100", 200)).To(Succeed())
+			// Not a member of the vlan
+			Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.100", 400)).To(Succeed())
+			Expect(deleted).To(Equal([]vlanDel{{100, true, true}, {200, false, false}}))
+			Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.101", 100)).NotTo(Succeed())
+		})
+
+		It("should return the port state and vlans", func() {
+			status, err := brCtlr.PortStatus(context.TODO(), "d3.0.100")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(&types.PortStatus{Name: "d3.0.100", AdminUp: true, OperUp: true,
+				AccessVlan: 100, TaggedVlans: []int{200, 300}}))
+		})
+	})
 })
 
 // Mock netlink functions for testing
@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Names of the services that can be exposed on a listener
+const (
+	LifeCycleServiceName       = "LifeCycleService"
+	BridgePortServiceName      = "BridgePortService"
+	NetworkFunctionServiceName = "NetworkFunctionService"
+	DeviceServiceName          = "DeviceService"
+	LogicalBridgeServiceName   = "LogicalBridgeService"
+	VrfServiceName             = "VrfService"
+	VfEventServiceName         = "VfEventService"
+)
+
+var allServices = []string{LifeCycleServiceName, BridgePortServiceName, NetworkFunctionServiceName, DeviceServiceName,
+	LogicalBridgeServiceName, VrfServiceName, VfEventServiceName}
+
+// ListenerConfig defines an address the gRPC services are served on
+type ListenerConfig struct {
+	Name string `mapstructure:"name"`
+	// Proto is "unix" or "tcp"
+	Proto string `mapstructure:"proto"`
+	// Addr is the socket path for "unix", or the host to listen on for "tcp"
+	Addr string `mapstructure:"addr"`
+	Port int    `mapstructure:"port"`
+	// Services exposed on this listener, all the services are exposed when empty
+	Services         []string  `mapstructure:"services"`
+	TLS              TLSConfig `mapstructure:"tls"`
+	AllowInsecureTCP bool      `mapstructure:"allowInsecureTcp"`
+}
+
+func (c ListenerConfig) address() string {
+	if c.Proto == "tcp" {
+		return fmt.Sprintf("%s:%d", c.Addr, c.Port)
+	}
+	return c.Addr
+}
+
+// listener is a gRPC server serving a subset of the services on one address, with its own auth policy
+type listener struct {
+	cfg          ListenerConfig
+	log          *log.Entry
+	grpcSrvr     *grpc.Server
+	netListener  net.Listener
+	certReloader *certReloader
+}
+
+// newListener validates the listener configuration and creates its gRPC server
+func newListener(cfg ListenerConfig) (*listener, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Proto + ":" + cfg.address()
+	}
+	if cfg.Proto != "unix" && cfg.Proto != "tcp" {
+		return nil, fmt.Errorf("listener %s: unsupported serving protocol %s", cfg.Name, cfg.Proto)
+	}
+	if cfg.Addr == "" && cfg.Proto == "unix" {
+		return nil, fmt.Errorf("listener %s: socket path is empty", cfg.Name)
+	}
+	for _, svc := range cfg.Services {
+		if !slices.Contains(allServices, svc) {
+			return nil, fmt.Errorf("listener %s: unknown service %s, supported services are %v", cfg.Name, svc, allServices)
+		}
+	}
+
+	if err := cfg.TLS.Validate(); err != nil {
+		return nil, fmt.Errorf("listener %s: invalid TLS configuration: %w", cfg.Name, err)
+	}
+
+	l := &listener{cfg: cfg, log: log.WithField("listener", cfg.Name)}
+	var serverOpts []grpc.ServerOption
+	if cfg.TLS.Enabled() {
+		var err error
+		if l.certReloader, err = newCertReloader(cfg.TLS); err != nil {
+			return nil, fmt.Errorf("listener %s: invalid TLS configuration: %w", cfg.Name, err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(l.certReloader.serverTLSConfig())))
+	} else if cfg.Proto == "tcp" && !cfg.AllowInsecureTCP {
+		return nil, fmt.Errorf("listener %s: refusing to serve on TCP without TLS, set a TLS certificate and key or explicitly allow insecure TCP", cfg.Name)
+	}
+	// The otelgrpc handler starts the server span of each request, which the request logger is tagged with
+	serverOpts = append(serverOpts, grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(), metrics.UnaryServerInterceptor()))
+	l.grpcSrvr = grpc.NewServer(serverOpts...)
+	return l, nil
+}
+
+// serves returns whether service has to be registered on this listener
+func (l *listener) serves(service string) bool {
+	return len(l.cfg.Services) == 0 || slices.Contains(l.cfg.Services, service)
+}
+
+// listen opens the listener socket and starts serving, the error stopping the server early is sent to serveErrs
+func (l *listener) listen(serveErrs chan<- error) error {
+	if l.cfg.Proto == "unix" {
+		// Do clean up first
+		if err := l.cleanUp(); err != nil {
+			return err
+		}
+		socketDir := filepath.Dir(l.cfg.Addr)
+		if err := os.MkdirAll(socketDir, 0600); err != nil {
+			return fmt.Errorf("unable to create socket directory for listener %s: %w", l.cfg.Name, err)
+		}
+	}
+
+	netListener, err := net.Listen(l.cfg.Proto, l.cfg.address())
+	if err != nil {
+		return fmt.Errorf("unable to open %s socket for listener %s: %w", l.cfg.Proto, l.cfg.Name, err)
+	}
+	l.netListener = netListener
+
+	l.log.WithField("addr", netListener.Addr().String()).Info("IPU plugin server listening on at:")
+	go func() {
+		if err := l.grpcSrvr.Serve(netListener); err != nil {
+			serveErrs <- fmt.Errorf("failed to serve on listener %s: %w", l.cfg.Name, err)
+		}
+	}()
+	return nil
+}
+
+func (l *listener) stop() {
+	l.grpcSrvr.GracefulStop()
+	l.certReloader.Stop()
+	if l.netListener != nil {
+		_ = l.cleanUp()
+	}
+}
+
+// drainListeners stops the listeners, the in-flight requests are given until timeout to complete before being
+// cancelled. There is no deadline when timeout is 0.
+func drainListeners(listeners []*listener, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, l := range listeners {
+			wg.Add(1)
+			go func(l *listener) {
+				defer wg.Done()
+				l.stop()
+			}(l)
+		}
+		wg.Wait()
+	}()
+	if timeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("in-flight requests did not complete within %v, cancelling them", timeout)
+		for _, l := range listeners {
+			// Closes the connections, which cancels the contexts of the in-flight requests
+			l.grpcSrvr.Stop()
+		}
+		<-done
+	}
+}
+
+func (l *listener) cleanUp() error {
+	if l.cfg.Proto != "unix" {
+		return nil
+	}
+	if err := os.Remove(l.cfg.Addr); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/openshift/dpu-operator/dpu-api/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// registeredServices returns the short names of the services registered on a listener
+func registeredServices(l *listener) []string {
+	names := []string{}
+	for name := range l.grpcSrvr.GetServiceInfo() {
+		names = append(names, name[strings.LastIndex(name, ".")+1:])
+	}
+	return names
+}
+
+var _ = Describe("Listeners", func() {
+	newPlugin := func(mode string, listeners ...ListenerConfig) (*server, error) {
+		s, err := NewIpuPlugin(nil, nil, Config{Mode: mode, Listeners: listeners})
+		if err != nil {
+			return nil, err
+		}
+		return s.(*server), nil
+	}
+
+	It("should refuse to serve on TCP without TLS unless allowed", func() {
+		_, err := newPlugin(types.HostMode, ListenerConfig{Proto: "tcp", Addr: "127.0.0.1"})
+		Expect(err).To(HaveOccurred())
+
+		_, err = newPlugin(types.HostMode, ListenerConfig{Proto: "tcp", Addr: "127.0.0.1", AllowInsecureTCP: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject invalid listener definitions", func() {
+		_, err := newPlugin(types.HostMode)
+		Expect(err).To(HaveOccurred())
+		_, err = newPlugin(types.HostMode, ListenerConfig{Proto: "udp", Addr: "127.0.0.1"})
+		Expect(err).To(HaveOccurred())
+		_, err = newPlugin(types.HostMode, ListenerConfig{Proto: "unix", Addr: "/tmp/a.sock", Services: []string{"FooService"}})
+		Expect(err).To(HaveOccurred())
+		_, err = newPlugin(types.HostMode, ListenerConfig{Name: "a", Proto: "unix", Addr: "/tmp/a.sock"},
+			ListenerConfig{Name: "a", Proto: "unix", Addr: "/tmp/b.sock"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should register the configured services on each listener", func() {
+		s, err := newPlugin(types.IpuMode,
+			ListenerConfig{Name: "local", Proto: "unix", Addr: "/tmp/a.sock"},
+			ListenerConfig{Name: "remote", Proto: "tcp", Addr: "127.0.0.1", AllowInsecureTCP: true,
+				Services: []string{DeviceServiceName, NetworkFunctionServiceName}})
+		Expect(err).NotTo(HaveOccurred())
+		s.registerServices()
+
+		Expect(registeredServices(s.listeners[0])).To(ConsistOf(
+			LifeCycleServiceName, BridgePortServiceName, NetworkFunctionServiceName, DeviceServiceName,
+			LogicalBridgeServiceName, VrfServiceName, VfEventServiceName, "Health"))
+		Expect(registeredServices(s.listeners[1])).To(ConsistOf(NetworkFunctionServiceName, DeviceServiceName, "Health"))
+	})
+
+	It("should not register the IPU services in host mode", func() {
+		s, err := newPlugin(types.HostMode, ListenerConfig{Proto: "unix", Addr: "/tmp/a.sock"})
+		Expect(err).NotTo(HaveOccurred())
+		s.registerServices()
+		Expect(registeredServices(s.listeners[0])).To(ConsistOf(LifeCycleServiceName, DeviceServiceName, "Health"))
+	})
+
+	It("should serve on a unix socket and a TCP address at the same time", func() {
+		sockPath := filepath.Join(GinkgoT().TempDir(), "plugin.sock")
+		s, err := newPlugin(types.HostMode,
+			ListenerConfig{Name: "local", Proto: "unix", Addr: sockPath},
+			ListenerConfig{Name: "remote", Proto: "tcp", Addr: "127.0.0.1", AllowInsecureTCP: true,
+				Services: []string{LifeCycleServiceName}})
+		Expect(err).NotTo(HaveOccurred())
+		s.registerServices()
+		for _, l := range s.listeners {
+			Expect(l.listen(make(chan error, 1))).To(Succeed())
+		}
+		DeferCleanup(s.Stop)
+
+		unixConn, err := grpc.NewClient("unix://"+sockPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		defer unixConn.Close()
+		_, err = pb.NewDeviceServiceClient(unixConn).GetDevices(context.Background(), &pb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+
+		tcpConn, err := grpc.NewClient(s.listeners[1].netListener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		defer tcpConn.Close()
+		_, err = pb.NewDeviceServiceClient(tcpConn).GetDevices(context.Background(), &pb.Empty{})
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+	})
+})
@@ -0,0 +1,246 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// logicalBridgePrefix is the prefix of the LogicalBridge resource names, e.g.; "logicalBridges/blue"
+	logicalBridgePrefix = "logicalBridges/"
+	// maxVni is the highest 24 bits VXLAN network identifier
+	maxVni = 1<<24 - 1
+)
+
+// CreateLogicalBridge registers a LogicalBridge that BridgePorts can then refer to by name. Creating it again
+// with the same spec returns the existing one.
+func (s *server) CreateLogicalBridge(ctx context.Context, in *pb.CreateLogicalBridgeRequest) (*pb.LogicalBridge, error) {
+	tracing.Logger(ctx).WithField("CreateLogicalBridgeRequest", in).Info("CreateLogicalBridge")
+	if in.LogicalBridge == nil {
+		return nil, invalidArgument(fieldViolation("logical_bridge", "logical bridge is not provided"))
+	}
+	if err := validateResourceId("logical_bridge_id", in.LogicalBridgeId); err != nil {
+		return nil, err
+	}
+	name := logicalBridgePrefix + in.LogicalBridgeId
+
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	if bridge, ok := s.logicalBridges.get(name); ok {
+		if proto.Equal(bridge.Spec, in.LogicalBridge.Spec) {
+			return bridge, nil
+		}
+		return nil, alreadyExists(logicalBridgeResource, name,
+			fmt.Sprintf("logical bridge %s already exists with another spec", name))
+	}
+	bridge := &pb.LogicalBridge{
+		Name:   name,
+		Spec:   in.LogicalBridge.Spec,
+		Status: &pb.LogicalBridgeStatus{OperStatus: pb.LBOperStatus_LB_OPER_STATUS_UP},
+	}
+	if err := s.validateLogicalBridge(bridge); err != nil {
+		return nil, err
+	}
+	if bridge.Spec.Vni != nil {
+		if err := s.addVxlan(ctx, bridge); err != nil {
+			return nil, internalError(reasonNetlink,
+				fmt.Errorf("unable to create the vxlan of logical bridge %s: %v", name, err))
+		}
+	}
+	s.logicalBridges.set(name, bridge)
+	s.persistState(ctx)
+	return proto.Clone(bridge).(*pb.LogicalBridge), nil
+}
+
+// DeleteLogicalBridge removes a LogicalBridge, which must not be used by a BridgePort anymore
+func (s *server) DeleteLogicalBridge(ctx context.Context, in *pb.DeleteLogicalBridgeRequest) (*emptypb.Empty, error) {
+	tracing.Logger(ctx).WithField("DeleteLogicalBridgeRequest", in).Info("DeleteLogicalBridge")
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	bridge, ok := s.logicalBridges.get(in.Name)
+	if !ok {
+		if in.AllowMissing {
+			return &emptypb.Empty{}, nil
+		}
+		return nil, notFound(logicalBridgeResource, in.Name)
+	}
+	if port, ok := s.logicalBridgeUser(in.Name); ok {
+		return nil, logicalBridgeInUse(in.Name, port)
+	}
+	if bridge.Spec.Vni != nil {
+		// The logical bridge is kept when its vxlan could not be removed so that the delete can be retried
+		if err := s.removeVxlan(ctx, bridge); err != nil {
+			return nil, internalError(reasonNetlink,
+				fmt.Errorf("unable to remove the vxlan of logical bridge %s: %v", in.Name, err))
+		}
+	}
+	s.logicalBridges.delete(in.Name)
+	s.persistState(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+// UpdateLogicalBridge updates the spec fields of a LogicalBridge given by the update mask, all of them when it is
+// empty. The spec of a LogicalBridge used by a BridgePort cannot be changed.
+func (s *server) UpdateLogicalBridge(ctx context.Context, in *pb.UpdateLogicalBridgeRequest) (*pb.LogicalBridge, error) {
+	tracing.Logger(ctx).WithField("UpdateLogicalBridgeRequest", in).Info("UpdateLogicalBridge")
+	if in.LogicalBridge == nil {
+		return nil, invalidArgument(fieldViolation("logical_bridge", "logical bridge is not provided"))
+	}
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	bridge, exists := s.logicalBridges.get(in.LogicalBridge.Name)
+	if !exists {
+		if !in.AllowMissing || !strings.HasPrefix(in.LogicalBridge.Name, logicalBridgePrefix) {
+			return nil, notFound(logicalBridgeResource, in.LogicalBridge.Name)
+		}
+		if err := validateResourceId("logical_bridge.name", strings.TrimPrefix(in.LogicalBridge.Name, logicalBridgePrefix)); err != nil {
+			return nil, err
+		}
+		bridge = &pb.LogicalBridge{
+			Name:   in.LogicalBridge.Name,
+			Status: &pb.LogicalBridgeStatus{OperStatus: pb.LBOperStatus_LB_OPER_STATUS_UP},
+		}
+	}
+	updated := proto.Clone(bridge).(*pb.LogicalBridge)
+	if err := updateSpec(updated, in.LogicalBridge, in.UpdateMask.GetPaths()); err != nil {
+		return nil, err
+	}
+	if exists && proto.Equal(updated.Spec, bridge.Spec) {
+		return bridge, nil
+	}
+	if port, inUse := s.logicalBridgeUser(bridge.Name); inUse {
+		return nil, logicalBridgeInUse(bridge.Name, port)
+	}
+	if err := s.validateLogicalBridge(updated); err != nil {
+		return nil, err
+	}
+	if err := s.replaceVxlan(ctx, bridge, updated, exists); err != nil {
+		return nil, internalError(reasonNetlink,
+			fmt.Errorf("unable to update the vxlan of logical bridge %s: %v", bridge.Name, err))
+	}
+	s.logicalBridges.set(updated.Name, updated)
+	s.persistState(ctx)
+	return proto.Clone(updated).(*pb.LogicalBridge), nil
+}
+
+// GetLogicalBridge returns a LogicalBridge
+func (s *server) GetLogicalBridge(ctx context.Context, in *pb.GetLogicalBridgeRequest) (*pb.LogicalBridge, error) {
+	tracing.Logger(ctx).WithField("GetLogicalBridgeRequest", in).Debug("GetLogicalBridge")
+	bridge, ok := s.logicalBridges.get(in.Name)
+	if !ok {
+		return nil, notFound(logicalBridgeResource, in.Name)
+	}
+	return bridge, nil
+}
+
+// ListLogicalBridges returns the LogicalBridges in name order, page by page
+func (s *server) ListLogicalBridges(ctx context.Context, in *pb.ListLogicalBridgesRequest) (*pb.ListLogicalBridgesResponse, error) {
+	tracing.Logger(ctx).WithField("ListLogicalBridgesRequest", in).Debug("ListLogicalBridges")
+	if err := validatePageSize(in.PageSize); err != nil {
+		return nil, err
+	}
+	bridges, next := s.logicalBridges.list(in.PageSize, in.PageToken)
+	return &pb.ListLogicalBridgesResponse{LogicalBridges: bridges, NextPageToken: next}, nil
+}
+
+// replaceVxlan replaces the vxlan of bridge, if it exists, with the one of updated. The former vxlan is restored when
+// the new one cannot be added.
+func (s *server) replaceVxlan(ctx context.Context, bridge, updated *pb.LogicalBridge, exists bool) error {
+	hadVxlan := exists && bridge.Spec.Vni != nil
+	if hadVxlan {
+		if err := s.removeVxlan(ctx, bridge); err != nil {
+			return err
+		}
+	}
+	if updated.Spec.Vni == nil {
+		return nil
+	}
+	err := s.addVxlan(ctx, updated)
+	if err != nil && hadVxlan {
+		if restoreErr := s.addVxlan(ctx, bridge); restoreErr != nil {
+			tracing.Logger(ctx).WithField("logicalBridge", bridge.Name).Errorf("unable to restore vxlan: %v", restoreErr)
+		}
+	}
+	return err
+}
+
+// validateLogicalBridge checks the spec of bridge, and that no other LogicalBridge has the same VLAN or VNI
+func (s *server) validateLogicalBridge(bridge *pb.LogicalBridge) error {
+	spec := bridge.Spec
+	if spec == nil {
+		return invalidArgument(fieldViolation("logical_bridge.spec", "logical bridge spec is not provided"))
+	}
+	if err := s.vlanAlloc.check(int(spec.VlanId)); err != nil {
+		return invalidArgument(fieldViolation("logical_bridge.spec.vlan_id", err.Error()))
+	}
+	if spec.Vni != nil && (*spec.Vni < 1 || *spec.Vni > maxVni) {
+		return invalidArgument(fieldViolation("logical_bridge.spec.vni",
+			fmt.Sprintf("invalid vni %d, vni must be within 1-%d range", *spec.Vni, maxVni)))
+	}
+	if spec.Vni != nil {
+		if _, err := getVtepAddr(spec.VtepIpPrefix); err != nil {
+			return invalidArgument(fieldViolation("logical_bridge.spec.vtep_ip_prefix",
+				fmt.Sprintf("invalid vtep ip prefix of vni %d: %v", *spec.Vni, err)))
+		}
+	}
+	for name, other := range s.logicalBridges.all() {
+		if name == bridge.Name {
+			continue
+		}
+		if other.Spec.VlanId == spec.VlanId {
+			return alreadyExists(logicalBridgeResource, name,
+				fmt.Sprintf("vlan %d is already used by logical bridge %s", spec.VlanId, name))
+		}
+		if spec.Vni != nil && other.Spec.Vni != nil && *other.Spec.Vni == *spec.Vni {
+			return alreadyExists(logicalBridgeResource, name,
+				fmt.Sprintf("vni %d is already used by logical bridge %s", *spec.Vni, name))
+		}
+	}
+	return nil
+}
+
+// logicalBridgeUser returns the name of a BridgePort using the LogicalBridge name, if any
+func (s *server) logicalBridgeUser(name string) (string, bool) {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	for portName, port := range s.Ports {
+		if slices.Contains(port.Spec.LogicalBridges, name) {
+			return portName, true
+		}
+	}
+	return "", false
+}
+
+// logicalBridgeInUse returns the FailedPrecondition status of a change to the LogicalBridge name used by port
+func logicalBridgeInUse(name, port string) error {
+	return failedPrecondition("IN_USE", name, fmt.Sprintf("logical bridge %s is used by bridge port %s", name, port))
+}
+
+// validateResourceId checks the id given by field of a resource to create, which is the last segment of its name
+func validateResourceId(field, id string) error {
+	if id == "" || strings.Contains(id, "/") {
+		return invalidArgument(fieldViolation(field, fmt.Sprintf("invalid resource id %q", id)))
+	}
+	return nil
+}
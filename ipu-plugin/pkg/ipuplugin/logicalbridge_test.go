@@ -0,0 +1,341 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	pc "github.com/opiproject/opi-api/network/opinetcommon/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("logical bridges", Serial, func() {
+	var (
+		ipuServer  *server
+		links      *fakeLinkStore
+		p4rtClient *mockP4rtClient
+	)
+
+	BeforeEach(func() {
+		links = newFakeLinkStore("enp0s1f0d3", "br-test")
+		linkByNameFn = links.linkByName
+		linkAddFn = links.linkAdd
+		linkDelFn = links.linkDel
+		linkSetMasterFn = fakeLinkSetMaster
+		linkSetNoMasterFn = fakeLinkSetNoMaster
+		linkSetUpFn = fakeLinkSetUp
+		linkSetDownFn = fakeLinkSetDown
+		executeScriptFn = func(string) (string, error) { return "", nil }
+		DeferCleanup(func() {
+			linkDelFn = netlink.LinkDel
+			executeScriptFn = utils.ExecuteScript
+		})
+
+		p4rtClient = &mockP4rtClient{}
+		ipuServer = &server{
+			uplinkInterface: "enp0s1f0d3",
+			bridgeCtlr:      NewLinuxBridgeController("br-test"),
+			p4RtClient:      p4rtClient,
+			log:             log.WithField("pkg", "logicalbridge_test.go"),
+		}
+	})
+
+	createBridge := func(id string, vlan uint32) (*pb.LogicalBridge, error) {
+		return ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+			LogicalBridgeId: id,
+			LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: vlan}},
+		})
+	}
+	createPort := func(name string, bridges ...string) error {
+		_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+			Name: name,
+			Spec: &pb.BridgePortSpec{
+				MacAddress:     []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14},
+				LogicalBridges: bridges,
+			},
+		}})
+		return err
+	}
+
+	It("should create, get, list and delete logical bridges", func() {
+		bridge, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bridge.Name).To(Equal("logicalBridges/blue"))
+		Expect(bridge.Status.OperStatus).To(Equal(pb.LBOperStatus_LB_OPER_STATUS_UP))
+
+		// Creating it again with the same spec is not an error
+		again, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proto.Equal(again, bridge)).To(BeTrue())
+		_, err = createBridge("blue", 101)
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+		_, err = createBridge("green", 101)
+		Expect(err).NotTo(HaveOccurred())
+		got, err := ipuServer.GetLogicalBridge(context.TODO(), &pb.GetLogicalBridgeRequest{Name: "logicalBridges/green"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Spec.VlanId).To(BeEquivalentTo(101))
+
+		resp, err := ipuServer.ListLogicalBridges(context.TODO(), &pb.ListLogicalBridgesRequest{PageSize: 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.LogicalBridges).To(HaveLen(1))
+		Expect(resp.LogicalBridges[0].Name).To(Equal("logicalBridges/blue"))
+		resp, err = ipuServer.ListLogicalBridges(context.TODO(), &pb.ListLogicalBridgesRequest{PageToken: resp.NextPageToken})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.LogicalBridges).To(HaveLen(1))
+		Expect(resp.LogicalBridges[0].Name).To(Equal("logicalBridges/green"))
+		Expect(resp.NextPageToken).To(BeEmpty())
+
+		_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ipuServer.GetLogicalBridge(context.TODO(), &pb.GetLogicalBridgeRequest{Name: "logicalBridges/blue"})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue"})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue", AllowMissing: true})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject invalid logical bridges", func() {
+		_, err := createBridge("", 100)
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		_, err = createBridge("blue", 1)
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		vni := uint32(1 << 24)
+		_, err = ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+			LogicalBridgeId: "blue",
+			LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: 100, Vni: &vni}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = createBridge("green", 100)
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+	})
+
+	It("should update the fields given by the update mask", func() {
+		_, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		vni := uint32(1000)
+		update := &pb.LogicalBridge{Name: "logicalBridges/blue", Spec: &pb.LogicalBridgeSpec{VlanId: 200, Vni: &vni}}
+		bridge, err := ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+			LogicalBridge: update,
+			UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"spec.vlan_id"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bridge.Spec.VlanId).To(BeEquivalentTo(200))
+		Expect(bridge.Spec.Vni).To(BeNil())
+
+		_, err = ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+			LogicalBridge: update,
+			UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+			LogicalBridge: &pb.LogicalBridge{Name: "logicalBridges/green", Spec: &pb.LogicalBridgeSpec{VlanId: 101}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		bridge, err = ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+			LogicalBridge: &pb.LogicalBridge{Name: "logicalBridges/green", Spec: &pb.LogicalBridgeSpec{VlanId: 101}},
+			AllowMissing:  true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bridge.Status.OperStatus).To(Equal(pb.LBOperStatus_LB_OPER_STATUS_UP))
+	})
+
+	It("should program the vlan of the logical bridges referred to by a BridgePort", func() {
+		_, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(createPort("port0", "logicalBridges/blue", "101")).To(Succeed())
+		Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0", "d3.0.100", "d3.0.101"))
+
+		// The logical bridge cannot be changed or removed while the port uses it
+		_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue"})
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		_, err = ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+			LogicalBridge: &pb.LogicalBridge{Name: "logicalBridges/blue", Spec: &pb.LogicalBridgeSpec{VlanId: 200}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+
+		_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+		_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should remove a BridgePort from the vlans it was created on", func() {
+		_, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(createPort("port0", "logicalBridges/blue")).To(Succeed())
+
+		// e.g.; the logical bridge was not restored along with the port
+		ipuServer.logicalBridges.delete("logicalBridges/blue")
+		_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0"))
+		Expect(p4rtClient.deleted).To(HaveLen(1))
+	})
+
+	It("should reject a BridgePort referring to an unknown logical bridge", func() {
+		err := createPort("port0", "logicalBridges/blue")
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+		Expect(p4rtClient.added).To(BeEmpty())
+	})
+
+	It("should manage vrfs", func() {
+		vni := uint32(1000)
+		vrf, err := ipuServer.CreateVrf(context.TODO(), &pb.CreateVrfRequest{
+			VrfId: "red",
+			Vrf:   &pb.Vrf{Spec: &pb.VrfSpec{Vni: &vni}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vrf.Name).To(Equal("vrfs/red"))
+		_, err = ipuServer.CreateVrf(context.TODO(), &pb.CreateVrfRequest{
+			VrfId: "black",
+			Vrf:   &pb.Vrf{Spec: &pb.VrfSpec{Vni: &vni}},
+		})
+		Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+		resp, err := ipuServer.ListVrfs(context.TODO(), &pb.ListVrfsRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Vrfs).To(HaveLen(1))
+		_, err = ipuServer.DeleteVrf(context.TODO(), &pb.DeleteVrfRequest{Name: "vrfs/red"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ipuServer.GetVrf(context.TODO(), &pb.GetVrfRequest{Name: "vrfs/red"})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+	})
+
+	It("should save and restore the logical bridges with the ports", func() {
+		stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+		_, err := createBridge("blue", 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(createPort("port0", "logicalBridges/blue")).To(Succeed())
+		Expect(ipuServer.saveState(stateFile)).To(Succeed())
+
+		restored := &server{log: ipuServer.log}
+		Expect(restored.restoreState(stateFile)).To(Succeed())
+		Expect(restored.Ports).To(HaveKey("port0"))
+		bridge, ok := restored.logicalBridges.get("logicalBridges/blue")
+		Expect(ok).To(BeTrue())
+		Expect(bridge.Spec.VlanId).To(BeEquivalentTo(100))
+		vlans, err := restored.getVlanIDs(restored.Ports["port0"].Spec.LogicalBridges)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vlans).To(Equal([]int{100}))
+	})
+
+	Context("with a vni", func() {
+		var vtepAddrs map[string]bool
+
+		BeforeEach(func() {
+			vtepAddrs = map[string]bool{}
+			addrReplaceFn = func(_ netlink.Link, addr *netlink.Addr) error {
+				vtepAddrs[addr.IPNet.String()] = true
+				return nil
+			}
+			addrDelFn = func(_ netlink.Link, addr *netlink.Addr) error {
+				delete(vtepAddrs, addr.IPNet.String())
+				return nil
+			}
+			DeferCleanup(func() {
+				addrReplaceFn = netlink.AddrReplace
+				addrDelFn = netlink.AddrDel
+			})
+		})
+
+		vtep := &pc.IPPrefix{Addr: &pc.IPAddress{Af: pc.IpAf_IP_AF_INET, V4OrV6: &pc.IPAddress_V4Addr{V4Addr: 0x0a000001}}}
+		createVxlanBridge := func(id string, vlan, vni uint32) (*pb.LogicalBridge, error) {
+			return ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+				LogicalBridgeId: id,
+				LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: vlan, Vni: &vni, VtepIpPrefix: vtep}},
+			})
+		}
+
+		It("should add a vxlan device to the bridge with the vtep address on the uplink", func() {
+			_, err := createVxlanBridge("blue", 100, 1000)
+			Expect(err).NotTo(HaveOccurred())
+			link, err := links.linkByName("vxlan1000")
+			Expect(err).NotTo(HaveOccurred())
+			vxlan, ok := link.(*netlink.Vxlan)
+			Expect(ok).To(BeTrue())
+			Expect(vxlan.VxlanId).To(Equal(1000))
+			Expect(vxlan.SrcAddr.String()).To(Equal("10.0.0.1"))
+			Expect(vxlan.Port).To(Equal(4789))
+			Expect(vtepAddrs).To(HaveKey("10.0.0.1/32"))
+			Expect(p4rtClient.vxlanAdded).To(Equal([]types.VxlanRuleSpec{
+				{Vni: 1000, Vlan: 100, VtepIp: net.IPv4(10, 0, 0, 1).To4()}}))
+
+			// The vtep address is shared by the logical bridges
+			_, err = createVxlanBridge("green", 101, 1001)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/blue"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.exists("vxlan1000")).To(BeFalse())
+			Expect(vtepAddrs).To(HaveKey("10.0.0.1/32"))
+			Expect(p4rtClient.vxlanDeleted).To(HaveLen(1))
+
+			_, err = ipuServer.DeleteLogicalBridge(context.TODO(), &pb.DeleteLogicalBridgeRequest{Name: "logicalBridges/green"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+			Expect(vtepAddrs).To(BeEmpty())
+		})
+
+		It("should replace the vxlan device when the vni is updated", func() {
+			_, err := createVxlanBridge("blue", 100, 1000)
+			Expect(err).NotTo(HaveOccurred())
+			vni := uint32(2000)
+			_, err = ipuServer.UpdateLogicalBridge(context.TODO(), &pb.UpdateLogicalBridgeRequest{
+				LogicalBridge: &pb.LogicalBridge{Name: "logicalBridges/blue", Spec: &pb.LogicalBridgeSpec{Vni: &vni}},
+				UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"spec.vni"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "vxlan2000"))
+			Expect(vtepAddrs).To(HaveKey("10.0.0.1/32"))
+		})
+
+		It("should require a valid vtep address", func() {
+			vni := uint32(1000)
+			_, err := ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+				LogicalBridgeId: "blue",
+				LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: 100, Vni: &vni}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+		})
+
+		It("should remove the vxlan devices on teardown", func() {
+			_, err := createVxlanBridge("blue", 100, 1000)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipuServer.teardown(context.TODO())).To(Succeed())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+			Expect(vtepAddrs).To(BeEmpty())
+		})
+	})
+})
@@ -15,19 +15,80 @@
 package ipuplugin
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 )
 
+func init() {
+	// Never reach out to the IMC from the unit tests, the VSI is then the second octet of the mac address
+	imcVsiForMacFn = func(string, string) (string, error) { return "", fmt.Errorf("no IMC in unit tests") }
+}
+
 // nolint
 type mockP4rtClient struct {
+	mu           sync.Mutex
+	added        []types.FxpRuleSpec
+	deleted      []types.FxpRuleSpec
+	vxlanAdded   []types.VxlanRuleSpec
+	vxlanDeleted []types.VxlanRuleSpec
+}
+
+// nolint
+func (p *mockP4rtClient) AddRules(ctx context.Context, spec types.FxpRuleSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.added = append(p.added, spec)
+}
+
+// nolint
+func (p *mockP4rtClient) DeleteRules(ctx context.Context, spec types.FxpRuleSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deleted = append(p.deleted, spec)
+}
+
+// nolint
+func (p *mockP4rtClient) AddVxlanRules(ctx context.Context, spec types.VxlanRuleSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.vxlanAdded = append(p.vxlanAdded, spec)
+}
+
+// nolint
+func (p *mockP4rtClient) DeleteVxlanRules(ctx context.Context, spec types.VxlanRuleSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.vxlanDeleted = append(p.vxlanDeleted, spec)
 }
 
+// mockQosP4rtClient is a mockP4rtClient of a P4 package with meter tables
 // nolint
-func (p *mockP4rtClient) AddRules(macAddr []byte, vlan int) {
+type mockQosP4rtClient struct {
+	mockP4rtClient
+	qosAdded   []types.QosRuleSpec
+	qosDeleted []types.QosRuleSpec
+	// qosAddErr and qosDelErr are returned by AddQosRules and DeleteQosRules
+	qosAddErr error
+	qosDelErr error
 }
 
 // nolint
-func (p *mockP4rtClient) DeleteRules(macAddr []byte, vlan int) {
+func (p *mockQosP4rtClient) AddQosRules(ctx context.Context, spec types.QosRuleSpec) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.qosAdded = append(p.qosAdded, spec)
+	return p.qosAddErr
+}
+
+// nolint
+func (p *mockQosP4rtClient) DeleteQosRules(ctx context.Context, spec types.QosRuleSpec) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.qosDeleted = append(p.qosDeleted, spec)
+	return p.qosDelErr
 }
 
 type mockBrCtlr struct {
@@ -57,7 +118,7 @@ func (brCtlr *mockBrCtlr) EnsureBridgeExists() error {
 	return nil
 }
 
-func (brCtlr *mockBrCtlr) AddPort(portName string) error {
+func (brCtlr *mockBrCtlr) AddPort(ctx context.Context, portName string) error {
 	if brCtlr.fnCalled == "AddPort" {
 		return brCtlr.retValues[0].(error)
 	}
@@ -65,9 +126,29 @@ func (brCtlr *mockBrCtlr) AddPort(portName string) error {
 	return nil
 }
 
-func (brCtlr *mockBrCtlr) DeletePort(portName string) error {
+func (brCtlr *mockBrCtlr) DeletePort(ctx context.Context, portName string) error {
 	if brCtlr.fnCalled == "DeletePort" {
 		return brCtlr.retValues[0].(error)
 	}
 	return fmt.Errorf("invalid mock function called")
 }
+
+func (brCtlr *mockBrCtlr) ListPorts(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (brCtlr *mockBrCtlr) HasPort(ctx context.Context, portName string) (bool, error) {
+	return false, nil
+}
+
+func (brCtlr *mockBrCtlr) SetPortVlan(ctx context.Context, portName string, vid int, tagged bool) error {
+	return nil
+}
+
+func (brCtlr *mockBrCtlr) RemovePortVlan(ctx context.Context, portName string, vid int) error {
+	return nil
+}
+
+func (brCtlr *mockBrCtlr) PortStatus(ctx context.Context, portName string) (*types.PortStatus, error) {
+	return &types.PortStatus{Name: portName}, nil
+}
@@ -17,62 +17,73 @@ package ipuplugin
 import (
 	"context"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/p4rtclient"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	pb "github.com/openshift/dpu-operator/dpu-api/gen"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type NetworkFunctionServiceServer struct {
 	pb.UnimplementedNetworkFunctionServiceServer
-	p4rtbin string
+	p4rtbin   string
+	vfWatcher *VfWatcher
 }
 
-func NewNetworkFunctionService(p4rtbin string) *NetworkFunctionServiceServer {
+func NewNetworkFunctionService(p4rtbin string, vfWatcher *VfWatcher) *NetworkFunctionServiceServer {
 	return &NetworkFunctionServiceServer{
-		p4rtbin: p4rtbin,
+		p4rtbin:   p4rtbin,
+		vfWatcher: vfWatcher,
 	}
 }
 
 func (s *NetworkFunctionServiceServer) CreateNetworkFunction(ctx context.Context, in *pb.NFRequest) (*pb.Empty, error) {
+	// Do not let the VF watcher update the FXP while the NF rules are being programmed
+	defer s.vfWatcher.pause()()
 
-	vfMacList, err := utils.GetVfMacList()
-
+	vfMacList, err := getVfMacList()
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Unable to reach the IMC %v", err)
-	}
-
-	if len(vfMacList) == 0 {
-		return nil, status.Error(codes.Internal, "No NFs initialized on the host")
+		return nil, err
 	}
 
 	// Remove point-to-point between host VFs from the FXP
-	p4rtclient.DeletePointToPointVFRules(s.p4rtbin, vfMacList)
+	p4rtclient.DeletePointToPointVFRules(ctx, s.p4rtbin, vfMacList)
 
 	// Generate the P4 rules and program the FXP with NF comms
-	p4rtclient.CreateNetworkFunctionRules(s.p4rtbin, vfMacList, in.Input, in.Output)
+	p4rtclient.CreateNetworkFunctionRules(ctx, s.p4rtbin, vfMacList, in.Input, in.Output)
+	s.vfWatcher.setNetworkFunction(vfMacList, in.Input, in.Output)
+	metrics.NetworkFunctions.Set(1)
 
 	return &pb.Empty{}, nil
 }
 
 func (s *NetworkFunctionServiceServer) DeleteNetworkFunction(ctx context.Context, in *pb.NFRequest) (*pb.Empty, error) {
+	defer s.vfWatcher.pause()()
 
-	vfMacList, err := utils.GetVfMacList()
-
+	vfMacList, err := getVfMacList()
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Unable to reach the IMC %v", err)
-	}
-
-	if len(vfMacList) == 0 {
-		return nil, status.Error(codes.Internal, "No NFs initialized on the host")
+		return nil, err
 	}
 
 	// Remove the NF comms from the FXP
-	p4rtclient.DeleteNetworkFunctionRules(s.p4rtbin, vfMacList, in.Input, in.Output)
+	p4rtclient.DeleteNetworkFunctionRules(ctx, s.p4rtbin, vfMacList, in.Input, in.Output)
 
 	// Generate the P4 rules and program the FXP with point-to-point rules between host VFs
-	p4rtclient.CreatePointToPointVFRules(s.p4rtbin, vfMacList)
+	p4rtclient.CreatePointToPointVFRules(ctx, s.p4rtbin, vfMacList)
+	s.vfWatcher.setNetworkFunction(vfMacList, "", "")
+	metrics.NetworkFunctions.Set(0)
 
 	return &pb.Empty{}, nil
 }
+
+// getVfMacList returns the MAC addresses of the host VFs known to the IMC. It fails with Unavailable when the IMC
+// cannot be reached, and with FailedPrecondition when there is no VF yet.
+func getVfMacList() ([]string, error) {
+	vfMacList, err := utils.GetVfMacList()
+	if err != nil {
+		return nil, imcUnavailable(err)
+	}
+	if len(vfMacList) == 0 {
+		return nil, failedPrecondition("VF", "host", "No NFs initialized on the host")
+	}
+	return vfMacList, nil
+}
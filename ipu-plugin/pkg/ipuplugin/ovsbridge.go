@@ -1,14 +1,36 @@
 package ipuplugin
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	log "github.com/sirupsen/logrus"
 )
 
+// Abstract ovs-vsctl invocation for unit tests
+var ovsVsctlFn = defaultOvsVsctl
+
+// defaultOvsVsctl runs ovs-vsctl and returns its output
+func defaultOvsVsctl(ctx context.Context, vsctl string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, vsctl, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	tracing.Logger(ctx).WithField("ovs command", cmd.String()).Debug("running ovs-vsctl")
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
 type ovsBridge struct {
 	brName    string
 	ovsCliDir string
@@ -21,6 +43,10 @@ func NewOvsBridgeController(bridge, ovsCliDir string) types.BridgeController {
 	}
 }
 
+func (b *ovsBridge) vsctl(ctx context.Context, args ...string) (string, error) {
+	return ovsVsctlFn(ctx, b.ovsCliDir+"/ovs-vsctl", args...)
+}
+
 func (b *ovsBridge) EnsureBridgeExists() error {
 	// ovs-vsctl --may-exist add-br br-tenant
 	createBrParams := []string{"--may-exist", "add-br", b.brName}
@@ -31,22 +57,110 @@ func (b *ovsBridge) EnsureBridgeExists() error {
 	return nil
 }
 
-func (b *ovsBridge) AddPort(portName string) error {
-	cmd := exec.Command(b.ovsCliDir+"/ovs-vsctl", "add-port", b.brName, portName)
-	log.WithField("ovs command", cmd.String()).Debug("adding ovs bridge port")
-	if err := cmd.Run(); err != nil {
+func (b *ovsBridge) AddPort(ctx context.Context, portName string) error {
+	logger := tracing.Logger(ctx)
+	if _, err := b.vsctl(ctx, "add-port", b.brName, portName); err != nil {
 		return fmt.Errorf("unable to add port to the bridge: %w", err)
 	}
-	log.WithField("portName", portName).Infof("port added to ovs bridge %s", b.brName)
+	logger.WithField("portName", portName).Infof("port added to ovs bridge %s", b.brName)
 	return nil
 }
 
-func (b *ovsBridge) DeletePort(portName string) error {
-	cmd := exec.Command(b.ovsCliDir+"/ovs-vsctl", "del-port", b.brName, portName)
-	log.WithField("ovs command", cmd.String()).Debug("deleting ovs bridge port")
-	if err := cmd.Run(); err != nil {
+func (b *ovsBridge) DeletePort(ctx context.Context, portName string) error {
+	logger := tracing.Logger(ctx)
+	if _, err := b.vsctl(ctx, "del-port", b.brName, portName); err != nil {
 		return fmt.Errorf("unable to delete port from the bridge: %w", err)
 	}
-	log.WithField("portName", portName).Infof("port deleted from ovs bridge %s", b.brName)
+	logger.WithField("portName", portName).Infof("port deleted from ovs bridge %s", b.brName)
+	return nil
+}
+
+func (b *ovsBridge) ListPorts(ctx context.Context) ([]string, error) {
+	out, err := b.vsctl(ctx, "list-ports", b.brName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the bridge ports: %w", err)
+	}
+	return strings.Fields(out), nil
+}
+
+func (b *ovsBridge) HasPort(ctx context.Context, portName string) (bool, error) {
+	ports, err := b.ListPorts(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(ports, portName), nil
+}
+
+// SetPortVlan sets the tag of an access port, or adds the VLAN to the trunks of a trunk port
+func (b *ovsBridge) SetPortVlan(ctx context.Context, portName string, vid int, tagged bool) error {
+	if vid < 1 || vid > 4094 {
+		return fmt.Errorf("invalid vlan %d, vlan must be within 1-4094 range", vid)
+	}
+	args := []string{"set", "Port", portName, "tag=" + strconv.Itoa(vid)}
+	if tagged {
+		args = []string{"add", "Port", portName, "trunks", strconv.Itoa(vid)}
+	}
+	if _, err := b.vsctl(ctx, args...); err != nil {
+		return fmt.Errorf("error adding vlan %d to port %s: %w", vid, portName, err)
+	}
+	tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid, "tagged": tagged}).
+		Infof("vlan set on ovs bridge %s port", b.brName)
 	return nil
 }
+
+// RemovePortVlan clears the tag of an access port, or removes the VLAN from the trunks of a trunk port
+func (b *ovsBridge) RemovePortVlan(ctx context.Context, portName string, vid int) error {
+	v := strconv.Itoa(vid)
+	if _, err := b.vsctl(ctx, "remove", "Port", portName, "tag", v, "--", "remove", "Port", portName, "trunks", v); err != nil {
+		return fmt.Errorf("error removing vlan %d from port %s: %w", vid, portName, err)
+	}
+	tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid}).
+		Infof("vlan removed from ovs bridge %s port", b.brName)
+	return nil
+}
+
+func (b *ovsBridge) PortStatus(ctx context.Context, portName string) (*types.PortStatus, error) {
+	out, err := b.vsctl(ctx, "get", "Port", portName, "tag", "trunks", "--", "get", "Interface", portName,
+		"admin_state", "link_state")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get port %s: %w", portName, err)
+	}
+	// One value per line, an empty column is printed as []
+	values := strings.Split(strings.TrimSpace(out), "\n")
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected ovs-vsctl output for port %s: %q", portName, out)
+	}
+	status := &types.PortStatus{
+		Name:    portName,
+		AdminUp: strings.TrimSpace(values[2]) == "up",
+		OperUp:  strings.TrimSpace(values[3]) == "up",
+	}
+	tags, err := parseOvsIntSet(values[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 1 {
+		status.AccessVlan = tags[0]
+	}
+	if status.TaggedVlans, err = parseOvsIntSet(values[1]); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// parseOvsIntSet parses an integer column printed by ovs-vsctl, e.g.; 100, [] or [100, 200]
+func parseOvsIntSet(value string) ([]int, error) {
+	value = strings.Trim(strings.TrimSpace(value), "[]")
+	var ints []int
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ovs-vsctl value %q: %w", value, err)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
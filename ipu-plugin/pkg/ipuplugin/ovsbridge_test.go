@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ovsBridge", Serial, func() {
+	var (
+		brCtlr   types.BridgeController
+		commands []string
+		outputs  map[string]string
+	)
+
+	BeforeEach(func() {
+		brCtlr = NewOvsBridgeController("br-test", "/opt/ovs")
+		commands = nil
+		outputs = map[string]string{}
+		ovsVsctlFn = func(_ context.Context, vsctl string, args ...string) (string, error) {
+			Expect(vsctl).To(Equal("/opt/ovs/ovs-vsctl"))
+			cmd := strings.Join(args, " ")
+			commands = append(commands, cmd)
+			out, ok := outputs[cmd]
+			if !ok {
+				return "", fmt.Errorf("exit status 1: no port named %s", args[len(args)-1])
+			}
+			return out, nil
+		}
+		DeferCleanup(func() { ovsVsctlFn = defaultOvsVsctl })
+	})
+
+	It("should list the ports of the bridge", func() {
+		outputs["list-ports br-test"] = "d3.0\nd3.0.100\n"
+		Expect(brCtlr.ListPorts(context.TODO())).To(Equal([]string{"d3.0", "d3.0.100"}))
+		Expect(brCtlr.HasPort(context.TODO(), "d3.0.100")).To(BeTrue())
+		Expect(brCtlr.HasPort(context.TODO(), "d3.0.101")).To(BeFalse())
+	})
+
+	It("should set the tag of access ports and the trunks of trunk ports", func() {
+		outputs["set Port d3.0.100 tag=100"] = ""
+		outputs["add Port d3.0 trunks 200"] = ""
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.100", 100, false)).To(Succeed())
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0", 200, true)).To(Succeed())
+		Expect(commands).To(Equal([]string{"set Port d3.0.100 tag=100", "add Port d3.0 trunks 200"}))
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.101", 100, false)).NotTo(Succeed())
+	})
+
+	It("should remove the vlan from the tag and the trunks of the port", func() {
+		outputs["remove Port d3.0 tag 200 -- remove Port d3.0 trunks 200"] = ""
+		Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0", 200)).To(Succeed())
+		Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.101", 100)).NotTo(Succeed())
+	})
+
+	It("should parse the port state and vlans", func() {
+		outputs["get Port d3.0 tag trunks -- get Interface d3.0 admin_state link_state"] = "[]\n[200, 300]\nup\ndown\n"
+		status, err := brCtlr.PortStatus(context.TODO(), "d3.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0", AdminUp: true, TaggedVlans: []int{200, 300}}))
+
+		outputs["get Port d3.0.100 tag trunks -- get Interface d3.0.100 admin_state link_state"] = "100\n[]\n[]\n[]\n"
+		status, err = brCtlr.PortStatus(context.TODO(), "d3.0.100")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0.100", AccessVlan: 100}))
+	})
+})
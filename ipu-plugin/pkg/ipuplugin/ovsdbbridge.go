@@ -0,0 +1,336 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/ovsdb"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ovsdbName = "Open_vSwitch"
+	// bridgePortExternalID is the external-ids key of the ports added for a BridgePort, set to its name
+	bridgePortExternalID = "ipu-bridge-port"
+	ovsdbTimeout         = 10 * time.Second
+)
+
+type ovsdbBridge struct {
+	brName string
+	dbSock string
+
+	mu     sync.Mutex // guards client
+	client *ovsdb.Client
+}
+
+// NewOvsdbBridgeController returns a BridgeController managing the OVS bridge through the OVSDB server listening
+// on the unix socket dbSock, e.g.; /opt/p4/p4-cp-nws/var/run/openvswitch/db.sock. Adding or deleting a port is
+// idempotent.
+func NewOvsdbBridgeController(bridge, dbSock string) types.BridgeController {
+	return &ovsdbBridge{
+		brName: bridge,
+		dbSock: dbSock,
+	}
+}
+
+// getClient returns the connection to the OVSDB server, connecting again when it was closed
+func (b *ovsdbBridge) getClient(ctx context.Context) (*ovsdb.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil && !b.client.Closed() {
+		return b.client, nil
+	}
+	client, err := ovsdb.Dial(ctx, "unix", b.dbSock)
+	if err != nil {
+		return nil, err
+	}
+	b.client = client
+	return client, nil
+}
+
+// transact executes ops on the Open_vSwitch database, connecting again once if the connection was closed
+func (b *ovsdbBridge) transact(ctx context.Context, ops ...ovsdb.Operation) ([]ovsdb.OperationResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, ovsdbTimeout)
+	defer cancel()
+	client, err := b.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Transact(ctx, ovsdbName, ops...)
+	if errors.Is(err, ovsdb.ErrClosed) {
+		// The server restarted since the last transaction, the operations are safe to send again as the inserts
+		// are guarded by wait operations
+		if client, err = b.getClient(ctx); err != nil {
+			return nil, err
+		}
+		res, err = client.Transact(ctx, ovsdbName, ops...)
+	}
+	return res, err
+}
+
+// findRow returns the uuid of the row of table named name, or "" when there is none
+func (b *ovsdbBridge) findRow(ctx context.Context, table, name string) (string, error) {
+	res, err := b.transact(ctx, ovsdb.Select(table, []ovsdb.Condition{ovsdb.Equal("name", name)}, "_uuid"))
+	if err != nil {
+		return "", err
+	}
+	if len(res[0].Rows) == 0 {
+		return "", nil
+	}
+	return ovsdb.ParseUUID(res[0].Rows[0]["_uuid"])
+}
+
+// isConcurrentInsert returns whether err is the failure of the wait operation guarding an insert, which means
+// that the row was inserted by another client in the meantime
+func isConcurrentInsert(err error) bool {
+	var opErr *ovsdb.OperationError
+	return errors.As(err, &opErr) && opErr.Op == "wait" && opErr.Reason == "timed out"
+}
+
+func (b *ovsdbBridge) EnsureBridgeExists() error {
+	if b.brName == "" {
+		return fmt.Errorf("bridge name is empty")
+	}
+	ctx := context.Background()
+	uuid, err := b.findRow(ctx, "Bridge", b.brName)
+	if err != nil {
+		return fmt.Errorf("error looking up ovs bridge %s: %w", b.brName, err)
+	}
+	if uuid != "" {
+		log.Infof("bridge %s found", b.brName)
+		return nil
+	}
+
+	// Same as ovs-vsctl add-br, the bridge has an internal port of the same name
+	where := []ovsdb.Condition{ovsdb.Equal("name", b.brName)}
+	_, err = b.transact(ctx,
+		ovsdb.WaitNone("Bridge", where),
+		ovsdb.Insert("Interface", ovsdb.Row{"name": b.brName, "type": "internal"}, "iface"),
+		ovsdb.Insert("Port", ovsdb.Row{"name": b.brName, "interfaces": ovsdb.NamedUUID("iface")}, "port"),
+		ovsdb.Insert("Bridge", ovsdb.Row{"name": b.brName, "ports": ovsdb.NamedUUID("port")}, "bridge"),
+		ovsdb.Mutate("Open_vSwitch", nil, ovsdb.Mutation{"bridges", "insert", ovsdb.Set(ovsdb.NamedUUID("bridge"))}),
+	)
+	if err != nil && !isConcurrentInsert(err) {
+		return fmt.Errorf("error creating ovs bridge %s: %w", b.brName, err)
+	}
+	log.Infof("bridge %s created", b.brName)
+	return nil
+}
+
+func (b *ovsdbBridge) AddPort(ctx context.Context, portName string) error {
+	logger := tracing.Logger(ctx)
+	externalIDs := map[string]string{}
+	if bridgePort := types.BridgePortName(ctx); bridgePort != "" {
+		externalIDs[bridgePortExternalID] = bridgePort
+	}
+	uuid, err := b.findRow(ctx, "Port", portName)
+	if err != nil {
+		return fmt.Errorf("unable to add port to the bridge: %w", err)
+	}
+	if uuid == "" {
+		_, err = b.transact(ctx,
+			ovsdb.WaitNone("Port", []ovsdb.Condition{ovsdb.Equal("name", portName)}),
+			ovsdb.Insert("Interface", ovsdb.Row{"name": portName}, "iface"),
+			ovsdb.Insert("Port", ovsdb.Row{"name": portName, "interfaces": ovsdb.NamedUUID("iface"),
+				"external_ids": ovsdb.Map(externalIDs)}, "port"),
+			ovsdb.Mutate("Bridge", []ovsdb.Condition{ovsdb.Equal("name", b.brName)},
+				ovsdb.Mutation{"ports", "insert", ovsdb.Set(ovsdb.NamedUUID("port"))}),
+		)
+		if err == nil {
+			logger.WithField("portName", portName).Infof("port added to ovs bridge %s", b.brName)
+			return nil
+		}
+		if !isConcurrentInsert(err) {
+			return fmt.Errorf("unable to add port to the bridge: %w", err)
+		}
+		// Added by another client in the meantime
+		if uuid, err = b.findRow(ctx, "Port", portName); err != nil {
+			return fmt.Errorf("unable to add port to the bridge: %w", err)
+		}
+	}
+
+	// The port already exists, make sure it is linked to its BridgePort
+	if len(externalIDs) > 0 {
+		if _, err := b.transact(ctx, ovsdb.Mutate("Port", []ovsdb.Condition{ovsdb.Equal("_uuid", ovsdb.UUID(uuid))},
+			ovsdb.Mutation{"external_ids", "delete", ovsdb.Set(bridgePortExternalID)},
+			ovsdb.Mutation{"external_ids", "insert", ovsdb.Map(externalIDs)})); err != nil {
+			return fmt.Errorf("unable to update port %s: %w", portName, err)
+		}
+	}
+	logger.WithField("portName", portName).Infof("port already exists on ovs bridge %s", b.brName)
+	return nil
+}
+
+func (b *ovsdbBridge) DeletePort(ctx context.Context, portName string) error {
+	logger := tracing.Logger(ctx)
+	uuid, err := b.findRow(ctx, "Port", portName)
+	if err != nil {
+		return fmt.Errorf("unable to delete port from the bridge: %w", err)
+	}
+	if uuid == "" {
+		logger.WithField("portName", portName).Infof("port not found on ovs bridge %s, nothing to delete", b.brName)
+		return nil
+	}
+	// The Port and its Interface are garbage collected by the server once no bridge references them
+	if _, err := b.transact(ctx, ovsdb.Mutate("Bridge", []ovsdb.Condition{ovsdb.Equal("name", b.brName)},
+		ovsdb.Mutation{"ports", "delete", ovsdb.Set(ovsdb.UUID(uuid))})); err != nil {
+		return fmt.Errorf("unable to delete port from the bridge: %w", err)
+	}
+	logger.WithField("portName", portName).Infof("port deleted from ovs bridge %s", b.brName)
+	return nil
+}
+
+// bridgePorts returns the names of the ports of the bridge by uuid, the internal port of the bridge included
+func (b *ovsdbBridge) bridgePorts(ctx context.Context) (map[string]string, error) {
+	res, err := b.transact(ctx,
+		ovsdb.Select("Bridge", []ovsdb.Condition{ovsdb.Equal("name", b.brName)}, "ports"),
+		ovsdb.Select("Port", nil, "_uuid", "name"))
+	if err != nil {
+		return nil, err
+	}
+	if len(res[0].Rows) == 0 {
+		return nil, fmt.Errorf("bridge %s not found", b.brName)
+	}
+	uuids, err := ovsdb.ParseUUIDSet(res[0].Rows[0]["ports"])
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, row := range res[1].Rows {
+		uuid, err := ovsdb.ParseUUID(row["_uuid"])
+		if err != nil {
+			return nil, err
+		}
+		var name string
+		if err := json.Unmarshal(row["name"], &name); err != nil {
+			return nil, fmt.Errorf("invalid port name %s", row["name"])
+		}
+		names[uuid] = name
+	}
+	ports := make(map[string]string, len(uuids))
+	for _, uuid := range uuids {
+		ports[uuid] = names[uuid]
+	}
+	return ports, nil
+}
+
+// ListPorts returns the ports of the bridge, without its internal port as ovs-vsctl list-ports does
+func (b *ovsdbBridge) ListPorts(ctx context.Context) ([]string, error) {
+	ports, err := b.bridgePorts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the bridge ports: %w", err)
+	}
+	names := []string{}
+	for _, name := range ports {
+		if name != b.brName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *ovsdbBridge) HasPort(ctx context.Context, portName string) (bool, error) {
+	ports, err := b.ListPorts(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(ports, portName), nil
+}
+
+// SetPortVlan sets the tag of an access port, or adds the VLAN to the trunks of a trunk port
+func (b *ovsdbBridge) SetPortVlan(ctx context.Context, portName string, vid int, tagged bool) error {
+	if vid < 1 || vid > 4094 {
+		return fmt.Errorf("invalid vlan %d, vlan must be within 1-4094 range", vid)
+	}
+	where := []ovsdb.Condition{ovsdb.Equal("name", portName)}
+	op := ovsdb.Update("Port", where, ovsdb.Row{"tag": vid})
+	if tagged {
+		op = ovsdb.Mutate("Port", where, ovsdb.Mutation{"trunks", "insert", ovsdb.Set(vid)})
+	}
+	res, err := b.transact(ctx, op)
+	if err != nil {
+		return fmt.Errorf("error adding vlan %d to port %s: %w", vid, portName, err)
+	}
+	if res[0].Count == 0 {
+		return fmt.Errorf("error adding vlan %d to port %s: port not found", vid, portName)
+	}
+	tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid, "tagged": tagged}).
+		Infof("vlan set on ovs bridge %s port", b.brName)
+	return nil
+}
+
+// RemovePortVlan clears the tag of an access port, or removes the VLAN from the trunks of a trunk port
+func (b *ovsdbBridge) RemovePortVlan(ctx context.Context, portName string, vid int) error {
+	where := []ovsdb.Condition{ovsdb.Equal("name", portName)}
+	res, err := b.transact(ctx,
+		ovsdb.Mutate("Port", where, ovsdb.Mutation{"trunks", "delete", ovsdb.Set(vid)}),
+		ovsdb.Mutate("Port", where, ovsdb.Mutation{"tag", "delete", ovsdb.Set(vid)}))
+	if err != nil {
+		return fmt.Errorf("error removing vlan %d from port %s: %w", vid, portName, err)
+	}
+	if res[0].Count == 0 {
+		return fmt.Errorf("error removing vlan %d from port %s: port not found", vid, portName)
+	}
+	tracing.Logger(ctx).WithFields(log.Fields{"portName": portName, "vlan": vid}).
+		Infof("vlan removed from ovs bridge %s port", b.brName)
+	return nil
+}
+
+func (b *ovsdbBridge) PortStatus(ctx context.Context, portName string) (*types.PortStatus, error) {
+	where := []ovsdb.Condition{ovsdb.Equal("name", portName)}
+	res, err := b.transact(ctx,
+		ovsdb.Select("Port", where, "tag", "trunks"),
+		ovsdb.Select("Interface", where, "admin_state", "link_state"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get port %s: %w", portName, err)
+	}
+	if len(res[0].Rows) == 0 {
+		return nil, fmt.Errorf("port %s not found", portName)
+	}
+	status := &types.PortStatus{Name: portName}
+	port := res[0].Rows[0]
+	tags, err := ovsdb.ParseIntSet(port["tag"])
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 1 {
+		status.AccessVlan = tags[0]
+	}
+	if status.TaggedVlans, err = ovsdb.ParseIntSet(port["trunks"]); err != nil {
+		return nil, err
+	}
+	if len(status.TaggedVlans) == 0 {
+		status.TaggedVlans = nil
+	}
+	if len(res[1].Rows) > 0 {
+		intf := res[1].Rows[0]
+		adminState, _ := ovsdb.ParseStringSet(intf["admin_state"])
+		linkState, _ := ovsdb.ParseStringSet(intf["link_state"])
+		status.AdminUp = slices.Contains(adminState, "up")
+		status.OperUp = slices.Contains(linkState, "up")
+	}
+	return status, nil
+}
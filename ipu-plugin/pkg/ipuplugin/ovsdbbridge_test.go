@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/ovsdb/ovsdbtest"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ovsdb bridge", func() {
+	var (
+		srv    *ovsdbtest.Server
+		brCtlr types.BridgeController
+	)
+
+	portNames := func() []string {
+		names := []string{}
+		for _, row := range srv.Rows("Port") {
+			names = append(names, row["name"].(string))
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		var err error
+		srv, err = ovsdbtest.NewServer(filepath.Join(GinkgoT().TempDir(), "db.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { srv.Close() })
+		brCtlr = NewOvsdbBridgeController("br-test", srv.Path)
+		Expect(brCtlr.EnsureBridgeExists()).To(Succeed())
+	})
+
+	It("should create the bridge once", func() {
+		Expect(brCtlr.EnsureBridgeExists()).To(Succeed())
+		Expect(srv.Rows("Bridge")).To(HaveLen(1))
+		Expect(srv.Rows("Interface")[0]).To(HaveKeyWithValue("type", "internal"))
+		Expect(portNames()).To(ConsistOf("br-test"))
+	})
+
+	It("should add and delete ports idempotently", func() {
+		ctx := types.WithBridgePortName(context.TODO(), "port0")
+		Expect(brCtlr.AddPort(ctx, "d3.0.100")).To(Succeed())
+		Expect(brCtlr.AddPort(ctx, "d3.0.100")).To(Succeed())
+		Expect(portNames()).To(ConsistOf("br-test", "d3.0.100"))
+		Expect(srv.Rows("Port")[1]["external_ids"]).To(Equal([]interface{}{"map", []interface{}{
+			[]interface{}{bridgePortExternalID, "port0"}}}))
+
+		Expect(brCtlr.DeletePort(context.TODO(), "d3.0.100")).To(Succeed())
+		Expect(brCtlr.DeletePort(context.TODO(), "d3.0.100")).To(Succeed())
+		Expect(portNames()).To(ConsistOf("br-test"))
+		Expect(srv.Rows("Interface")).To(HaveLen(1))
+	})
+
+	It("should link an existing port to its BridgePort", func() {
+		Expect(brCtlr.AddPort(context.TODO(), "d3.0.100")).To(Succeed())
+		Expect(brCtlr.AddPort(types.WithBridgePortName(context.TODO(), "port0"), "d3.0.100")).To(Succeed())
+		Expect(srv.Rows("Port")[1]["external_ids"]).To(Equal([]interface{}{"map", []interface{}{
+			[]interface{}{bridgePortExternalID, "port0"}}}))
+	})
+
+	It("should add a port once when added concurrently", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(brCtlr.AddPort(context.TODO(), "d3.0.100")).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		Expect(portNames()).To(ConsistOf("br-test", "d3.0.100"))
+	})
+
+	It("should list the ports of the bridge and set their vlans", func() {
+		Expect(brCtlr.AddPort(context.TODO(), "d3.0")).To(Succeed())
+		Expect(brCtlr.AddPort(context.TODO(), "d3.0.100")).To(Succeed())
+		Expect(brCtlr.ListPorts(context.TODO())).To(Equal([]string{"d3.0", "d3.0.100"}))
+		Expect(brCtlr.HasPort(context.TODO(), "d3.0.100")).To(BeTrue())
+		Expect(brCtlr.HasPort(context.TODO(), "br-test")).To(BeFalse())
+
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.100", 100, false)).To(Succeed())
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0", 100, true)).To(Succeed())
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0", 200, true)).To(Succeed())
+		Expect(brCtlr.SetPortVlan(context.TODO(), "d3.0.101", 100, true)).NotTo(Succeed())
+
+		status, err := brCtlr.PortStatus(context.TODO(), "d3.0.100")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0.100", AccessVlan: 100}))
+		status, err = brCtlr.PortStatus(context.TODO(), "d3.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0", TaggedVlans: []int{100, 200}}))
+
+		Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.100", 100)).To(Succeed())
+		Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0", 100)).To(Succeed())
+		Expect(brCtlr.RemovePortVlan(context.TODO(), "d3.0.101", 100)).NotTo(Succeed())
+		status, err = brCtlr.PortStatus(context.TODO(), "d3.0.100")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0.100"}))
+		status, err = brCtlr.PortStatus(context.TODO(), "d3.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(&types.PortStatus{Name: "d3.0", TaggedVlans: []int{200}}))
+	})
+
+	It("should reconnect when the OVSDB server restarts", func() {
+		srv.Close()
+		var err error
+		srv, err = ovsdbtest.NewServer(srv.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(brCtlr.EnsureBridgeExists()).To(Succeed())
+		Expect(brCtlr.AddPort(context.TODO(), "d3.0.100")).To(Succeed())
+		Expect(portNames()).To(ConsistOf("br-test", "d3.0.100"))
+	})
+})
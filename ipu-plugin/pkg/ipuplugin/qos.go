@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// gRPC metadata of a CreateBridgePort request giving the QoS of the port; the rates are in Mbps and the priority
+	// is the 802.1p priority, 0-7
+	maxTxRateKey = "ipu-max-tx-rate"
+	minTxRateKey = "ipu-min-tx-rate"
+	priorityKey  = "ipu-priority"
+
+	maxPriority = 7
+	// qosBurstMs is the time the traffic of a rate limited port may burst above the rate
+	qosBurstMs = 10
+)
+
+// portQos is the QoS of a BridgePort, the rates are in Mbps and 0 when not limited
+type portQos struct {
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	MinTxRate int `json:"minTxRate,omitempty"`
+	Priority  int `json:"priority,omitempty"`
+}
+
+func (q portQos) isSet() bool {
+	return q != portQos{}
+}
+
+// getQos returns the QoS given by the "ipu-max-tx-rate", "ipu-min-tx-rate" and "ipu-priority" request metadata.
+// The invalid values are returned as field violations of the metadata keys.
+func getQos(ctx context.Context) (portQos, []*errdetails.BadRequest_FieldViolation) {
+	var qos portQos
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return qos, nil
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, field := range []struct {
+		key   string
+		value *int
+	}{
+		{maxTxRateKey, &qos.MaxTxRate},
+		{minTxRateKey, &qos.MinTxRate},
+		{priorityKey, &qos.Priority},
+	} {
+		values := md.Get(field.key)
+		if len(values) == 0 {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(values[0]))
+		if err != nil || v < 0 {
+			violations = append(violations, fieldViolation(field.key,
+				fmt.Sprintf("invalid %s %q, it must be a number of 0 or more", field.key, values[0])))
+			continue
+		}
+		*field.value = v
+	}
+	if qos.Priority > maxPriority {
+		violations = append(violations, fieldViolation(priorityKey,
+			fmt.Sprintf("invalid priority %d, priority must be within 0-%d range", qos.Priority, maxPriority)))
+	}
+	if qos.MaxTxRate != 0 && qos.MinTxRate > qos.MaxTxRate {
+		violations = append(violations, fieldViolation(minTxRateKey,
+			fmt.Sprintf("%s %d is above %s %d", minTxRateKey, qos.MinTxRate, maxTxRateKey, qos.MaxTxRate)))
+	}
+	if len(violations) > 0 {
+		return portQos{}, violations
+	}
+	return qos, nil
+}
+
+// checkQos returns an error when the QoS of a BridgePort can't be applied. Without the FXP meters, the QoS is
+// applied with tc on the inner vlan interfaces of the port, so they must not be shared with other ports.
+func (s *server) checkQos(spec *bridgePortSpec) error {
+	if !spec.qos.isSet() {
+		return nil
+	}
+	if _, ok := s.p4RtClient.(types.QosP4RTClient); ok {
+		return nil
+	}
+	var reason string
+	switch {
+	case s.vlanFiltering():
+		reason = "the ports of a vlan filtering bridge have no vlan interface"
+	case len(spec.ownVlans) != len(spec.vlans):
+		reason = "the vlan interfaces of the logical bridges are shared with other ports"
+	case spec.qos.MinTxRate != 0:
+		reason = minTxRateKey + " is only supported with FXP meters"
+	default:
+		return nil
+	}
+	return failedPrecondition("QOS", "bridge_port.spec.logical_bridges",
+		fmt.Sprintf("the P4 package has no FXP meters and %s", reason))
+}
+
+// addPortQos applies the QoS of a BridgePort, with FXP meter rules when the P4 package has them, otherwise with
+// tc on the inner vlan interfaces of the port: the frames the VF sends are policed and get the priority.
+func (s *server) addPortQos(ctx context.Context, vsi, stag int, vlans []int, qos portQos) error {
+	if !qos.isSet() {
+		return nil
+	}
+	logger := tracing.Logger(ctx).WithFields(log.Fields{"maxTxRate": qos.MaxTxRate, "minTxRate": qos.MinTxRate,
+		"priority": qos.Priority})
+	if p4Client, ok := s.p4RtClient.(types.QosP4RTClient); ok {
+		if err := p4Client.AddQosRules(ctx, getQosRuleSpec(vsi, qos)); err != nil {
+			// The rules which were added are not removed along with the vlans, unlike the tc rules
+			if delErr := p4Client.DeleteQosRules(ctx, getQosRuleSpec(vsi, qos)); delErr != nil {
+				logger.WithField("error", delErr).Warn("unable to remove the qos rules added to the bridge port")
+			}
+			return fmt.Errorf("unable to add the qos rules of vsi %d: %w", vsi, err)
+		}
+		logger.Info("qos applied to the bridge port")
+		return nil
+	}
+	for _, vlan := range vlans {
+		vlanIntfName := getInnerVlanIntfName(s.uplinkInterface, stag, vlan)
+		for _, cmd := range getTcQosCmds(vlanIntfName, qos) {
+			if _, err := executeScriptFn(cmd); err != nil {
+				return fmt.Errorf("unable to set the qos of %s: %w", vlanIntfName, err)
+			}
+		}
+	}
+	logger.Info("qos applied to the bridge port")
+	return nil
+}
+
+// removePortQos removes the QoS of a BridgePort. The tc rules are only removed to be safe, the vlan interfaces are
+// removed along with the port.
+func (s *server) removePortQos(ctx context.Context, vsi, stag int, vlans []int, qos portQos) {
+	if !qos.isSet() {
+		return
+	}
+	if p4Client, ok := s.p4RtClient.(types.QosP4RTClient); ok {
+		if err := p4Client.DeleteQosRules(ctx, getQosRuleSpec(vsi, qos)); err != nil {
+			tracing.Logger(ctx).WithField("error", err).Warnf("unable to remove the qos rules of vsi %d", vsi)
+		}
+		return
+	}
+	for _, vlan := range vlans {
+		vlanIntfName := getInnerVlanIntfName(s.uplinkInterface, stag, vlan)
+		if _, err := executeScriptFn("tc qdisc del dev " + vlanIntfName + " ingress"); err != nil {
+			tracing.Logger(ctx).WithField("error", err).Warnf("unable to remove the qos of %s", vlanIntfName)
+		}
+	}
+}
+
+// getTcQosCmds returns the tc commands policing and prioritizing the frames the VF sends, which the bridge
+// receives on the inner vlan interface
+func getTcQosCmds(vlanIntfName string, qos portQos) []string {
+	filter := fmt.Sprintf("tc filter replace dev %s ingress pref 1 handle 1 matchall", vlanIntfName)
+	if qos.Priority != 0 {
+		filter += fmt.Sprintf(" action skbedit priority %d", qos.Priority)
+	}
+	if qos.MaxTxRate != 0 {
+		// The burst is the traffic of qosBurstMs at the rate, in kbytes
+		burst := max(qos.MaxTxRate*qosBurstMs/8, 64)
+		filter += fmt.Sprintf(" action police rate %dmbit burst %dk conform-exceed drop", qos.MaxTxRate, burst)
+	}
+	return []string{"tc qdisc replace dev " + vlanIntfName + " ingress", filter}
+}
+
+func getQosRuleSpec(vsi int, qos portQos) types.QosRuleSpec {
+	return types.QosRuleSpec{Vsi: vsi, MaxTxRate: qos.MaxTxRate, MinTxRate: qos.MinTxRate, Priority: qos.Priority}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("bridge port qos", Serial, func() {
+	var (
+		ipuServer *server
+		links     *fakeLinkStore
+		cmdsMu    sync.Mutex
+		tcCmds    []string
+		tcErr     error
+	)
+
+	BeforeEach(func() {
+		links = newFakeLinkStore("enp0s1f0d3", "br-test")
+		linkByNameFn = links.linkByName
+		linkAddFn = links.linkAdd
+		linkDelFn = links.linkDel
+		linkSetMasterFn = fakeLinkSetMaster
+		linkSetNoMasterFn = fakeLinkSetNoMaster
+		linkSetUpFn = fakeLinkSetUp
+		linkSetDownFn = fakeLinkSetDown
+		tcCmds, tcErr = nil, nil
+		executeScriptFn = func(script string) (string, error) {
+			if !strings.HasPrefix(script, "tc ") {
+				return "", nil
+			}
+			cmdsMu.Lock()
+			defer cmdsMu.Unlock()
+			tcCmds = append(tcCmds, script)
+			return "", tcErr
+		}
+		DeferCleanup(func() {
+			linkDelFn = netlink.LinkDel
+			executeScriptFn = utils.ExecuteScript
+		})
+
+		ipuServer = &server{
+			uplinkInterface: "enp0s1f0d3",
+			bridgeCtlr:      NewLinuxBridgeController("br-test"),
+			p4RtClient:      &mockP4rtClient{},
+			log:             log.WithField("pkg", "qos_test.go"),
+		}
+	})
+
+	// createPort creates the BridgePort name on bridges with the QoS given by the metadata key/value pairs kv
+	createPort := func(name string, bridges []string, kv ...string) error {
+		ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs(kv...))
+		_, err := ipuServer.CreateBridgePort(ctx, &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+			Name: name,
+			Spec: &pb.BridgePortSpec{
+				MacAddress:     []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14},
+				LogicalBridges: bridges,
+			},
+		}})
+		return err
+	}
+	deletePort := func(name string) error {
+		_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: name})
+		return err
+	}
+
+	It("should police and prioritize the frames of the port with tc without FXP meters", func() {
+		Expect(createPort("port0", []string{"100"}, maxTxRateKey, "1000", priorityKey, "5")).To(Succeed())
+		Expect(tcCmds).To(Equal([]string{
+			"tc qdisc replace dev d3.0.100 ingress",
+			"tc filter replace dev d3.0.100 ingress pref 1 handle 1 matchall action skbedit priority 5 " +
+				"action police rate 1000mbit burst 1250k conform-exceed drop",
+		}))
+
+		tcCmds = nil
+		Expect(deletePort("port0")).To(Succeed())
+		Expect(tcCmds).To(Equal([]string{"tc qdisc del dev d3.0.100 ingress"}))
+	})
+
+	It("should add the FXP meter rules when the P4 package has them", func() {
+		p4rtClient := &mockQosP4rtClient{}
+		ipuServer.p4RtClient = p4rtClient
+		_, err := ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+			LogicalBridgeId: "blue",
+			LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: 100}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(createPort("port0", []string{"logicalBridges/blue"}, minTxRateKey, "100", maxTxRateKey, "1000")).
+			To(Succeed())
+		Expect(p4rtClient.qosAdded).To(Equal([]types.QosRuleSpec{{Vsi: 8, MaxTxRate: 1000, MinTxRate: 100}}))
+		Expect(tcCmds).To(BeEmpty())
+
+		Expect(deletePort("port0")).To(Succeed())
+		Expect(p4rtClient.qosDeleted).To(Equal(p4rtClient.qosAdded))
+	})
+
+	It("should apply the qos again when the vlans of the port could not be removed", func() {
+		Expect(createPort("port0", []string{"100"}, maxTxRateKey, "1000")).To(Succeed())
+
+		tcCmds = nil
+		linkDelFn = func(link netlink.Link) error {
+			return fmt.Errorf("fake LinkDel error")
+		}
+		Expect(status.Code(deletePort("port0"))).To(Equal(codes.Internal))
+		Expect(tcCmds).To(Equal(append([]string{"tc qdisc del dev d3.0.100 ingress"},
+			getTcQosCmds("d3.0.100", portQos{MaxTxRate: 1000})...)))
+
+		linkDelFn = links.linkDel
+		Expect(deletePort("port0")).To(Succeed())
+	})
+
+	It("should reject the port and remove its FXP meter rules when they could not all be added", func() {
+		p4rtClient := &mockQosP4rtClient{qosAddErr: fmt.Errorf("fake p4rt-ctl error")}
+		ipuServer.p4RtClient = p4rtClient
+		err := createPort("port0", []string{"100"}, priorityKey, "3")
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Expect(p4rtClient.qosDeleted).To(Equal(p4rtClient.qosAdded))
+		_, ok := ipuServer.getPort("port0")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should not apply any qos to the ports without one", func() {
+		p4rtClient := &mockQosP4rtClient{}
+		ipuServer.p4RtClient = p4rtClient
+		Expect(createPort("port0", []string{"100"})).To(Succeed())
+		Expect(deletePort("port0")).To(Succeed())
+		Expect(p4rtClient.qosAdded).To(BeEmpty())
+		Expect(p4rtClient.qosDeleted).To(BeEmpty())
+	})
+
+	It("should reject invalid qos settings", func() {
+		for _, kv := range [][]string{{maxTxRateKey, "fast"}, {minTxRateKey, "-1"}, {priorityKey, "8"},
+			{maxTxRateKey, "100", minTxRateKey, "200"}} {
+			err := createPort("port0", []string{"100"}, kv...)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument), "metadata %v", kv)
+			Expect(status.Convert(err).Details()).To(ContainElement(HaveField("FieldViolations",
+				ContainElement(HaveField("Field", BeElementOf(kv))))), "metadata %v", kv)
+		}
+		Expect(ipuServer.Ports).To(BeEmpty())
+	})
+
+	It("should reject a retried request with another qos", func() {
+		Expect(createPort("port0", []string{"100"}, maxTxRateKey, "1000")).To(Succeed())
+		Expect(createPort("port0", []string{"100"}, maxTxRateKey, "1000")).To(Succeed())
+		Expect(status.Code(createPort("port0", []string{"100"}, maxTxRateKey, "2000"))).To(Equal(codes.AlreadyExists))
+		Expect(status.Code(createPort("port0", []string{"100"}))).To(Equal(codes.AlreadyExists))
+	})
+
+	It("should remove the qos of a port restored from the state file", func() {
+		stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+		ipuServer.shutdown = ShutdownConfig{StateFile: stateFile}
+		Expect(createPort("port0", []string{"100"}, maxTxRateKey, "1000")).To(Succeed())
+
+		restored := &server{uplinkInterface: ipuServer.uplinkInterface, bridgeCtlr: ipuServer.bridgeCtlr,
+			p4RtClient: ipuServer.p4RtClient, log: ipuServer.log}
+		Expect(restored.restoreState(stateFile)).To(Succeed())
+		Expect(restored.portQos).To(Equal(map[string]portQos{"port0": {MaxTxRate: 1000}}))
+		tcCmds = nil
+		_, err := restored.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port0"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tcCmds).To(Equal([]string{"tc qdisc del dev d3.0.100 ingress"}))
+	})
+
+	It("should reject the qos which tc can't apply", func() {
+		_, err := ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+			LogicalBridgeId: "blue",
+			LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: 100}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Code(createPort("port0", []string{"logicalBridges/blue"}, maxTxRateKey, "1000"))).
+			To(Equal(codes.FailedPrecondition))
+		Expect(status.Code(createPort("port0", []string{"101"}, minTxRateKey, "100"))).
+			To(Equal(codes.FailedPrecondition))
+		Expect(tcCmds).To(BeEmpty())
+	})
+
+	It("should remove the vlans of the port when tc fails", func() {
+		tcErr = fmt.Errorf("tc failed")
+		err := createPort("port0", []string{"100"}, maxTxRateKey, "1000")
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Expect(links.names()).NotTo(ContainElement("d3.0.100"))
+		Expect(ipuServer.Ports).To(BeEmpty())
+	})
+
+	It("should keep a minimum burst for the low rates", func() {
+		Expect(getTcQosCmds("d3.0.100", portQos{MaxTxRate: 10})).To(ContainElement(
+			"tc filter replace dev d3.0.100 ingress pref 1 handle 1 matchall action police rate 10mbit burst 64k conform-exceed drop"))
+	})
+})
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// registry is a set of OPI resources keyed by their resource name. The resources are cloned in and out so that
+// the callers never share them. The zero value is ready to use.
+type registry[T proto.Message] struct {
+	mu    sync.RWMutex
+	items map[string]T
+}
+
+// get returns a copy of the resource name, if it exists
+func (r *registry[T]) get(name string) (T, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	item, ok := r.items[name]
+	if !ok {
+		return item, false
+	}
+	return proto.Clone(item).(T), true
+}
+
+func (r *registry[T]) set(name string, item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.items == nil {
+		r.items = make(map[string]T)
+	}
+	r.items[name] = proto.Clone(item).(T)
+}
+
+func (r *registry[T]) delete(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.items, name)
+}
+
+// all returns a copy of all the resources, keyed by name
+func (r *registry[T]) all() map[string]T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	items := make(map[string]T, len(r.items))
+	for name, item := range r.items {
+		items[name] = proto.Clone(item).(T)
+	}
+	return items
+}
+
+// list returns the page of at most pageSize resources, all of them when 0, following pageToken in name order,
+// along with the token of the next page
+func (r *registry[T]) list(pageSize int32, pageToken string) ([]T, string) {
+	items := r.all()
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	page, next := paginate(names, pageSize, pageToken)
+	list := make([]T, 0, len(page))
+	for _, name := range page {
+		list = append(list, items[name])
+	}
+	return list, next
+}
+
+// validatePageSize checks the page size of a List request, 0 meaning all the resources
+func validatePageSize(pageSize int32) error {
+	if pageSize < 0 {
+		return invalidArgument(fieldViolation("page_size", fmt.Sprintf("invalid page size %d", pageSize)))
+	}
+	return nil
+}
+
+// paginate returns the names of the page of at most pageSize names, all of them when 0, following pageToken in
+// name order, along with the token of the next page. The token is the last name of the page.
+func paginate(names []string, pageSize int32, pageToken string) ([]string, string) {
+	slices.Sort(names)
+	var page []string
+	for _, name := range names {
+		if name <= pageToken {
+			continue
+		}
+		if pageSize > 0 && len(page) == int(pageSize) {
+			return page, page[len(page)-1]
+		}
+		page = append(page, name)
+	}
+	return page, ""
+}
+
+// updateSpec copies the fields of the spec of src given by the update mask paths, e.g.; "spec.vlan_id", to dst.
+// The whole spec is copied when paths is empty or "*".
+func updateSpec(dst, src proto.Message, paths []string) error {
+	if len(paths) == 0 || slices.Contains(paths, "*") {
+		paths = []string{"spec"}
+	}
+	for _, path := range paths {
+		fields := strings.Split(path, ".")
+		if fields[0] != "spec" {
+			return invalidArgument(fieldViolation("update_mask",
+				fmt.Sprintf("invalid update mask path %q, only the spec can be updated", path)))
+		}
+		d, s := dst.ProtoReflect(), src.ProtoReflect()
+		for i, field := range fields {
+			fd := d.Descriptor().Fields().ByName(protoreflect.Name(field))
+			if fd == nil || (i < len(fields)-1 && fd.Message() == nil) {
+				return invalidArgument(fieldViolation("update_mask", fmt.Sprintf("invalid update mask path %q", path)))
+			}
+			if i == len(fields)-1 {
+				if s.Has(fd) {
+					d.Set(fd, s.Get(fd))
+				} else {
+					d.Clear(fd)
+				}
+				break
+			}
+			d, s = d.Mutable(fd).Message(), s.Get(fd).Message()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ResourcePool selects a class of devices that pods can request separately from the others.
+// All the non-empty selectors of a pool must match for a device to be part of it.
+type ResourcePool struct {
+	Name string `mapstructure:"name"`
+	// PCI addresses of the devices
+	PciAddresses []string `mapstructure:"pciAddresses"`
+	// Kernel drivers bound to the devices, e.g.; idpf or vfio-pci
+	Drivers []string `mapstructure:"drivers"`
+	// Parent PFs of the VFs, by netdev name or PCI address
+	PfNames []string `mapstructure:"pfNames"`
+	// Range of VF indexes on their PF, e.g.; "0-7"
+	VfIndexRange string `mapstructure:"vfIndexRange"`
+	// NUMA nodes the devices are attached to
+	NumaNodes []int `mapstructure:"numaNodes"`
+	// Regular expression the device MAC address has to match, e.g.; "^00:1[0-7]:"
+	MacPattern string `mapstructure:"macPattern"`
+}
+
+type resourcePool struct {
+	ResourcePool
+	vfIndexMin int
+	vfIndexMax int
+	macRegexp  *regexp.Regexp
+}
+
+// newResourcePools validates the pool definitions from the configuration
+func newResourcePools(pools []ResourcePool) (map[string]*resourcePool, error) {
+	res := make(map[string]*resourcePool, len(pools))
+	for _, p := range pools {
+		if p.Name == "" {
+			return nil, fmt.Errorf("resource pool name is empty")
+		}
+		if _, ok := res[p.Name]; ok {
+			return nil, fmt.Errorf("resource pool %s is defined more than once", p.Name)
+		}
+		pool := &resourcePool{ResourcePool: p, vfIndexMin: -1, vfIndexMax: -1}
+		if p.VfIndexRange != "" {
+			min, max, err := parseRange(p.VfIndexRange)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vfIndexRange for resource pool %s: %w", p.Name, err)
+			}
+			pool.vfIndexMin, pool.vfIndexMax = min, max
+		}
+		if p.MacPattern != "" {
+			re, err := regexp.Compile(strings.ToLower(p.MacPattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid macPattern for resource pool %s: %w", p.Name, err)
+			}
+			pool.macRegexp = re
+		}
+		res[p.Name] = pool
+	}
+	return res, nil
+}
+
+// parseRange parses "min-max" or a single value into an inclusive range
+func parseRange(r string) (int, int, error) {
+	bounds := strings.SplitN(r, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse range %q: %w", r, err)
+	}
+	max := min
+	if len(bounds) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+			return 0, 0, fmt.Errorf("unable to parse range %q: %w", r, err)
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("invalid range %q", r)
+	}
+	return min, max, nil
+}
+
+func (p *resourcePool) matches(info *deviceInfo) bool {
+	if len(p.PciAddresses) > 0 && !slices.Contains(p.PciAddresses, info.PciAddr) {
+		return false
+	}
+	if len(p.Drivers) > 0 && !slices.Contains(p.Drivers, info.Driver) {
+		return false
+	}
+	if len(p.PfNames) > 0 && !slices.Contains(p.PfNames, info.PfName) && !slices.Contains(p.PfNames, info.PfPciAddr) {
+		return false
+	}
+	if p.vfIndexMin >= 0 && (info.VfIndex < p.vfIndexMin || info.VfIndex > p.vfIndexMax) {
+		return false
+	}
+	if len(p.NumaNodes) > 0 && !slices.Contains(p.NumaNodes, info.NumaNode) {
+		return false
+	}
+	if p.macRegexp != nil && !p.macRegexp.MatchString(strings.ToLower(info.Mac)) {
+		return false
+	}
+	return true
+}
+
+// deviceInfo describes a netdev exposed through the DeviceService
+type deviceInfo struct {
+	Name      string `json:"name"`
+	PciAddr   string `json:"pciAddress,omitempty"`
+	Driver    string `json:"driver,omitempty"`
+	Mac       string `json:"mac,omitempty"`
+	Vsi       int    `json:"vsi,omitempty"`
+	NumaNode  int    `json:"numaNode"`
+	LocalCpus string `json:"localCpus,omitempty"`
+	PfPciAddr string `json:"pfPciAddress,omitempty"`
+	PfName    string `json:"pfName,omitempty"`
+	// VfIndex is -1 for devices which are not VFs
+	VfIndex int `json:"vfIndex"`
+}
+
+// getDeviceInfo reads the PCI and netdev attributes of a netdev from sysfs. Attributes that
+// cannot be read are left empty.
+func getDeviceInfo(netdev string) *deviceInfo {
+	info := &deviceInfo{Name: netdev, NumaNode: -1, VfIndex: -1}
+
+	if addr, err := os.ReadFile(filepath.Join(sysClassNet, netdev, "address")); err == nil {
+		info.Mac = strings.TrimSpace(string(addr))
+		// The second octet of the MAC address is the VSI number, unless the IMC says otherwise; see resolveVsi
+		if hwAddr, err := net.ParseMAC(info.Mac); err == nil && len(hwAddr) > 1 {
+			info.Vsi = int(hwAddr[1])
+		}
+	}
+
+	devDir, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, netdev, "device"))
+	if err != nil {
+		return info
+	}
+	info.PciAddr = filepath.Base(devDir)
+
+	if driver, err := filepath.EvalSymlinks(filepath.Join(devDir, "driver")); err == nil {
+		info.Driver = filepath.Base(driver)
+	}
+	info.NumaNode = getPciNumaNode(devDir)
+	info.LocalCpus = getPciLocalCpus(devDir)
+
+	pfDir, err := filepath.EvalSymlinks(filepath.Join(devDir, "physfn"))
+	if err != nil {
+		return info
+	}
+	info.PfPciAddr = filepath.Base(pfDir)
+	info.PfName = getPfNetDev(info.PfPciAddr)
+	info.VfIndex = getVfIndex(pfDir, info.PciAddr)
+
+	return info
+}
+
+// getVfIndex returns the index of VF vfPciAddr on the PF directory pfDir, or -1 if not found
+func getVfIndex(pfDir, vfPciAddr string) int {
+	vfDirs, err := filepath.Glob(filepath.Join(pfDir, "virtfn*"))
+	if err != nil {
+		return -1
+	}
+	for _, dir := range vfDirs {
+		linkName, err := filepath.EvalSymlinks(dir)
+		if err != nil || filepath.Base(linkName) != vfPciAddr {
+			continue
+		}
+		if idx, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "virtfn")); err == nil {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ShutdownPreserve leaves the vlan interfaces and the FXP rules in place on shutdown, the BridgePorts are saved
+	// to the state file and restored on the next start
+	ShutdownPreserve = "preserve"
+	// ShutdownTeardown deletes all the BridgePorts, the NF rules and the outer vlan on shutdown
+	ShutdownTeardown = "teardown"
+	// DefaultShutdownTimeout is the time given to the in-flight requests to complete on shutdown
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// ShutdownConfig is what the plugin does with the resources it created when it stops
+type ShutdownConfig struct {
+	// Policy is either ShutdownPreserve, the default, or ShutdownTeardown
+	Policy string
+	// Timeout is the time after which the in-flight requests are cancelled, no deadline when 0
+	Timeout time.Duration
+	// StateFile is where the BridgePorts are saved after each change and on shutdown, they are not persisted
+	// when empty
+	StateFile string
+}
+
+func (c ShutdownConfig) validate() error {
+	switch c.Policy {
+	case "", ShutdownPreserve, ShutdownTeardown:
+	default:
+		return fmt.Errorf("invalid shutdown policy %s, expected %s or %s", c.Policy, ShutdownPreserve, ShutdownTeardown)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid shutdown timeout %v", c.Timeout)
+	}
+	return nil
+}
+
+// ReloadableConfig is the configuration applied on SIGHUP, without closing the sockets of the plugin
+type ReloadableConfig struct {
+	ResourcePools     []ResourcePool
+	ExcludeInterfaces []string
+	Shutdown          ShutdownConfig
+}
+
+// ReloadFunc reads the configuration again
+type ReloadFunc func() (*ReloadableConfig, error)
+
+func (s *server) getShutdownConfig() ShutdownConfig {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	return s.shutdown
+}
+
+// Reload applies the configuration returned by reloadFn, nothing is applied when it is invalid
+func (s *server) Reload() error {
+	if s.reloadFn == nil {
+		return nil
+	}
+	cfg, err := s.reloadFn()
+	if err != nil {
+		return fmt.Errorf("unable to read configuration: %w", err)
+	}
+	if err := cfg.Shutdown.validate(); err != nil {
+		return err
+	}
+	if err := s.deviceService.setResourcePools(cfg.ResourcePools); err != nil {
+		return fmt.Errorf("invalid device plugin configuration: %w", err)
+	}
+	if err := s.deviceWatcher.SetExclude(cfg.ExcludeInterfaces); err != nil {
+		s.log.Warnf("unable to rescan devices: %v", err)
+	}
+	s.cfgMu.Lock()
+	s.shutdown = cfg.Shutdown
+	s.cfgMu.Unlock()
+	s.log.WithField("shutdownPolicy", cfg.Shutdown.Policy).Info("configuration reloaded")
+	return nil
+}
+
+// applyShutdownPolicy saves or tears down the BridgePorts once no request is in flight anymore
+func (s *server) applyShutdownPolicy() {
+	cfg := s.getShutdownConfig()
+	if cfg.Policy == ShutdownTeardown {
+		ctx := tracing.WithLogger(context.Background(), s.log.WithField("op", "teardown"))
+		if err := s.teardown(ctx); err != nil {
+			s.log.Errorf("teardown incomplete: %v", err)
+			return
+		}
+		if cfg.StateFile != "" {
+			if err := os.Remove(cfg.StateFile); err != nil && !os.IsNotExist(err) {
+				s.log.Warnf("unable to remove state file: %v", err)
+			}
+		}
+		s.log.Info("BridgePorts, NF rules and outer vlan removed")
+		return
+	}
+	if err := s.saveState(cfg.StateFile); err != nil {
+		s.log.Errorf("unable to save state: %v", err)
+		return
+	}
+	s.log.WithField("file", cfg.StateFile).Info("state saved")
+}
+
+// persistState saves the state after a change, so that the resources are not lost when the plugin crashes. The
+// request is not failed when the state cannot be saved, it is saved again on the next change and on shutdown.
+func (s *server) persistState(ctx context.Context) {
+	if err := s.saveState(s.getShutdownConfig().StateFile); err != nil {
+		tracing.Logger(ctx).WithField("error", err).Warn("unable to save state")
+	}
+}
+
+// teardown deletes all the BridgePorts, the rules of the deployed NF, the outer vlans and the vxlans
+func (s *server) teardown(ctx context.Context) error {
+	var errs []error
+	stags := map[int]bool{s.stag: true}
+	for _, name := range s.portNames() {
+		if pv, err := s.getPortVlans(name); err == nil {
+			stags[pv.Stag] = true
+		}
+		if _, err := s.DeleteBridgePort(ctx, &pb.DeleteBridgePortRequest{Name: name}); err != nil {
+			errs = append(errs, fmt.Errorf("bridge port %s: %w", name, err))
+		}
+	}
+	s.vfWatcher.teardownNetworkFunction(ctx)
+	if len(errs) > 0 {
+		// The outer vlans are still used by the remaining ports
+		return errors.Join(errs...)
+	}
+	for stag := range stags {
+		if err := s.removeOuterVlan(ctx, stag); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, bridge := range s.logicalBridges.all() {
+		if bridge.Spec.Vni == nil {
+			continue
+		}
+		if err := s.removeVxlan(ctx, bridge); err != nil {
+			errs = append(errs, fmt.Errorf("logical bridge %s: %w", name, err))
+			continue
+		}
+		// The vtep address is only removed along with the last logical bridge using it
+		s.logicalBridges.delete(name)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *server) portNames() []string {
+	s.portsMu.RLock()
+	defer s.portsMu.RUnlock()
+	names := make([]string, 0, len(s.Ports))
+	for name := range s.Ports {
+		names = append(names, name)
+	}
+	return names
+}
+
+// state is the content of the state file, each resource is keyed by name
+type state struct {
+	BridgePorts    map[string]json.RawMessage `json:"bridgePorts"`
+	LogicalBridges map[string]json.RawMessage `json:"logicalBridges"`
+	Vrfs           map[string]json.RawMessage `json:"vrfs"`
+	// Vsis are the VSIs of the VFs of the BridgePorts
+	Vsis map[string]int `json:"vsis"`
+	// AutoVlans are the vlans allocated to the BridgePorts created without one
+	AutoVlans map[string]int `json:"autoVlans,omitempty"`
+	// PortVlans are the S-tags and vlans the BridgePorts were created on
+	PortVlans map[string]portVlans `json:"portVlans,omitempty"`
+	// PortQos is the QoS of the BridgePorts created with one
+	PortQos map[string]portQos `json:"portQos,omitempty"`
+}
+
+// saveState writes the BridgePorts, LogicalBridges and Vrfs to stateFile
+func (s *server) saveState(stateFile string) error {
+	if stateFile == "" {
+		return nil
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.portsMu.RLock()
+	ports, err := encodeResources(s.Ports)
+	vsis := maps.Clone(s.vsis)
+	autoVlans := maps.Clone(s.autoVlans)
+	pvs := maps.Clone(s.portVlans)
+	qos := maps.Clone(s.portQos)
+	s.portsMu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("unable to encode bridge ports: %w", err)
+	}
+	bridges, err := encodeResources(s.logicalBridges.all())
+	if err != nil {
+		return fmt.Errorf("unable to encode logical bridges: %w", err)
+	}
+	vrfs, err := encodeResources(s.vrfs.all())
+	if err != nil {
+		return fmt.Errorf("unable to encode vrfs: %w", err)
+	}
+
+	data, err := json.MarshalIndent(state{BridgePorts: ports, LogicalBridges: bridges, Vrfs: vrfs, Vsis: vsis,
+		AutoVlans: autoVlans, PortVlans: pvs, PortQos: qos}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a crash never leaves a truncated state file
+	tmpFile := stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		return err
+	}
+	s.log.WithFields(log.Fields{"file": stateFile, "ports": len(ports), "logicalBridges": len(bridges),
+		"vrfs": len(vrfs)}).Debug("state written")
+	return nil
+}
+
+// restoreState loads the resources saved by saveState, a missing stateFile is not an error. A state file written
+// by an older version only holds the BridgePorts.
+func (s *server) restoreState(stateFile string) error {
+	if stateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	st := state{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", stateFile, err)
+	}
+	if st.BridgePorts == nil && st.LogicalBridges == nil && st.Vrfs == nil {
+		if err := json.Unmarshal(data, &st.BridgePorts); err != nil {
+			return fmt.Errorf("invalid state file %s: %w", stateFile, err)
+		}
+	}
+
+	// The logical bridges are restored first as the ports refer to them
+	bridges, err := decodeResources(st.LogicalBridges, func() *pb.LogicalBridge { return &pb.LogicalBridge{} })
+	if err != nil {
+		return fmt.Errorf("invalid logical bridge in state file %s: %w", stateFile, err)
+	}
+	for name, bridge := range bridges {
+		s.logicalBridges.set(name, bridge)
+	}
+	vrfs, err := decodeResources(st.Vrfs, func() *pb.Vrf { return &pb.Vrf{} })
+	if err != nil {
+		return fmt.Errorf("invalid vrf in state file %s: %w", stateFile, err)
+	}
+	for name, vrf := range vrfs {
+		s.vrfs.set(name, vrf)
+	}
+	ports, err := decodeResources(st.BridgePorts, func() *pb.BridgePort { return &pb.BridgePort{} })
+	if err != nil {
+		return fmt.Errorf("invalid bridge port in state file %s: %w", stateFile, err)
+	}
+	for name, port := range ports {
+		vsi, ok := st.Vsis[name]
+		if !ok && len(port.Spec.GetMacAddress()) > 1 {
+			// Saved before the VSIs were recorded, the VSI was the second octet of the mac address
+			vsi = int(port.Spec.MacAddress[1])
+		}
+		pv, ok := st.PortVlans[name]
+		if !ok {
+			// Saved before the vlans were recorded, they are resolved from the restored logical bridges, or on
+			// delete when they cannot be resolved yet
+			pv, _ = s.resolvePortVlans(port.Spec.GetLogicalBridges())
+		}
+		s.setPort(name, port, vsi, pv, st.AutoVlans[name], st.PortQos[name])
+	}
+	s.log.WithFields(log.Fields{"file": stateFile, "ports": len(ports), "logicalBridges": len(bridges),
+		"vrfs": len(vrfs)}).Info("state restored")
+	return nil
+}
+
+// encodeResources returns the protojson encoding of each resource
+func encodeResources[T proto.Message](items map[string]T) (map[string]json.RawMessage, error) {
+	raw := make(map[string]json.RawMessage, len(items))
+	for name, item := range items {
+		data, err := protojson.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		raw[name] = data
+	}
+	return raw, nil
+}
+
+// decodeResources decodes the resources encoded by encodeResources, newItem returns an empty resource
+func decodeResources[T proto.Message](raw map[string]json.RawMessage, newItem func() T) (map[string]T, error) {
+	items := make(map[string]T, len(raw))
+	for name, data := range raw {
+		item := newItem()
+		if err := protojson.Unmarshal(data, item); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		items[name] = item
+	}
+	return items, nil
+}
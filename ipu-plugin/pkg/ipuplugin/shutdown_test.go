@@ -0,0 +1,257 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/p4rtclient"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// blockingBrCtlr blocks adding the inner vlans to the bridge until the request is cancelled, the other methods
+// are the mockBrCtlr ones
+type blockingBrCtlr struct {
+	mockBrCtlr
+	entered chan struct{}
+}
+
+func (b *blockingBrCtlr) AddPort(ctx context.Context, portName string) error {
+	if portName == "d3.0" {
+		return nil
+	}
+	close(b.entered)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+var _ = Describe("Shutdown", Serial, func() {
+	var (
+		ipuServer *server
+		links     *fakeLinkStore
+	)
+
+	newPort := func(i int) *pb.BridgePort {
+		return &pb.BridgePort{
+			Name: fmt.Sprintf("port%d", i),
+			Spec: &pb.BridgePortSpec{
+				MacAddress:     []byte{0x00, byte(i + 1), 0x00, 0x00, 0x03, 0x14},
+				LogicalBridges: []string{fmt.Sprint(100 + i)},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		links = newFakeLinkStore("enp0s1f0d3", "br-test")
+		linkByNameFn = links.linkByName
+		linkAddFn = links.linkAdd
+		linkDelFn = links.linkDel
+		linkSetMasterFn = fakeLinkSetMaster
+		linkSetNoMasterFn = fakeLinkSetNoMaster
+		linkSetUpFn = fakeLinkSetUp
+		linkSetDownFn = fakeLinkSetDown
+		executeScriptFn = func(string) (string, error) { return "", nil }
+		DeferCleanup(func() {
+			linkDelFn = netlink.LinkDel
+			executeScriptFn = utils.ExecuteScript
+		})
+
+		ipuServer = &server{
+			uplinkInterface: "enp0s1f0d3",
+			bridgeCtlr:      NewLinuxBridgeController("br-test"),
+			p4RtClient:      &mockP4rtClient{},
+			mode:            types.IpuMode,
+			log:             log.WithField("pkg", "shutdown_test.go"),
+		}
+	})
+
+	Context("with the preserve policy", func() {
+		It("should restore the saved BridgePorts", func() {
+			stateFile := filepath.Join(GinkgoT().TempDir(), "state", "bridgeports.json")
+			for i := 0; i < 2; i++ {
+				_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: newPort(i)})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			ipuServer.shutdown = ShutdownConfig{Policy: ShutdownPreserve, StateFile: stateFile}
+			ipuServer.applyShutdownPolicy()
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test", "d3.0", "d3.0.100", "d3.0.101"))
+
+			restored := &server{log: ipuServer.log}
+			Expect(restored.restoreState(stateFile)).To(Succeed())
+			Expect(restored.Ports).To(HaveLen(2))
+			for name, port := range ipuServer.Ports {
+				Expect(proto.Equal(restored.Ports[name], port)).To(BeTrue(), "bridge port %s", name)
+			}
+			Expect(restored.vsis).To(Equal(map[string]int{"port0": 1, "port1": 2}))
+		})
+
+		It("should restore a state file holding only the BridgePorts", func() {
+			stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+			Expect(os.WriteFile(stateFile, []byte(`{"port0": {"name": "port0", "spec": {"logicalBridges": ["100"]}}}`),
+				0600)).To(Succeed())
+			Expect(ipuServer.restoreState(stateFile)).To(Succeed())
+			Expect(ipuServer.Ports).To(HaveKey("port0"))
+			Expect(ipuServer.Ports["port0"].Spec.LogicalBridges).To(Equal([]string{"100"}))
+		})
+
+		It("should save the state after each change", func() {
+			stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+			ipuServer.shutdown = ShutdownConfig{Policy: ShutdownPreserve, StateFile: stateFile}
+			for i := 0; i < 2; i++ {
+				_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: newPort(i)})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			_, err := ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			restored := &server{log: ipuServer.log}
+			Expect(restored.restoreState(stateFile)).To(Succeed())
+			Expect(restored.Ports).To(HaveLen(1))
+			Expect(restored.Ports).To(HaveKey("port1"))
+			Expect(restored.portVlans).To(Equal(map[string]portVlans{"port1": {Stag: 0, Vlans: []int{101}}}))
+			Expect(stateFile + ".tmp").NotTo(BeAnExistingFile())
+		})
+
+		It("should start without BridgePorts when there is no state file", func() {
+			Expect(ipuServer.restoreState(filepath.Join(GinkgoT().TempDir(), "bridgeports.json"))).To(Succeed())
+			Expect(ipuServer.Ports).To(BeEmpty())
+		})
+	})
+
+	Context("with the teardown policy", func() {
+		It("should delete the BridgePorts, the NF rules and the outer vlan", func() {
+			var nfDeleted []string
+			deleteNFRulesFn = func(_ context.Context, _ string, vfMacList []string, input, output string) {
+				nfDeleted = append(vfMacList, input, output)
+			}
+			DeferCleanup(func() { deleteNFRulesFn = p4rtclient.DeleteNetworkFunctionRules })
+			ipuServer.vfWatcher = NewVfWatcher("", 0)
+			ipuServer.vfWatcher.setNetworkFunction([]string{"00:00:00:00:00:01"}, "00:00:00:00:00:0a", "00:00:00:00:00:0b")
+
+			for i := 0; i < 2; i++ {
+				_, err := ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: newPort(i)})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+			Expect(os.WriteFile(stateFile, []byte("{}"), 0600)).To(Succeed())
+			ipuServer.shutdown = ShutdownConfig{Policy: ShutdownTeardown, StateFile: stateFile}
+			ipuServer.applyShutdownPolicy()
+
+			Expect(ipuServer.Ports).To(BeEmpty())
+			Expect(links.names()).To(ConsistOf("enp0s1f0d3", "br-test"))
+			Expect(nfDeleted).To(Equal([]string{"00:00:00:00:00:01", "00:00:00:00:00:0a", "00:00:00:00:00:0b"}))
+			Expect(ipuServer.vfWatcher.nfInput).To(BeEmpty())
+			Expect(stateFile).NotTo(BeAnExistingFile())
+		})
+	})
+
+	It("should cancel the in-flight requests after the shutdown timeout", func() {
+		brCtlr := &blockingBrCtlr{entered: make(chan struct{})}
+		sockPath := filepath.Join(GinkgoT().TempDir(), "plugin.sock")
+		runnable, err := NewIpuPlugin(brCtlr, &mockP4rtClient{}, Config{
+			Bridge:    "br-test",
+			Interface: "enp0s1f0d3",
+			Mode:      types.IpuMode,
+			Listeners: []ListenerConfig{{Proto: "unix", Addr: sockPath}},
+			Shutdown:  ShutdownConfig{Timeout: 100 * time.Millisecond},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		s := runnable.(*server)
+		s.registerServices()
+		Expect(s.listeners[0].listen(make(chan error, 1))).To(Succeed())
+
+		conn, err := grpc.NewClient("unix://"+sockPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := pb.NewBridgePortServiceClient(conn).CreateBridgePort(context.Background(),
+				&pb.CreateBridgePortRequest{BridgePort: newPort(0)})
+			errCh <- err
+		}()
+		Eventually(brCtlr.entered).Should(BeClosed())
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			s.Stop()
+		}()
+		Eventually(stopped, 5*time.Second).Should(BeClosed())
+		var rpcErr error
+		Eventually(errCh).Should(Receive(&rpcErr))
+		Expect(status.Code(rpcErr)).To(BeElementOf(codes.Canceled, codes.Unavailable))
+	})
+})
+
+var _ = Describe("reload", func() {
+	var (
+		s        *server
+		reloaded *ReloadableConfig
+	)
+
+	BeforeEach(func() {
+		reloaded = &ReloadableConfig{}
+		sockPath := filepath.Join(GinkgoT().TempDir(), "plugin.sock")
+		runnable, err := NewIpuPlugin(nil, nil, Config{
+			Mode:          types.HostMode,
+			ResourcePools: []ResourcePool{{Name: "dpdk", Drivers: []string{"vfio-pci"}}},
+			Listeners:     []ListenerConfig{{Proto: "unix", Addr: sockPath}},
+			Shutdown:      ShutdownConfig{Policy: ShutdownPreserve},
+			ReloadFn:      func() (*ReloadableConfig, error) { return reloaded, nil },
+		})
+		Expect(err).NotTo(HaveOccurred())
+		s = runnable.(*server)
+	})
+
+	It("should apply the new resource pools, excluded interfaces and shutdown config", func() {
+		reloaded.ResourcePools = []ResourcePool{{Name: "kernel", Drivers: []string{"idpf"}}}
+		reloaded.ExcludeInterfaces = []string{"ens5f0"}
+		reloaded.Shutdown = ShutdownConfig{Policy: ShutdownTeardown, Timeout: time.Second}
+		Expect(s.Reload()).To(Succeed())
+
+		Expect(s.deviceService.pools).To(HaveKey("kernel"))
+		Expect(s.deviceService.pools).NotTo(HaveKey("dpdk"))
+		Expect(s.deviceWatcher.exclude).To(Equal([]string{"ens5f0"}))
+		Expect(s.getShutdownConfig()).To(Equal(reloaded.Shutdown))
+	})
+
+	It("should keep the current configuration when the new one is invalid", func() {
+		reloaded.ResourcePools = []ResourcePool{{Name: "kernel", VfIndexRange: "7-0"}}
+		reloaded.Shutdown = ShutdownConfig{Policy: ShutdownTeardown}
+		Expect(s.Reload()).NotTo(Succeed())
+		Expect(s.deviceService.pools).To(HaveKey("dpdk"))
+		Expect(s.getShutdownConfig().Policy).To(Equal(ShutdownPreserve))
+
+		reloaded.ResourcePools = nil
+		reloaded.Shutdown = ShutdownConfig{Policy: "destroy"}
+		Expect(s.Reload()).NotTo(Succeed())
+		Expect(s.deviceService.pools).To(HaveKey("dpdk"))
+	})
+})
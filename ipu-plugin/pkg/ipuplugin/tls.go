@@ -0,0 +1,181 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSConfig holds the certificate files of the gRPC server. TLS is disabled when no certificate is set,
+// and clients have to present a certificate signed by ClientCAFile (mutual TLS) when it is set.
+type TLSConfig struct {
+	CertFile     string `mapstructure:"certFile"`
+	KeyFile      string `mapstructure:"keyFile"`
+	ClientCAFile string `mapstructure:"clientCAFile"`
+}
+
+// Enabled returns whether the server certificate is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate returns an error when the configuration cannot be served, e.g.; a client CA without a server
+// certificate, which would silently disable the mutual TLS it asks for
+func (c TLSConfig) Validate() error {
+	if c.ClientCAFile != "" && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("the client CA %s requires both the TLS certificate and key files", c.ClientCAFile)
+	}
+	if c.Enabled() && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("both the TLS certificate and key files must be set")
+	}
+	return nil
+}
+
+// certReloader serves the last valid server certificate and client CA pool, and reloads them when the files
+// change, e.g.; when cert-manager renews the certificate mounted from a secret.
+type certReloader struct {
+	cfg     TLSConfig
+	log     *log.Entry
+	watcher *fsnotify.Watcher
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// newCertReloader loads the certificates of cfg and starts watching their files
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("both the TLS certificate and key files must be set")
+	}
+	r := &certReloader{cfg: cfg, log: log.WithField("pkg", "tls")}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch the TLS certificate files: %w", err)
+	}
+	// Watch the directories rather than the files, secrets are updated by swapping symlinks
+	dirs := map[string]bool{}
+	for _, file := range r.files() {
+		dir := filepath.Dir(file)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("unable to watch %s: %w", dir, err)
+		}
+		dirs[dir] = true
+	}
+	r.watcher = watcher
+	go r.run()
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the TLS certificate %s: %w", r.cfg.CertFile, err)
+	}
+	var clientCA *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		caPem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the client CA %s: %w", r.cfg.ClientCAFile, err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf("no valid certificate found in the client CA %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCA = clientCA
+	return nil
+}
+
+func (r *certReloader) run() {
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// A partial update fails to load; the next event of the same update triggers another attempt
+			if err := r.load(); err != nil {
+				r.log.WithField("error", err).Warn("unable to reload the TLS certificates, keeping the previous ones")
+			} else {
+				r.log.WithField("file", event.Name).Info("TLS certificates reloaded")
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			r.log.WithField("error", err).Warn("error watching the TLS certificate files")
+		}
+	}
+}
+
+// Stop stops watching the certificate files. It is safe to call on a nil reloader.
+func (r *certReloader) Stop() {
+	if r == nil {
+		return
+	}
+	r.watcher.Close()
+}
+
+// serverTLSConfig returns a TLS configuration which always uses the last loaded certificates
+func (r *certReloader) serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// newTestCert returns a certificate signed by parent (self-signed when parent is nil) and its PEM encoding
+func newTestCert(cn string, parent *tls.Certificate) (*tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{cn},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	Expect(err).NotTo(HaveOccurred())
+	cert.Leaf, err = x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return &cert, certPem, keyPem
+}
+
+// tlsHandshake runs a TLS handshake between the server config and a client presenting clientCert
+func tlsHandshake(serverCfg *tls.Config, caPool *x509.CertPool, clientCert *tls.Certificate) (*x509.Certificate, error) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- tls.Server(serverConn, serverCfg).Handshake()
+	}()
+	clientCfg := &tls.Config{RootCAs: caPool, ServerName: "ipuplugin"}
+	if clientCert != nil {
+		clientCfg.Certificates = []tls.Certificate{*clientCert}
+	}
+	client := tls.Client(clientConn, clientCfg)
+	err := client.Handshake()
+	// with TLS 1.3 the client certificate is verified after the client handshake has completed, close the
+	// connection so that the server does not block sending an alert nobody reads
+	clientConn.Close()
+	if srvErr := <-srvErr; err == nil {
+		err = srvErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.ConnectionState().PeerCertificates[0], nil
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		tmpDir   string
+		cfg      TLSConfig
+		ca       *tls.Certificate
+		caPool   *x509.CertPool
+		reloader *certReloader
+	)
+
+	writeServerCert := func() *tls.Certificate {
+		cert, certPem, keyPem := newTestCert("ipuplugin", ca)
+		Expect(os.WriteFile(cfg.KeyFile, keyPem, 0600)).To(Succeed())
+		Expect(os.WriteFile(cfg.CertFile, certPem, 0644)).To(Succeed())
+		return cert
+	}
+
+	BeforeEach(func() {
+		tmpDir = GinkgoT().TempDir()
+		var caPem []byte
+		ca, caPem, _ = newTestCert("ca", nil)
+		caPool = x509.NewCertPool()
+		caPool.AppendCertsFromPEM(caPem)
+
+		cfg = TLSConfig{
+			CertFile:     filepath.Join(tmpDir, "tls.crt"),
+			KeyFile:      filepath.Join(tmpDir, "tls.key"),
+			ClientCAFile: filepath.Join(tmpDir, "ca.crt"),
+		}
+		Expect(os.WriteFile(cfg.ClientCAFile, caPem, 0644)).To(Succeed())
+		writeServerCert()
+	})
+
+	AfterEach(func() {
+		reloader.Stop()
+		reloader = nil
+	})
+
+	It("should require a client certificate signed by the client CA", func() {
+		var err error
+		reloader, err = newCertReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		clientCert, _, _ := newTestCert("client", ca)
+		_, err = tlsHandshake(reloader.serverTLSConfig(), caPool, clientCert)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = tlsHandshake(reloader.serverTLSConfig(), caPool, nil)
+		Expect(err).To(HaveOccurred())
+
+		otherCa, _, _ := newTestCert("other-ca", nil)
+		untrustedCert, _, _ := newTestCert("client", otherCa)
+		_, err = tlsHandshake(reloader.serverTLSConfig(), caPool, untrustedCert)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should serve the new certificate once the files are updated", func() {
+		var err error
+		reloader, err = newCertReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		clientCert, _, _ := newTestCert("client", ca)
+		newCert := writeServerCert()
+		Eventually(func() ([]byte, error) {
+			peer, err := tlsHandshake(reloader.serverTLSConfig(), caPool, clientCert)
+			if err != nil {
+				return nil, err
+			}
+			return peer.Raw, nil
+		}).Should(Equal(newCert.Leaf.Raw))
+	})
+
+	It("should keep the previous certificate when the new one is invalid", func() {
+		var err error
+		reloader, err = newCertReloader(cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.WriteFile(cfg.CertFile, []byte("invalid"), 0644)).To(Succeed())
+		clientCert, _, _ := newTestCert("client", ca)
+		Consistently(func() error {
+			_, err := tlsHandshake(reloader.serverTLSConfig(), caPool, clientCert)
+			return err
+		}, "200ms").Should(Succeed())
+	})
+
+	It("should fail to start with a missing key", func() {
+		_, err := newCertReloader(TLSConfig{CertFile: cfg.CertFile})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject a client CA without a server certificate", func() {
+		Expect(TLSConfig{ClientCAFile: cfg.ClientCAFile}.Validate()).To(MatchError(ContainSubstring("requires both")))
+		Expect(TLSConfig{ClientCAFile: cfg.ClientCAFile, CertFile: cfg.CertFile}.Validate()).To(HaveOccurred())
+		Expect(cfg.Validate()).To(Succeed())
+		Expect(TLSConfig{}.Validate()).To(Succeed())
+
+		_, err := newListener(ListenerConfig{Proto: "unix", Addr: filepath.Join(tmpDir, "plugin.sock"),
+			TLS: TLSConfig{ClientCAFile: cfg.ClientCAFile}})
+		Expect(err).To(MatchError(ContainSubstring("invalid TLS configuration")))
+	})
+})
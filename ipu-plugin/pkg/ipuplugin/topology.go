@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pb "github.com/openshift/dpu-operator/dpu-api/gen"
+)
+
+// VfTopology is the PCI address of a VF along with the NUMA node and the CPUs local to it
+type VfTopology struct {
+	PciAddr string
+	// NumaNode is -1 when the platform does not report a NUMA node for the device
+	NumaNode int
+	// LocalCpus is the kernel cpulist format, e.g.; "0-15,32-47"
+	LocalCpus string
+}
+
+// getTopologyInfo returns the topology hint for a device on NUMA node numaNode. No hint is returned
+// when the NUMA node is unknown so that the topology manager does not constrain the pod placement.
+func getTopologyInfo(numaNode int) *pb.TopologyInfo {
+	if numaNode < 0 {
+		return nil
+	}
+	return &pb.TopologyInfo{Node: strconv.Itoa(numaNode)}
+}
+
+// getPciNumaNode returns the NUMA node of a PCI device directory, or -1 when unknown
+func getPciNumaNode(devDir string) int {
+	numaByte, err := os.ReadFile(filepath.Join(devDir, "numa_node"))
+	if err != nil {
+		return -1
+	}
+	numa, err := strconv.Atoi(strings.TrimSpace(string(numaByte)))
+	if err != nil {
+		return -1
+	}
+	return numa
+}
+
+// getPciLocalCpus returns the list of CPUs local to a PCI device directory, or "" when unknown
+func getPciLocalCpus(devDir string) string {
+	cpuList, err := os.ReadFile(filepath.Join(devDir, "local_cpulist"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(cpuList))
+}
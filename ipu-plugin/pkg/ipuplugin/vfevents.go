@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// vfEventServiceFullName is the gRPC name of the VfEventService, which streams the VfEvents of the VfWatcher to
+// the dpu-daemon. The dpu-api has no such service, its messages are well-known types: the request is an Empty and
+// each event a Struct, e.g.; {"type": "added", "mac": "00:13:00:00:03:14"}.
+const (
+	vfEventServiceFullName = "ipuplugin.VfEventService"
+	watchVfEventsMethod    = "WatchVfEvents"
+)
+
+type vfEventServer interface {
+	WatchVfEvents(*emptypb.Empty, grpc.ServerStream) error
+}
+
+var vfEventServiceDesc = grpc.ServiceDesc{
+	ServiceName: vfEventServiceFullName,
+	HandlerType: (*vfEventServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    watchVfEventsMethod,
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := &emptypb.Empty{}
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(vfEventServer).WatchVfEvents(in, stream)
+		},
+	}},
+	Metadata: "vfevents.go",
+}
+
+// registerVfEventService registers the VfEventService of w on srv
+func registerVfEventService(srv grpc.ServiceRegistrar, w *VfWatcher) {
+	srv.RegisterService(&vfEventServiceDesc, w)
+}
+
+// WatchVfEvents streams the VfEvents until the client goes away or the watcher is stopped
+func (w *VfWatcher) WatchVfEvents(_ *emptypb.Empty, stream grpc.ServerStream) error {
+	events := w.Subscribe()
+	defer w.unsubscribe(events)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "the VF watcher is stopped")
+			}
+			msg, err := structpb.NewStruct(map[string]interface{}{"type": ev.Type.String(), "mac": ev.Mac})
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// VfEventStream receives the VfEvents of an IPU plugin
+type VfEventStream struct {
+	stream grpc.ClientStream
+}
+
+// WatchVfEvents subscribes to the VfEvents of the IPU plugin served on conn, e.g.; for the dpu-daemon to learn
+// about the host VFs created or removed after Init
+func WatchVfEvents(ctx context.Context, conn grpc.ClientConnInterface) (*VfEventStream, error) {
+	stream, err := conn.NewStream(ctx, &vfEventServiceDesc.Streams[0],
+		"/"+vfEventServiceFullName+"/"+watchVfEventsMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &VfEventStream{stream: stream}, nil
+}
+
+// Recv blocks until the next VfEvent is received
+func (s *VfEventStream) Recv() (VfEvent, error) {
+	msg := &structpb.Struct{}
+	if err := s.stream.RecvMsg(msg); err != nil {
+		return VfEvent{}, err
+	}
+	ev := VfEvent{Mac: msg.Fields["mac"].GetStringValue()}
+	switch t := msg.Fields["type"].GetStringValue(); t {
+	case VfAdded.String():
+		ev.Type = VfAdded
+	case VfRemoved.String():
+		ev.Type = VfRemoved
+	default:
+		return VfEvent{}, fmt.Errorf("unknown VF event type %q", t)
+	}
+	return ev, nil
+}
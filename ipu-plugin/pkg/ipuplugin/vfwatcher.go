@@ -0,0 +1,309 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/p4rtclient"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type VfEventType int
+
+const (
+	VfAdded VfEventType = iota
+	VfRemoved
+)
+
+func (t VfEventType) String() string {
+	switch t {
+	case VfAdded:
+		return "added"
+	case VfRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// VfEvent is emitted by the VfWatcher every time a host VF shows up in or disappears from the IMC VSI table.
+type VfEvent struct {
+	Type VfEventType
+	Mac  string
+}
+
+var (
+	// Abstract the IMC query and the FXP programming for unit tests
+	getVfMacListFn                   = utils.GetVfMacList
+	addVFsToPointToPointRulesFn      = p4rtclient.AddVFsToPointToPointRules
+	removeVFsFromPointToPointRulesFn = p4rtclient.RemoveVFsFromPointToPointRules
+	addVFsToNFRulesFn                = p4rtclient.AddVFsToNetworkFunctionRules
+	removeVFsFromNFRulesFn           = p4rtclient.RemoveVFsFromNetworkFunctionRules
+	deleteNFRulesFn                  = p4rtclient.DeleteNetworkFunctionRules
+)
+
+// VfWatcher keeps the FXP rules between host VFs in sync with the VFs currently known by the IMC.
+// VFs created by SetNumVfs after Init get their point-to-point (or NF) rules programmed, and the rules of
+// removed VFs are cleaned up.
+type VfWatcher struct {
+	p4rtbin  string
+	interval time.Duration
+	log      *log.Entry
+
+	// mu serializes resyncs with the NF service programming the same FXP tables
+	mu          sync.Mutex
+	vfMacList   []string
+	nfInput     string
+	nfOutput    string
+	subscribers []chan VfEvent
+	started     bool
+	trigger     chan struct{}
+	stopCh      chan struct{}
+}
+
+// NewVfWatcher returns a watcher which re-reads the VF table every interval once started.
+// A zero interval disables the periodic resync; Trigger can still be used to request one.
+func NewVfWatcher(p4rtbin string, interval time.Duration) *VfWatcher {
+	return &VfWatcher{
+		p4rtbin:  p4rtbin,
+		interval: interval,
+		log:      log.WithField("pkg", "vfwatcher"),
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Start records the VFs already programmed by Init and starts watching for changes. Calling Start
+// again on a running watcher only updates the recorded VF list.
+func (w *VfWatcher) Start(vfMacList []string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.vfMacList = filterVfMacList(vfMacList)
+	if w.started {
+		return
+	}
+	w.started = true
+	w.stopCh = make(chan struct{})
+	go w.run(w.stopCh)
+	w.log.WithField("interval", w.interval).Infof("VF watcher started with %d VFs", len(w.vfMacList))
+}
+
+// Stop terminates the watcher goroutine and closes all subscriber channels.
+func (w *VfWatcher) Stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.started {
+		close(w.stopCh)
+		w.started = false
+	}
+	for _, ch := range w.subscribers {
+		close(ch)
+	}
+	w.subscribers = nil
+}
+
+// Trigger requests an immediate resync, e.g.; after an IMC event or after the number of VFs was changed.
+func (w *VfWatcher) Trigger() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+		// a resync is already pending
+	}
+}
+
+// Subscribe returns a channel on which VF add/remove events are delivered. Events are dropped
+// for subscribers that do not keep up.
+func (w *VfWatcher) Subscribe() <-chan VfEvent {
+	if w == nil {
+		return nil
+	}
+	ch := make(chan VfEvent, 64)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
+// unsubscribe stops delivering the events to a channel returned by Subscribe
+func (w *VfWatcher) unsubscribe(events <-chan VfEvent) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, ch := range w.subscribers {
+		if (<-chan VfEvent)(ch) == events {
+			w.subscribers = append(w.subscribers[:i], w.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// pause blocks resyncs until the returned function is called. It is safe to call on a nil watcher.
+func (w *VfWatcher) pause() func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	return w.mu.Unlock
+}
+
+// setNetworkFunction records the FXP state left by the NF service. Must be called with the watcher paused.
+func (w *VfWatcher) setNetworkFunction(vfMacList []string, input, output string) {
+	if w == nil {
+		return
+	}
+	w.vfMacList = filterVfMacList(vfMacList)
+	w.nfInput = input
+	w.nfOutput = output
+}
+
+// teardownNetworkFunction removes the rules of the deployed NF from the FXP, if any
+func (w *VfWatcher) teardownNetworkFunction(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	defer w.pause()()
+	if w.nfInput == "" {
+		return
+	}
+	deleteNFRulesFn(ctx, w.p4rtbin, w.vfMacList, w.nfInput, w.nfOutput)
+	w.nfInput, w.nfOutput = "", ""
+	metrics.NetworkFunctions.Set(0)
+}
+
+func (w *VfWatcher) run(stopCh <-chan struct{}) {
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			w.log.Info("VF watcher stopped")
+			return
+		case <-tick:
+		case <-w.trigger:
+		}
+		if err := w.resync(); err != nil {
+			w.log.WithField("error", err).Warn("unable to resync VFs")
+		}
+	}
+}
+
+// resync reads the VF table from the IMC and updates the FXP rules for the VFs that were added or removed.
+func (w *VfWatcher) resync() error {
+	current, err := getVfMacListFn()
+	if err != nil {
+		return err
+	}
+	current = filterVfMacList(current)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	added, removed := diffVfMacList(w.vfMacList, current)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	w.log.WithField("added", added).WithField("removed", removed).Info("host VFs changed, updating FXP rules")
+
+	if w.nfInput != "" {
+		if len(removed) > 0 {
+			removeVFsFromNFRulesFn(w.p4rtbin, removed, w.nfInput)
+		}
+		if len(added) > 0 {
+			addVFsToNFRulesFn(w.p4rtbin, added, w.nfInput)
+		}
+	} else {
+		if len(removed) > 0 {
+			// the rules of removed VFs have to be generated against the previous list
+			removeVFsFromPointToPointRulesFn(w.p4rtbin, w.vfMacList, removed)
+		}
+		if len(added) > 0 {
+			addVFsToPointToPointRulesFn(w.p4rtbin, current, added)
+		}
+	}
+	w.vfMacList = current
+
+	for _, mac := range removed {
+		w.publish(VfEvent{Type: VfRemoved, Mac: mac})
+	}
+	for _, mac := range added {
+		w.publish(VfEvent{Type: VfAdded, Mac: mac})
+	}
+	return nil
+}
+
+// publish must be called with mu held
+func (w *VfWatcher) publish(ev VfEvent) {
+	w.log.WithField("mac", ev.Mac).Infof("VF %s", ev.Type)
+	for _, ch := range w.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			w.log.WithField("mac", ev.Mac).Warn("VF event subscriber is not keeping up, dropping event")
+		}
+	}
+}
+
+func filterVfMacList(vfMacList []string) []string {
+	filtered := make([]string, 0, len(vfMacList))
+	for _, mac := range vfMacList {
+		if mac != "" {
+			filtered = append(filtered, mac)
+		}
+	}
+	return filtered
+}
+
+// diffVfMacList returns the VFs only present in current (added) and the ones only present in previous (removed)
+func diffVfMacList(previous, current []string) (added, removed []string) {
+	prevSet := make(map[string]bool, len(previous))
+	for _, mac := range previous {
+		prevSet[mac] = true
+	}
+	curSet := make(map[string]bool, len(current))
+	for _, mac := range current {
+		curSet[mac] = true
+		if !prevSet[mac] {
+			added = append(added, mac)
+		}
+	}
+	for _, mac := range previous {
+		if !curSet[mac] {
+			removed = append(removed, mac)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var _ = Describe("VfWatcher", Serial, func() {
+	var (
+		watcher  *VfWatcher
+		imcVfs   []string
+		imcErr   error
+		p2pAdded [][]string
+		p2pDel   [][]string
+		nfAdded  [][]string
+		nfDel    [][]string
+	)
+
+	BeforeEach(func() {
+		imcVfs, imcErr = nil, nil
+		p2pAdded, p2pDel, nfAdded, nfDel = nil, nil, nil, nil
+		getVfMacListFn = func() ([]string, error) { return imcVfs, imcErr }
+		addVFsToPointToPointRulesFn = func(_ string, _ []string, vfMacs []string) { p2pAdded = append(p2pAdded, vfMacs) }
+		removeVFsFromPointToPointRulesFn = func(_ string, _ []string, vfMacs []string) { p2pDel = append(p2pDel, vfMacs) }
+		addVFsToNFRulesFn = func(_ string, vfMacs []string, _ string) { nfAdded = append(nfAdded, vfMacs) }
+		removeVFsFromNFRulesFn = func(_ string, vfMacs []string, _ string) { nfDel = append(nfDel, vfMacs) }
+
+		watcher = NewVfWatcher("fakebinary", 0)
+		watcher.vfMacList = []string{"00:11:00:00:03:14", "00:12:00:00:03:14"}
+	})
+
+	Context("when the VF table has not changed", func() {
+		It("should not program any rule", func() {
+			imcVfs = []string{"00:12:00:00:03:14", "00:11:00:00:03:14"}
+			Expect(watcher.resync()).To(Succeed())
+			Expect(p2pAdded).To(BeEmpty())
+			Expect(p2pDel).To(BeEmpty())
+		})
+	})
+
+	Context("when the IMC cannot be reached", func() {
+		It("should return the error and keep the known VFs", func() {
+			imcErr = fmt.Errorf("fake IMC error")
+			Expect(watcher.resync()).To(HaveOccurred())
+			Expect(watcher.vfMacList).To(HaveLen(2))
+		})
+	})
+
+	Context("when VFs are added and removed without an NF", func() {
+		It("should update the point-to-point rules and emit events", func() {
+			events := watcher.Subscribe()
+			imcVfs = []string{"00:12:00:00:03:14", "00:13:00:00:03:14", ""}
+			Expect(watcher.resync()).To(Succeed())
+
+			Expect(p2pDel).To(Equal([][]string{{"00:11:00:00:03:14"}}))
+			Expect(p2pAdded).To(Equal([][]string{{"00:13:00:00:03:14"}}))
+			Expect(nfAdded).To(BeEmpty())
+			Expect(watcher.vfMacList).To(Equal([]string{"00:12:00:00:03:14", "00:13:00:00:03:14"}))
+
+			Expect(<-events).To(Equal(VfEvent{Type: VfRemoved, Mac: "00:11:00:00:03:14"}))
+			Expect(<-events).To(Equal(VfEvent{Type: VfAdded, Mac: "00:13:00:00:03:14"}))
+		})
+	})
+
+	Context("when a VF is added while an NF is deployed", func() {
+		It("should steer the new VF to the NF", func() {
+			watcher.setNetworkFunction(watcher.vfMacList, "00:20:00:00:03:14", "00:21:00:00:03:14")
+			imcVfs = []string{"00:11:00:00:03:14", "00:12:00:00:03:14", "00:13:00:00:03:14"}
+			Expect(watcher.resync()).To(Succeed())
+
+			Expect(nfAdded).To(Equal([][]string{{"00:13:00:00:03:14"}}))
+			Expect(p2pAdded).To(BeEmpty())
+		})
+	})
+
+	Context("when the dpu-daemon watches the VF events", func() {
+		It("should stream the events of a resync", func() {
+			sockPath := filepath.Join(GinkgoT().TempDir(), "vfevents.sock")
+			lis, err := net.Listen("unix", sockPath)
+			Expect(err).NotTo(HaveOccurred())
+			grpcSrvr := grpc.NewServer()
+			registerVfEventService(grpcSrvr, watcher)
+			go grpcSrvr.Serve(lis)
+			DeferCleanup(grpcSrvr.Stop)
+
+			conn, err := grpc.NewClient("unix://"+sockPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(conn.Close)
+			ctx, cancel := context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			stream, err := WatchVfEvents(ctx, conn)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() int {
+				watcher.mu.Lock()
+				defer watcher.mu.Unlock()
+				return len(watcher.subscribers)
+			}).Should(Equal(1))
+
+			imcVfs = []string{"00:11:00:00:03:14", "00:12:00:00:03:14", "00:13:00:00:03:14"}
+			Expect(watcher.resync()).To(Succeed())
+			Expect(stream.Recv()).To(Equal(VfEvent{Type: VfAdded, Mac: "00:13:00:00:03:14"}))
+
+			cancel()
+			Eventually(func() int {
+				watcher.mu.Lock()
+				defer watcher.mu.Unlock()
+				return len(watcher.subscribers)
+			}).Should(BeZero())
+		})
+	})
+})
@@ -0,0 +1,177 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	minVlan = 2
+	maxVlan = 4094
+
+	// reasonVlansExhausted is the ErrorInfo reason of a BridgePort which could not be allocated a vlan
+	reasonVlansExhausted = "VLANS_EXHAUSTED"
+)
+
+// VlanConfig gives the vlans the BridgePorts and LogicalBridges may use
+type VlanConfig struct {
+	// Ranges are the vlan ranges, e.g.; "100-199", the BridgePorts may use, 2-4094 when empty. The vlan of a
+	// BridgePort created without one is allocated from them.
+	Ranges []string
+	// Reserved are the vlan ranges used by the infrastructure, which no BridgePort may use
+	Reserved []string
+}
+
+type vlanRange struct {
+	min, max int
+}
+
+func (r vlanRange) String() string {
+	if r.min == r.max {
+		return strconv.Itoa(r.min)
+	}
+	return fmt.Sprintf("%d-%d", r.min, r.max)
+}
+
+// vlanRanges are the parsed VlanConfig, the zero value allows 2-4094
+type vlanRanges struct {
+	allowed  []vlanRange
+	reserved []vlanRange
+}
+
+// parse checks the vlan ranges of the config
+func (c VlanConfig) parse() (vlanRanges, error) {
+	allowed, err := parseVlanRanges(c.Ranges)
+	if err != nil {
+		return vlanRanges{}, fmt.Errorf("invalid vlan ranges: %w", err)
+	}
+	reserved, err := parseVlanRanges(c.Reserved)
+	if err != nil {
+		return vlanRanges{}, fmt.Errorf("invalid reserved vlans: %w", err)
+	}
+	return vlanRanges{allowed: allowed, reserved: reserved}, nil
+}
+
+func parseVlanRanges(ranges []string) ([]vlanRange, error) {
+	var res []vlanRange
+	for _, r := range ranges {
+		min, max, err := parseRange(r)
+		if err != nil {
+			return nil, err
+		}
+		if min < minVlan || max > maxVlan {
+			return nil, fmt.Errorf("invalid range %q, vlans must be within %d-%d range", r, minVlan, maxVlan)
+		}
+		res = append(res, vlanRange{min: min, max: max})
+	}
+	return res, nil
+}
+
+// check returns an error when vlan is reserved or out of the allowed ranges
+func (r vlanRanges) check(vlan int) error {
+	for _, res := range r.reserved {
+		if vlan >= res.min && vlan <= res.max {
+			return fmt.Errorf("vlan %d is reserved", vlan)
+		}
+	}
+	if len(r.allowed) == 0 {
+		if vlan < minVlan || vlan > maxVlan {
+			return fmt.Errorf("invalid vlan %d, vlan must be within %d-%d range", vlan, minVlan, maxVlan)
+		}
+		return nil
+	}
+	for _, allowed := range r.allowed {
+		if vlan >= allowed.min && vlan <= allowed.max {
+			return nil
+		}
+	}
+	return fmt.Errorf("vlan %d is not in the vlan ranges %v", vlan, r.allowed)
+}
+
+// vlanAllocator allocates the vlans of the BridgePorts created without one
+type vlanAllocator struct {
+	vlanRanges
+	mu sync.Mutex
+	// pending are the vlans allocated to the BridgePorts being created, by s-tag
+	pending map[int][]int
+}
+
+// allocate returns the lowest vlan allowed on stag which inUse does not report. The vlan is not allocated again
+// until release is called, by when the BridgePort either has it or failed to be created.
+func (a *vlanAllocator) allocate(stag int, inUse func(vlan int) bool) (int, func(), error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	allowed := a.allowed
+	if len(allowed) == 0 {
+		allowed = []vlanRange{{min: minVlan, max: maxVlan}}
+	}
+	for _, r := range allowed {
+		for vlan := r.min; vlan <= r.max; vlan++ {
+			if a.check(vlan) != nil || slices.Contains(a.pending[stag], vlan) || inUse(vlan) {
+				continue
+			}
+			if a.pending == nil {
+				a.pending = make(map[int][]int)
+			}
+			a.pending[stag] = append(a.pending[stag], vlan)
+			return vlan, func() { a.release(stag, vlan) }, nil
+		}
+	}
+	return 0, nil, newStatus(codes.ResourceExhausted, fmt.Sprintf("no vlan left to allocate on s-tag %d", stag),
+		&errdetails.ErrorInfo{Reason: reasonVlansExhausted, Domain: errorDomain})
+}
+
+func (a *vlanAllocator) release(stag, vlan int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending[stag] = slices.DeleteFunc(a.pending[stag], func(v int) bool { return v == vlan })
+	if len(a.pending[stag]) == 0 {
+		delete(a.pending, stag)
+	}
+}
+
+// vlanUsed tells whether a LogicalBridge or a BridgePort on stag has vlan
+func (s *server) vlanUsed(stag, vlan int) bool {
+	for _, bridge := range s.logicalBridges.all() {
+		if int(bridge.Spec.GetVlanId()) == vlan {
+			return true
+		}
+	}
+	return s.vlanInUse("", stag, vlan)
+}
+
+// hasVlans tells whether the logical bridges of a BridgePort give a vlan, otherwise one is allocated
+func hasVlans(bridges []string) bool {
+	return slices.ContainsFunc(bridges, func(bridge string) bool { return !strings.HasPrefix(bridge, stagPrefix) })
+}
+
+// withoutVlan returns spec without the logical bridge of vlan, i.e.; as requested before vlan was allocated to it
+func withoutVlan(spec *pb.BridgePortSpec, vlan int) *pb.BridgePortSpec {
+	requested := proto.Clone(spec).(*pb.BridgePortSpec)
+	requested.LogicalBridges = slices.DeleteFunc(requested.LogicalBridges, func(bridge string) bool {
+		return bridge == strconv.Itoa(vlan)
+	})
+	return requested
+}
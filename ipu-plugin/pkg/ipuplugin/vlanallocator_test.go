@@ -0,0 +1,175 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("vlan allocation", Serial, func() {
+	var ipuServer *server
+
+	BeforeEach(func() {
+		links := newFakeLinkStore("enp0s1f0d3", "br-test")
+		linkByNameFn = links.linkByName
+		linkAddFn = links.linkAdd
+		linkDelFn = links.linkDel
+		linkSetMasterFn = fakeLinkSetMaster
+		linkSetNoMasterFn = fakeLinkSetNoMaster
+		linkSetUpFn = fakeLinkSetUp
+		linkSetDownFn = fakeLinkSetDown
+		executeScriptFn = func(string) (string, error) { return "", nil }
+		DeferCleanup(func() {
+			linkDelFn = netlink.LinkDel
+			executeScriptFn = utils.ExecuteScript
+		})
+
+		ranges, err := VlanConfig{Ranges: []string{"100-103"}, Reserved: []string{"100"}}.parse()
+		Expect(err).NotTo(HaveOccurred())
+		ipuServer = &server{
+			uplinkInterface: "enp0s1f0d3",
+			bridgeCtlr:      NewLinuxBridgeController("br-test"),
+			p4RtClient:      &mockP4rtClient{},
+			vlanAlloc:       vlanAllocator{vlanRanges: ranges},
+			log:             log.WithField("pkg", "vlanallocator_test.go"),
+		}
+	})
+
+	createPort := func(name string, vsi byte, bridges ...string) (*pb.BridgePort, error) {
+		return ipuServer.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+			Name: name,
+			Spec: &pb.BridgePortSpec{
+				MacAddress:     []byte{0x00, vsi, 0x00, 0x00, 0x03, 0x14},
+				LogicalBridges: bridges,
+			},
+		}})
+	}
+
+	It("should allocate the lowest vlan which is neither reserved nor used", func() {
+		_, err := ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+			LogicalBridgeId: "blue",
+			LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: 101}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = createPort("port-a", 0x08, "102")
+		Expect(err).NotTo(HaveOccurred())
+
+		port, err := createPort("port-b", 0x09)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(port.Spec.LogicalBridges).To(Equal([]string{"103"}))
+		// A retried request returns the port with the vlan allocated to it
+		again, err := createPort("port-b", 0x09)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again.Spec.LogicalBridges).To(Equal([]string{"103"}))
+
+		_, err = createPort("port-c", 0x0a)
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(status.Convert(err).Details()).To(ContainElement(
+			HaveField("Reason", reasonVlansExhausted)))
+
+		// The vlan of a deleted port is allocated again
+		_, err = ipuServer.DeleteBridgePort(context.TODO(), &pb.DeleteBridgePortRequest{Name: "port-b"})
+		Expect(err).NotTo(HaveOccurred())
+		port, err = createPort("port-c", 0x0a)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(port.Spec.LogicalBridges).To(Equal([]string{"103"}))
+	})
+
+	It("should allocate the vlans per s-tag", func() {
+		first, err := createPort("port-a", 0x08, "stag=300")
+		Expect(err).NotTo(HaveOccurred())
+		second, err := createPort("port-b", 0x09, "stag=301")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Spec.LogicalBridges).To(Equal([]string{"stag=300", "101"}))
+		Expect(second.Spec.LogicalBridges).To(Equal([]string{"stag=301", "101"}))
+	})
+
+	It("should allocate distinct vlans to the ports created concurrently", func() {
+		var wg sync.WaitGroup
+		vlans := make([]string, 3)
+		for i := range vlans {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				port, err := createPort(fmt.Sprintf("port%d", i), byte(0x08+i))
+				Expect(err).NotTo(HaveOccurred())
+				vlans[i] = port.Spec.LogicalBridges[0]
+			}(i)
+		}
+		wg.Wait()
+		Expect(vlans).To(ConsistOf("101", "102", "103"))
+	})
+
+	It("should reject the vlans which are reserved or out of the ranges", func() {
+		for _, vlan := range []uint32{100, 104} {
+			_, err := createPort("port-a", 0x08, fmt.Sprint(vlan))
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument), "vlan %d", vlan)
+			_, err = ipuServer.CreateLogicalBridge(context.TODO(), &pb.CreateLogicalBridgeRequest{
+				LogicalBridgeId: "blue",
+				LogicalBridge:   &pb.LogicalBridge{Spec: &pb.LogicalBridgeSpec{VlanId: vlan}},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument), "vlan %d", vlan)
+		}
+	})
+
+	It("should keep the allocated vlans across a restart", func() {
+		stateFile := filepath.Join(GinkgoT().TempDir(), "bridgeports.json")
+		// The state is saved as soon as the port is created, the plugin may crash before shutting down
+		ipuServer.shutdown = ShutdownConfig{StateFile: stateFile}
+		_, err := createPort("port-a", 0x08)
+		Expect(err).NotTo(HaveOccurred())
+
+		restored := &server{log: ipuServer.log}
+		Expect(restored.restoreState(stateFile)).To(Succeed())
+		Expect(restored.autoVlans).To(Equal(map[string]int{"port-a": 101}))
+		_, err = restored.CreateBridgePort(context.TODO(), &pb.CreateBridgePortRequest{BridgePort: &pb.BridgePort{
+			Name: "port-a",
+			Spec: &pb.BridgePortSpec{MacAddress: []byte{0x00, 0x08, 0x00, 0x00, 0x03, 0x14}},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject invalid vlan ranges", func() {
+		for _, cfg := range []VlanConfig{
+			{Ranges: []string{"1-10"}},
+			{Ranges: []string{"100-4095"}},
+			{Ranges: []string{"200-100"}},
+			{Reserved: []string{"blue"}},
+		} {
+			_, err := cfg.parse()
+			Expect(err).To(HaveOccurred(), "config %+v", cfg)
+		}
+		ranges, err := VlanConfig{Ranges: []string{"100-199", "300"}, Reserved: []string{"150-159"}}.parse()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ranges.check(120)).To(Succeed())
+		Expect(ranges.check(300)).To(Succeed())
+		Expect(ranges.check(155)).To(MatchError("vlan 155 is reserved"))
+		Expect(ranges.check(200)).To(MatchError("vlan 200 is not in the vlan ranges [100-199 300]"))
+		Expect(vlanRanges{}.check(1)).To(HaveOccurred())
+	})
+})
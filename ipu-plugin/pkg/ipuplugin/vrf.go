@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// vrfPrefix is the prefix of the Vrf resource names, e.g.; "vrfs/blue"
+const vrfPrefix = "vrfs/"
+
+// CreateVrf registers a Vrf. Creating it again with the same spec returns the existing one.
+func (s *server) CreateVrf(ctx context.Context, in *pb.CreateVrfRequest) (*pb.Vrf, error) {
+	tracing.Logger(ctx).WithField("CreateVrfRequest", in).Info("CreateVrf")
+	if in.Vrf == nil {
+		return nil, invalidArgument(fieldViolation("vrf", "vrf is not provided"))
+	}
+	if err := validateResourceId("vrf_id", in.VrfId); err != nil {
+		return nil, err
+	}
+	name := vrfPrefix + in.VrfId
+
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	if vrf, ok := s.vrfs.get(name); ok {
+		if proto.Equal(vrf.Spec, in.Vrf.Spec) {
+			return vrf, nil
+		}
+		return nil, alreadyExists(vrfResource, name, fmt.Sprintf("vrf %s already exists with another spec", name))
+	}
+	vrf := &pb.Vrf{
+		Name:   name,
+		Spec:   in.Vrf.Spec,
+		Status: &pb.VrfStatus{OperStatus: pb.VRFOperStatus_VRF_OPER_STATUS_UP},
+	}
+	if err := s.validateVrf(vrf); err != nil {
+		return nil, err
+	}
+	s.vrfs.set(name, vrf)
+	s.persistState(ctx)
+	return proto.Clone(vrf).(*pb.Vrf), nil
+}
+
+// DeleteVrf removes a Vrf
+func (s *server) DeleteVrf(ctx context.Context, in *pb.DeleteVrfRequest) (*emptypb.Empty, error) {
+	tracing.Logger(ctx).WithField("DeleteVrfRequest", in).Info("DeleteVrf")
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	if _, ok := s.vrfs.get(in.Name); !ok {
+		if in.AllowMissing {
+			return &emptypb.Empty{}, nil
+		}
+		return nil, notFound(vrfResource, in.Name)
+	}
+	s.vrfs.delete(in.Name)
+	s.persistState(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+// UpdateVrf updates the spec fields of a Vrf given by the update mask, all of them when it is empty
+func (s *server) UpdateVrf(ctx context.Context, in *pb.UpdateVrfRequest) (*pb.Vrf, error) {
+	tracing.Logger(ctx).WithField("UpdateVrfRequest", in).Info("UpdateVrf")
+	if in.Vrf == nil {
+		return nil, invalidArgument(fieldViolation("vrf", "vrf is not provided"))
+	}
+	s.lbMu.Lock()
+	defer s.lbMu.Unlock()
+	vrf, exists := s.vrfs.get(in.Vrf.Name)
+	if !exists {
+		if !in.AllowMissing || !strings.HasPrefix(in.Vrf.Name, vrfPrefix) {
+			return nil, notFound(vrfResource, in.Vrf.Name)
+		}
+		if err := validateResourceId("vrf.name", strings.TrimPrefix(in.Vrf.Name, vrfPrefix)); err != nil {
+			return nil, err
+		}
+		vrf = &pb.Vrf{
+			Name:   in.Vrf.Name,
+			Status: &pb.VrfStatus{OperStatus: pb.VRFOperStatus_VRF_OPER_STATUS_UP},
+		}
+	}
+	if err := updateSpec(vrf, in.Vrf, in.UpdateMask.GetPaths()); err != nil {
+		return nil, err
+	}
+	if err := s.validateVrf(vrf); err != nil {
+		return nil, err
+	}
+	s.vrfs.set(vrf.Name, vrf)
+	s.persistState(ctx)
+	return proto.Clone(vrf).(*pb.Vrf), nil
+}
+
+// GetVrf returns a Vrf
+func (s *server) GetVrf(ctx context.Context, in *pb.GetVrfRequest) (*pb.Vrf, error) {
+	tracing.Logger(ctx).WithField("GetVrfRequest", in).Debug("GetVrf")
+	vrf, ok := s.vrfs.get(in.Name)
+	if !ok {
+		return nil, notFound(vrfResource, in.Name)
+	}
+	return vrf, nil
+}
+
+// ListVrfs returns the Vrfs in name order, page by page
+func (s *server) ListVrfs(ctx context.Context, in *pb.ListVrfsRequest) (*pb.ListVrfsResponse, error) {
+	tracing.Logger(ctx).WithField("ListVrfsRequest", in).Debug("ListVrfs")
+	if err := validatePageSize(in.PageSize); err != nil {
+		return nil, err
+	}
+	vrfs, next := s.vrfs.list(in.PageSize, in.PageToken)
+	return &pb.ListVrfsResponse{Vrfs: vrfs, NextPageToken: next}, nil
+}
+
+// validateVrf checks the spec of vrf, and that no other Vrf has the same VNI
+func (s *server) validateVrf(vrf *pb.Vrf) error {
+	spec := vrf.Spec
+	if spec == nil {
+		return invalidArgument(fieldViolation("vrf.spec", "vrf spec is not provided"))
+	}
+	if spec.Vni == nil {
+		return nil
+	}
+	if *spec.Vni < 1 || *spec.Vni > maxVni {
+		return invalidArgument(fieldViolation("vrf.spec.vni",
+			fmt.Sprintf("invalid vni %d, vni must be within 1-%d range", *spec.Vni, maxVni)))
+	}
+	for name, other := range s.vrfs.all() {
+		if name != vrf.Name && other.Spec.Vni != nil && *other.Spec.Vni == *spec.Vni {
+			return alreadyExists(vrfResource, name, fmt.Sprintf("vni %d is already used by vrf %s", *spec.Vni, name))
+		}
+	}
+	return nil
+}
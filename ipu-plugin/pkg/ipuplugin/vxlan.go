@@ -0,0 +1,190 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipuplugin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
+	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
+	pc "github.com/opiproject/opi-api/network/opinetcommon/v1alpha1/gen/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// vxlanPort is the IANA VXLAN UDP port
+const vxlanPort = 4789
+
+var (
+	// Abstract netlink address functions for unit tests
+	addrReplaceFn = netlink.AddrReplace
+	addrDelFn     = netlink.AddrDel
+)
+
+// getVxlanIntfName returns the name of the VXLAN device of vni
+func getVxlanIntfName(vni uint32) string {
+	return fmt.Sprintf("vxlan%d", vni)
+}
+
+// getVtepAddr returns the VTEP address of an IPPrefix, a host address when the prefix length is not set
+func getVtepAddr(prefix *pc.IPPrefix) (*netlink.Addr, error) {
+	var ip net.IP
+	switch prefix.GetAddr().GetAf() {
+	case pc.IpAf_IP_AF_INET:
+		ip = make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, prefix.Addr.GetV4Addr())
+	case pc.IpAf_IP_AF_INET6:
+		if ip = net.IP(prefix.Addr.GetV6Addr()); len(ip) != net.IPv6len {
+			return nil, fmt.Errorf("invalid IPv6 vtep address %v", prefix.Addr.GetV6Addr())
+		}
+	default:
+		return nil, fmt.Errorf("vtep address family is not provided")
+	}
+	bits := len(ip) * 8
+	ones := int(prefix.Len)
+	if ones == 0 {
+		ones = bits
+	}
+	if ones < 0 || ones > bits {
+		return nil, fmt.Errorf("invalid vtep prefix length %d", prefix.Len)
+	}
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return nil, fmt.Errorf("invalid vtep address %s", ip)
+	}
+	return &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, bits)}}, nil
+}
+
+// addVxlan realizes a logical bridge with a VNI: the VTEP address is set on the uplink, and a VXLAN device of
+// the VNI is added to the bridge as an access port of the logical bridge vlan, with its FXP rules. What was
+// done is undone on failure.
+func (s *server) addVxlan(ctx context.Context, bridge *pb.LogicalBridge) (err error) {
+	logger := tracing.Logger(ctx)
+	vni := bridge.Spec.GetVni()
+	vxlanIntfName := getVxlanIntfName(vni)
+	defer s.intfLocks.lock(vxlanIntfName)()
+
+	addr, err := getVtepAddr(bridge.Spec.VtepIpPrefix)
+	if err != nil {
+		return err
+	}
+	upLink, err := linkByNameFn(s.uplinkInterface)
+	if err != nil {
+		return fmt.Errorf("unable to find uplink interface: %s, because: %w", s.uplinkInterface, err)
+	}
+	if err := addrReplaceFn(upLink, addr); err != nil {
+		return fmt.Errorf("unable to set vtep address %s on %s: %w", addr.IPNet, s.uplinkInterface, err)
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, s.removeVxlanLocked(ctx, bridge))
+		}
+	}()
+
+	if link, err := linkByNameFn(vxlanIntfName); err == nil {
+		if _, ok := link.(*netlink.Vxlan); !ok {
+			return fmt.Errorf("an interface %s is found but not a VXLAN device", vxlanIntfName)
+		}
+		logger.Debugf("vxlan interface %s already exist", vxlanIntfName)
+	} else {
+		vxlan := &netlink.Vxlan{
+			VxlanId:      int(vni),
+			VtepDevIndex: upLink.Attrs().Index,
+			SrcAddr:      addr.IP,
+			Port:         vxlanPort,
+			// The remote MAC addresses are learned by the EVPN control plane, not from the data plane
+			Learning: false,
+		}
+		vxlan.Name = vxlanIntfName
+		if err := linkAddFn(vxlan); err != nil {
+			return fmt.Errorf("error creating vxlan interface %s: %w", vxlanIntfName, err)
+		}
+	}
+	if err := s.bridgeCtlr.AddPort(ctx, vxlanIntfName); err != nil {
+		return fmt.Errorf("failed to add vxlan interface to bridge: %w", err)
+	}
+	if s.vlanFiltering() {
+		if err := s.bridgeCtlr.SetPortVlan(ctx, vxlanIntfName, int(bridge.Spec.VlanId), false); err != nil {
+			return err
+		}
+	}
+	if p4Client, ok := s.p4RtClient.(types.VxlanP4RTClient); ok {
+		p4Client.AddVxlanRules(ctx, getVxlanRuleSpec(bridge, addr))
+	} else {
+		logger.Debug("VXLAN is not supported by the P4 package, the tunnel is not offloaded")
+	}
+	logger.WithFields(log.Fields{"vxlan": vxlanIntfName, "vtep": addr.IPNet, "vlan": bridge.Spec.VlanId}).
+		Info("vxlan added to bridge")
+	return nil
+}
+
+// removeVxlan removes the VXLAN device of a logical bridge from the bridge and the host, with its FXP rules.
+// The VTEP address is removed from the uplink once no other logical bridge uses it.
+func (s *server) removeVxlan(ctx context.Context, bridge *pb.LogicalBridge) error {
+	defer s.intfLocks.lock(getVxlanIntfName(bridge.Spec.GetVni()))()
+	return s.removeVxlanLocked(ctx, bridge)
+}
+
+func (s *server) removeVxlanLocked(ctx context.Context, bridge *pb.LogicalBridge) error {
+	vxlanIntfName := getVxlanIntfName(bridge.Spec.GetVni())
+	addr, err := getVtepAddr(bridge.Spec.VtepIpPrefix)
+	if err != nil {
+		return err
+	}
+	if p4Client, ok := s.p4RtClient.(types.VxlanP4RTClient); ok {
+		p4Client.DeleteVxlanRules(ctx, getVxlanRuleSpec(bridge, addr))
+	}
+	if _, err := linkByNameFn(vxlanIntfName); err == nil {
+		if err := s.bridgeCtlr.DeletePort(ctx, vxlanIntfName); err != nil {
+			return fmt.Errorf("failed to delete vxlan interface from bridge: %w", err)
+		}
+		if err := removeVlanInterface(ctx, vxlanIntfName); err != nil {
+			return err
+		}
+	}
+	if s.vtepInUse(bridge.Name, addr) {
+		return nil
+	}
+	upLink, err := linkByNameFn(s.uplinkInterface)
+	if err != nil {
+		return fmt.Errorf("unable to find uplink interface: %s, because: %w", s.uplinkInterface, err)
+	}
+	if err := addrDelFn(upLink, addr); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return fmt.Errorf("unable to remove vtep address %s from %s: %w", addr.IPNet, s.uplinkInterface, err)
+	}
+	return nil
+}
+
+// vtepInUse returns whether a logical bridge other than name has a VXLAN with the VTEP address addr
+func (s *server) vtepInUse(name string, addr *netlink.Addr) bool {
+	for other, bridge := range s.logicalBridges.all() {
+		if other == name || bridge.Spec.Vni == nil {
+			continue
+		}
+		if otherAddr, err := getVtepAddr(bridge.Spec.VtepIpPrefix); err == nil && otherAddr.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func getVxlanRuleSpec(bridge *pb.LogicalBridge, addr *netlink.Addr) types.VxlanRuleSpec {
+	return types.VxlanRuleSpec{Vni: int(bridge.Spec.GetVni()), Vlan: int(bridge.Spec.VlanId), VtepIp: addr.IP}
+}
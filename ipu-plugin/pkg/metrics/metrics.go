@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus metrics of the plugin, served by Handler
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "ipuplugin"
+
+var (
+	// Registry holds all the plugin metrics, apart from the ones of the default client_golang registry
+	Registry = prometheus.NewRegistry()
+	factory  = promauto.With(Registry)
+
+	GrpcRequests = factory.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "grpc_requests_total",
+		Help: "Number of gRPC requests handled, by method and status code."}, []string{"method", "code"})
+	GrpcRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace,
+		Name: "grpc_request_duration_seconds", Help: "Duration of the gRPC requests, by method.",
+		Buckets: prometheus.DefBuckets}, []string{"method"})
+
+	P4rtCtlCommands = factory.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "p4rt_ctl_commands_total",
+		Help: "Number of p4rt-ctl invocations, by operation and P4 table."}, []string{"op", "table"})
+	P4rtCtlFailures = factory.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "p4rt_ctl_failures_total",
+		Help: "Number of failed p4rt-ctl invocations, by operation and P4 table."}, []string{"op", "table"})
+
+	BridgePorts = factory.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: "bridge_ports",
+		Help: "Number of active BridgePorts."})
+	NetworkFunctions = factory.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: "network_functions",
+		Help: "Number of deployed network functions."})
+
+	ImcQueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace,
+		Name: "imc_query_duration_seconds", Help: "Duration of the queries to the IMC, by query.",
+		Buckets: prometheus.DefBuckets}, []string{"query"})
+	ImcQueryFailures = factory.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "imc_query_failures_total",
+		Help: "Number of failed queries to the IMC, by query."}, []string{"query"})
+
+	Devices = factory.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: "devices",
+		Help: "Number of devices returned by the last GetDevices, by health."}, []string{"health"})
+)
+
+// Handler serves the metrics of Registry in the Prometheus exposition formats
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// UnaryServerInterceptor counts the gRPC requests and measures their duration
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		GrpcRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		GrpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		return resp, err
+	}
+}
+
+// ObserveP4rtCtl records the outcome of a p4rt-ctl invocation. params are the p4rt-ctl arguments,
+// e.g.; ["add-entry", "br0", "<table>", "<match,action>"].
+func ObserveP4rtCtl(params []string, err error) {
+	op, table := "unknown", "unknown"
+	if len(params) > 0 {
+		op = params[0]
+	}
+	if len(params) > 2 {
+		table = params[2]
+	}
+	P4rtCtlCommands.WithLabelValues(op, table).Inc()
+	if err != nil {
+		P4rtCtlFailures.WithLabelValues(op, table).Inc()
+	}
+}
+
+// ObserveImcQuery records the duration and outcome of an IMC query started at start
+func ObserveImcQuery(query string, start time.Time, err error) {
+	ImcQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ImcQueryFailures.WithLabelValues(query).Inc()
+	}
+}
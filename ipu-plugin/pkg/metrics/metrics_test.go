@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("UnaryServerInterceptor", func() {
+	It("should count the requests by method and code", func() {
+		method := "/test.Service/Fail"
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		handler := func(context.Context, interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		_, err := UnaryServerInterceptor()(context.Background(), nil, info, handler)
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		Expect(testutil.ToFloat64(GrpcRequests.WithLabelValues(method, codes.NotFound.String()))).To(Equal(1.0))
+		Expect(testutil.CollectAndCount(GrpcRequestDuration, "ipuplugin_grpc_request_duration_seconds")).
+			To(BeNumerically(">=", 1))
+	})
+})
+
+var _ = Describe("ObserveP4rtCtl", func() {
+	It("should count the failures by operation and table", func() {
+		ObserveP4rtCtl([]string{"del-entry", "br0", "test_table", "match"}, status.Error(codes.Unknown, "failed"))
+		Expect(testutil.ToFloat64(P4rtCtlCommands.WithLabelValues("del-entry", "test_table"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(P4rtCtlFailures.WithLabelValues("del-entry", "test_table"))).To(Equal(1.0))
+	})
+})
+
+var _ = Describe("ObserveImcQuery", func() {
+	It("should count the failed queries", func() {
+		ObserveImcQuery("test_query", time.Now(), fmt.Errorf("failed"))
+		Expect(testutil.ToFloat64(ImcQueryFailures.WithLabelValues("test_query"))).To(Equal(1.0))
+	})
+})
+
+var _ = Describe("Handler", func() {
+	It("should serve the metrics in the text format", func() {
+		BridgePorts.Set(2)
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(rec.Body.String()).To(ContainSubstring("# TYPE ipuplugin_bridge_ports gauge\nipuplugin_bridge_ports 2\n"))
+	})
+})
@@ -0,0 +1,222 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ovsdb is a minimal OVSDB management protocol (RFC 7047) client, enough to manage the bridges and
+// ports of the Open_vSwitch database over its JSON-RPC socket.
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrClosed is returned for the requests pending or sent after the connection is closed
+var ErrClosed = errors.New("ovsdb connection closed")
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     interface{}     `json:"id"`
+}
+
+// message is any JSON-RPC message received from the server, a request has a method
+type message struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     interface{}     `json:"id"`
+}
+
+// Client is a JSON-RPC connection to an OVSDB server. It is safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	log  *log.Entry
+
+	mu      sync.Mutex // guards enc, nextID, pending and err
+	enc     *json.Encoder
+	nextID  uint64
+	pending map[uint64]chan *message
+	err     error
+	done    chan struct{}
+}
+
+// Dial connects to the OVSDB server listening on addr, e.g.; unix and /var/run/openvswitch/db.sock
+func Dial(ctx context.Context, network, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ovsdb server %s: %w", addr, err)
+	}
+	c := &Client{
+		conn:    conn,
+		log:     log.WithField("pkg", "ovsdb"),
+		enc:     json.NewEncoder(conn),
+		pending: map[uint64]chan *message{},
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close closes the connection, the pending requests fail with ErrClosed
+func (c *Client) Close() error {
+	c.closeWithError(ErrClosed)
+	return nil
+}
+
+// Closed returns whether the connection is closed, in which case a new Client has to be dialed
+func (c *Client) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) closeWithError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	_ = c.conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+func (c *Client) readLoop() {
+	dec := json.NewDecoder(c.conn)
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			c.closeWithError(fmt.Errorf("%w: %v", ErrClosed, err))
+			return
+		}
+		if msg.Method == "echo" {
+			// Keepalive from the server, the params are sent back as the result
+			c.reply(msg.ID, msg.Params)
+			continue
+		}
+		if msg.Method != "" {
+			c.log.WithField("method", msg.Method).Debug("ignoring ovsdb notification")
+			continue
+		}
+		id, ok := msg.ID.(float64)
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[uint64(id)]
+		delete(c.pending, uint64(id))
+		c.mu.Unlock()
+		if ok {
+			ch <- &msg
+		}
+	}
+}
+
+func (c *Client) reply(id interface{}, result json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	_ = c.enc.Encode(response{Result: result, ID: id})
+}
+
+// call sends method and waits for its result, or until ctx is done
+func (c *Client) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = ch
+	if err := c.enc.Encode(request{Method: method, Params: params, ID: id}); err != nil {
+		delete(c.pending, id)
+		c.mu.Unlock()
+		err = fmt.Errorf("%w: %v", ErrClosed, err)
+		c.closeWithError(err)
+		return err
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	case msg, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("ovsdb %s failed: %v", method, msg.Error)
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// Transact executes ops atomically on database db. An *OperationError is returned when one of the operations
+// fails, in which case none of them is committed.
+func (c *Client) Transact(ctx context.Context, db string, ops ...Operation) ([]OperationResult, error) {
+	params := make([]interface{}, 0, len(ops)+1)
+	params = append(params, db)
+	for _, op := range ops {
+		params = append(params, op)
+	}
+	var results []OperationResult
+	if err := c.call(ctx, "transact", params, &results); err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		if res.Error == "" {
+			continue
+		}
+		opErr := &OperationError{Reason: res.Error, Details: res.Details}
+		// An extra result is returned when the commit itself fails
+		if i < len(ops) {
+			opErr.Op = ops[i]["op"].(string)
+		}
+		return nil, opErr
+	}
+	if len(results) < len(ops) {
+		return nil, fmt.Errorf("ovsdb transact returned %d results for %d operations", len(results), len(ops))
+	}
+	return results, nil
+}
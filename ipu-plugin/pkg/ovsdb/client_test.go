@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/ovsdb"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/ovsdb/ovsdbtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ovsdb.Client", func() {
+	var (
+		srv    *ovsdbtest.Server
+		client *ovsdb.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = ovsdbtest.NewServer(filepath.Join(GinkgoT().TempDir(), "db.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(srv.Close)
+		client, err = ovsdb.Dial(context.Background(), "unix", srv.Path)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(client.Close)
+	})
+
+	It("should insert and select rows in a transaction", func() {
+		res, err := client.Transact(context.Background(), "Open_vSwitch",
+			ovsdb.Insert("Interface", ovsdb.Row{"name": "p0"}, "iface"),
+			ovsdb.Insert("Port", ovsdb.Row{"name": "p0", "interfaces": ovsdb.NamedUUID("iface"),
+				"external_ids": ovsdb.Map(map[string]string{"b": "2", "a": "1"})}, "port"),
+			ovsdb.Insert("Bridge", ovsdb.Row{"name": "br0", "ports": ovsdb.Set(ovsdb.NamedUUID("port"))}, ""),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(HaveLen(3))
+
+		res, err = client.Transact(context.Background(), "Open_vSwitch",
+			ovsdb.Select("Port", []ovsdb.Condition{ovsdb.Equal("name", "p0")}, "_uuid", "external_ids"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res[0].Rows).To(HaveLen(1))
+		uuid, err := ovsdb.ParseUUID(res[0].Rows[0]["_uuid"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uuid).To(Equal(srv.Rows("Port")[0]["_uuid"].([]interface{})[1]))
+		ids, err := ovsdb.ParseMap(res[0].Rows[0]["external_ids"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(Equal(map[string]string{"a": "1", "b": "2"}))
+	})
+
+	It("should return the failed operation and commit none of them", func() {
+		_, err := client.Transact(context.Background(), "Open_vSwitch",
+			ovsdb.Insert("Bridge", ovsdb.Row{"name": "br0"}, ""),
+			ovsdb.WaitNone("Bridge", []ovsdb.Condition{ovsdb.Equal("name", "br0")}),
+		)
+		var opErr *ovsdb.OperationError
+		Expect(errors.As(err, &opErr)).To(BeTrue())
+		Expect(opErr.Op).To(Equal("wait"))
+		Expect(opErr.Reason).To(Equal("timed out"))
+		Expect(srv.Rows("Bridge")).To(BeEmpty())
+	})
+
+	It("should reply to the echo requests of the server", func() {
+		// Make sure the server accepted the connection first
+		_, err := client.Transact(context.Background(), "Open_vSwitch", ovsdb.Select("Bridge", nil))
+		Expect(err).NotTo(HaveOccurred())
+		srv.SendEcho()
+		Eventually(srv.EchoReplies).Should(Equal(1))
+	})
+
+	It("should fail the requests once the connection is closed", func() {
+		srv.Close()
+		Eventually(client.Closed).Should(BeTrue())
+		_, err := client.Transact(context.Background(), "Open_vSwitch", ovsdb.Select("Bridge", nil))
+		Expect(errors.Is(err, ovsdb.ErrClosed)).To(BeTrue())
+	})
+})
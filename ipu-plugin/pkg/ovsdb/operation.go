@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Operation is a transact operation, built with Select, Insert, Update, Mutate, Delete or Wait
+type Operation map[string]interface{}
+
+// Condition is a [column, function, value] where clause
+type Condition []interface{}
+
+// Mutation is a [column, mutator, value] mutation
+type Mutation []interface{}
+
+// Row is a table row, keyed by column name
+type Row map[string]interface{}
+
+// Equal is the condition matching the rows whose column is value
+func Equal(column string, value interface{}) Condition {
+	return Condition{column, "==", value}
+}
+
+// UUID is the value referencing the row uuid
+func UUID(uuid string) []interface{} {
+	return []interface{}{"uuid", uuid}
+}
+
+// NamedUUID is the value referencing the row inserted with uuid-name name in the same transaction
+func NamedUUID(name string) []interface{} {
+	return []interface{}{"named-uuid", name}
+}
+
+// Set is the set value of elems
+func Set(elems ...interface{}) []interface{} {
+	if elems == nil {
+		elems = []interface{}{}
+	}
+	return []interface{}{"set", elems}
+}
+
+// Map is the map value of m, its pairs are sorted by key
+func Map(m map[string]string) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]interface{}, 0, len(m))
+	for _, k := range keys {
+		pairs = append(pairs, []interface{}{k, m[k]})
+	}
+	return []interface{}{"map", pairs}
+}
+
+// Select returns the columns of the rows of table matching where, all the columns when none is given
+func Select(table string, where []Condition, columns ...string) Operation {
+	op := Operation{"op": "select", "table": table, "where": conditions(where)}
+	if len(columns) > 0 {
+		op["columns"] = columns
+	}
+	return op
+}
+
+// Insert inserts row in table, the row can be referenced as NamedUUID(uuidName) by the next operations
+func Insert(table string, row Row, uuidName string) Operation {
+	op := Operation{"op": "insert", "table": table, "row": row}
+	if uuidName != "" {
+		op["uuid-name"] = uuidName
+	}
+	return op
+}
+
+// Update sets the columns of row on the rows of table matching where
+func Update(table string, where []Condition, row Row) Operation {
+	return Operation{"op": "update", "table": table, "where": conditions(where), "row": row}
+}
+
+// Mutate applies mutations to the rows of table matching where
+func Mutate(table string, where []Condition, mutations ...Mutation) Operation {
+	return Operation{"op": "mutate", "table": table, "where": conditions(where), "mutations": mutations}
+}
+
+// Delete deletes the rows of table matching where
+func Delete(table string, where []Condition) Operation {
+	return Operation{"op": "delete", "table": table, "where": conditions(where)}
+}
+
+// WaitNone fails the transaction when a row of table matches where, e.g.; to insert a row unless a concurrent
+// client already did
+func WaitNone(table string, where []Condition) Operation {
+	return Operation{"op": "wait", "table": table, "where": conditions(where), "columns": []string{"_uuid"},
+		"until": "==", "rows": []Row{}, "timeout": 0}
+}
+
+func conditions(where []Condition) []Condition {
+	if where == nil {
+		// An empty where matches all the rows, it has to be present
+		return []Condition{}
+	}
+	return where
+}
+
+// OperationResult is the result of an operation, depending on its kind
+type OperationResult struct {
+	UUID    []interface{}                `json:"uuid,omitempty"`
+	Count   int                          `json:"count,omitempty"`
+	Rows    []map[string]json.RawMessage `json:"rows,omitempty"`
+	Error   string                       `json:"error,omitempty"`
+	Details string                       `json:"details,omitempty"`
+}
+
+// OperationError is the failure of an operation of a transaction
+type OperationError struct {
+	Op string
+	// Reason is the RFC 7047 error, e.g.; "constraint violation" or "timed out"
+	Reason  string
+	Details string
+}
+
+func (e *OperationError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("ovsdb transaction failed: %s: %s", e.Reason, e.Details)
+	}
+	return fmt.Sprintf("ovsdb %s operation failed: %s: %s", e.Op, e.Reason, e.Details)
+}
+
+// ParseUUID parses a ["uuid", <uuid>] value
+func ParseUUID(raw json.RawMessage) (string, error) {
+	var value []string
+	if err := json.Unmarshal(raw, &value); err != nil || len(value) != 2 || value[0] != "uuid" {
+		return "", fmt.Errorf("invalid uuid %s", raw)
+	}
+	return value[1], nil
+}
+
+// ParseMap parses a ["map", [[key, value], ...]] value of strings
+func ParseMap(raw json.RawMessage) (map[string]string, error) {
+	var value []json.RawMessage
+	var kind string
+	if err := json.Unmarshal(raw, &value); err != nil || len(value) != 2 || json.Unmarshal(value[0], &kind) != nil ||
+		kind != "map" {
+		return nil, fmt.Errorf("invalid map %s", raw)
+	}
+	var pairs [][2]string
+	if err := json.Unmarshal(value[1], &pairs); err != nil {
+		return nil, fmt.Errorf("invalid map %s: %w", raw, err)
+	}
+	m := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		m[p[0]] = p[1]
+	}
+	return m, nil
+}
+
+// parseSet returns the elements of a set value, a set of one element can also be encoded as the element itself
+func parseSet(raw json.RawMessage) ([]json.RawMessage, error) {
+	var value []json.RawMessage
+	if err := json.Unmarshal(raw, &value); err != nil {
+		// an atom
+		return []json.RawMessage{raw}, nil
+	}
+	var kind string
+	if len(value) != 2 || json.Unmarshal(value[0], &kind) != nil {
+		return nil, fmt.Errorf("invalid set %s", raw)
+	}
+	if kind != "set" {
+		// an atom such as ["uuid", <uuid>]
+		return []json.RawMessage{raw}, nil
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(value[1], &elems); err != nil {
+		return nil, fmt.Errorf("invalid set %s: %w", raw, err)
+	}
+	return elems, nil
+}
+
+// ParseUUIDSet parses a set of uuids
+func ParseUUIDSet(raw json.RawMessage) ([]string, error) {
+	elems, err := parseSet(raw)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(elems))
+	for _, e := range elems {
+		uuid, err := ParseUUID(e)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+	return uuids, nil
+}
+
+// ParseIntSet parses a set of integers, e.g.; an optional integer column is a set of zero or one integer
+func ParseIntSet(raw json.RawMessage) ([]int, error) {
+	elems, err := parseSet(raw)
+	if err != nil {
+		return nil, err
+	}
+	ints := make([]int, 0, len(elems))
+	for _, e := range elems {
+		var i int
+		if err := json.Unmarshal(e, &i); err != nil {
+			return nil, fmt.Errorf("invalid integer %s", e)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// ParseStringSet parses a set of strings, e.g.; an optional string column is a set of zero or one string
+func ParseStringSet(raw json.RawMessage) ([]string, error) {
+	elems, err := parseSet(raw)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(elems))
+	for _, e := range elems {
+		var s string
+		if err := json.Unmarshal(e, &s); err != nil {
+			return nil, fmt.Errorf("invalid string %s", e)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
@@ -0,0 +1,13 @@
+package ovsdb_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestOvsdb(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ovsdb Suite")
+}
@@ -0,0 +1,530 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ovsdbtest provides an in-memory stand-in of ovsdb-server for tests. It serves the Open_vSwitch,
+// Bridge, Port and Interface tables of the Open_vSwitch database with the subset of RFC 7047 used by the
+// bridge controllers: transact with select, insert, update, mutate, delete and wait operations, and echo.
+package ovsdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const dbName = "Open_vSwitch"
+
+type table map[string]map[string]interface{}
+
+// references are the strong reference columns of the schema, the rows of the non-root tables are garbage
+// collected once no longer referenced
+var references = map[string]struct{ column, target string }{
+	"Open_vSwitch": {"bridges", "Bridge"},
+	"Bridge":       {"ports", "Port"},
+	"Port":         {"interfaces", "Interface"},
+}
+
+var rootTables = map[string]bool{"Open_vSwitch": true, "Bridge": true}
+
+// Server is an in-memory OVSDB server listening on a unix socket
+type Server struct {
+	Path string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu           sync.Mutex
+	tables       map[string]table
+	nextUUID     int
+	transactions int
+	echoReplies  int
+	conns        map[net.Conn]*json.Encoder
+}
+
+// NewServer starts a server on the unix socket path, with a single Open_vSwitch row and no bridge
+func NewServer(path string) (*Server, error) {
+	_ = os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Path:     path,
+		listener: l,
+		tables:   map[string]table{"Open_vSwitch": {}, "Bridge": {}, "Port": {}, "Interface": {}},
+		conns:    map[net.Conn]*json.Encoder{},
+	}
+	s.tables["Open_vSwitch"][s.newUUID()] = map[string]interface{}{"bridges": set()}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Rows returns a copy of the rows of table, with their "_uuid" column
+func (s *Server) Rows(tableName string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []map[string]interface{}
+	for _, uuid := range sortedKeys(s.tables[tableName]) {
+		row := copyValue(s.tables[tableName][uuid]).(map[string]interface{})
+		row["_uuid"] = []interface{}{"uuid", uuid}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Transactions returns the number of transactions received
+func (s *Server) Transactions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions
+}
+
+// SendEcho sends an echo request to the connected clients
+func (s *Server) SendEcho() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, enc := range s.conns {
+		_ = enc.Encode(map[string]interface{}{"method": "echo", "params": []interface{}{}, "id": "echo"})
+	}
+}
+
+// EchoReplies returns the number of replies received to SendEcho
+func (s *Server) EchoReplies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.echoReplies
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = json.NewEncoder(conn)
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	dec := json.NewDecoder(conn)
+	for {
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+			ID     interface{}   `json:"id"`
+		}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		s.mu.Lock()
+		resp := map[string]interface{}{"id": req.ID, "error": nil, "result": nil}
+		switch req.Method {
+		case "":
+			// reply to SendEcho
+			s.echoReplies++
+			s.mu.Unlock()
+			continue
+		case "echo":
+			resp["result"] = req.Params
+		case "list_dbs":
+			resp["result"] = []string{dbName}
+		case "transact":
+			s.transactions++
+			resp["result"] = s.transact(req.Params)
+		default:
+			resp["error"] = "unknown method"
+		}
+		_ = s.conns[conn].Encode(resp)
+		s.mu.Unlock()
+	}
+}
+
+// transact executes the operations on a copy of the tables, which replaces them when all succeed
+func (s *Server) transact(params []interface{}) []interface{} {
+	if len(params) == 0 || params[0] != dbName {
+		return []interface{}{opError("unknown database", fmt.Sprint(params))}
+	}
+	ops := params[1:]
+	results := make([]interface{}, len(ops))
+	tables := copyValue(s.tables).(map[string]table)
+	named := map[string]string{}
+	for i, op := range ops {
+		res, err := s.execute(tables, named, op.(map[string]interface{}))
+		if err != nil {
+			results[i] = err
+			return results
+		}
+		results[i] = res
+	}
+	garbageCollect(tables)
+	if err := checkUnique(tables); err != nil {
+		return append(results, err)
+	}
+	s.tables = tables
+	return results
+}
+
+func (s *Server) execute(tables map[string]table, named map[string]string, op map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	tableName, _ := op["table"].(string)
+	t, ok := tables[tableName]
+	if !ok {
+		return nil, opError("unknown table", tableName)
+	}
+	op = resolveNamed(op, named).(map[string]interface{})
+	where, _ := op["where"].([]interface{})
+	switch op["op"] {
+	case "select":
+		var rows []interface{}
+		for _, uuid := range matching(t, where) {
+			rows = append(rows, selectColumns(uuid, t[uuid], op["columns"]))
+		}
+		if rows == nil {
+			rows = []interface{}{}
+		}
+		return map[string]interface{}{"rows": rows}, nil
+	case "insert":
+		uuid := s.newUUID()
+		row := map[string]interface{}{}
+		for k, v := range op["row"].(map[string]interface{}) {
+			row[k] = v
+		}
+		for _, column := range []string{"ports", "interfaces", "bridges"} {
+			if _, ok := row[column]; !ok && references[tableName].column == column {
+				row[column] = set()
+			}
+		}
+		if _, ok := row["external_ids"]; !ok {
+			row["external_ids"] = []interface{}{"map", []interface{}{}}
+		}
+		t[uuid] = row
+		if name, ok := op["uuid-name"].(string); ok {
+			named[name] = uuid
+		}
+		return map[string]interface{}{"uuid": []interface{}{"uuid", uuid}}, nil
+	case "update":
+		uuids := matching(t, where)
+		for _, uuid := range uuids {
+			for k, v := range op["row"].(map[string]interface{}) {
+				t[uuid][k] = v
+			}
+		}
+		return map[string]interface{}{"count": len(uuids)}, nil
+	case "mutate":
+		uuids := matching(t, where)
+		for _, uuid := range uuids {
+			for _, m := range op["mutations"].([]interface{}) {
+				mutation := m.([]interface{})
+				column, mutator := mutation[0].(string), mutation[1].(string)
+				value, err := mutate(t[uuid][column], mutator, mutation[2])
+				if err != nil {
+					return nil, err
+				}
+				t[uuid][column] = value
+			}
+		}
+		return map[string]interface{}{"count": len(uuids)}, nil
+	case "delete":
+		uuids := matching(t, where)
+		for _, uuid := range uuids {
+			delete(t, uuid)
+		}
+		return map[string]interface{}{"count": len(uuids)}, nil
+	case "wait":
+		rows, _ := op["rows"].([]interface{})
+		if op["until"] != "==" || len(rows) != 0 {
+			return nil, opError("not supported", "only waiting for no matching row is supported")
+		}
+		if len(matching(t, where)) > 0 {
+			return nil, opError("timed out", "")
+		}
+		return map[string]interface{}{}, nil
+	}
+	return nil, opError("unknown operation", fmt.Sprint(op["op"]))
+}
+
+func (s *Server) newUUID() string {
+	s.nextUUID++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextUUID)
+}
+
+func opError(reason, details string) map[string]interface{} {
+	return map[string]interface{}{"error": reason, "details": details}
+}
+
+func set(elems ...interface{}) []interface{} {
+	if elems == nil {
+		elems = []interface{}{}
+	}
+	return []interface{}{"set", elems}
+}
+
+// elems returns the elements of a set or map value, an atom is a set of one element
+func elems(value interface{}) []interface{} {
+	if v, ok := value.([]interface{}); ok && len(v) == 2 && (v[0] == "set" || v[0] == "map") {
+		return v[1].([]interface{})
+	}
+	if value == nil {
+		return nil
+	}
+	return []interface{}{value}
+}
+
+func isMap(value interface{}) bool {
+	v, ok := value.([]interface{})
+	return ok && len(v) == 2 && v[0] == "map"
+}
+
+func contains(list []interface{}, elem interface{}) bool {
+	for _, e := range list {
+		if reflect.DeepEqual(e, elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func mutate(current interface{}, mutator string, value interface{}) (interface{}, map[string]interface{}) {
+	cur := elems(current)
+	switch {
+	case isMap(current) && mutator == "insert":
+		res := append([]interface{}{}, cur...)
+		for _, pair := range elems(value) {
+			if !hasKey(res, pair.([]interface{})[0]) {
+				res = append(res, pair)
+			}
+		}
+		return []interface{}{"map", res}, nil
+	case isMap(current) && mutator == "delete":
+		var res []interface{}
+		for _, pair := range cur {
+			// value is either a map, whose matching pairs are removed, or a set of keys
+			if contains(elems(value), pair) || (!isMap(value) && contains(elems(value), pair.([]interface{})[0])) {
+				continue
+			}
+			res = append(res, pair)
+		}
+		if res == nil {
+			res = []interface{}{}
+		}
+		return []interface{}{"map", res}, nil
+	case mutator == "insert":
+		res := append([]interface{}{}, cur...)
+		for _, e := range elems(value) {
+			if !contains(res, e) {
+				res = append(res, e)
+			}
+		}
+		return set(res...), nil
+	case mutator == "delete":
+		var res []interface{}
+		for _, e := range cur {
+			if !contains(elems(value), e) {
+				res = append(res, e)
+			}
+		}
+		return set(res...), nil
+	}
+	return nil, opError("not supported", "mutator "+mutator)
+}
+
+func hasKey(pairs []interface{}, key interface{}) bool {
+	for _, pair := range pairs {
+		if reflect.DeepEqual(pair.([]interface{})[0], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matching returns the uuids of the rows matching all the == and != conditions of where
+func matching(t table, where []interface{}) []string {
+	var uuids []string
+	for _, uuid := range sortedKeys(t) {
+		match := true
+		for _, c := range where {
+			cond := c.([]interface{})
+			column, function, value := cond[0].(string), cond[1], cond[2]
+			current := t[uuid][column]
+			if column == "_uuid" {
+				current = []interface{}{"uuid", uuid}
+			}
+			equal := reflect.DeepEqual(current, value) ||
+				(!isMap(current) && reflect.DeepEqual(elems(current), elems(value)))
+			if (function == "==") != equal {
+				match = false
+				break
+			}
+		}
+		if match {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func selectColumns(uuid string, row map[string]interface{}, columns interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	if columns == nil {
+		for k, v := range row {
+			res[k] = v
+		}
+		res["_uuid"] = []interface{}{"uuid", uuid}
+		return res
+	}
+	for _, c := range columns.([]interface{}) {
+		column := c.(string)
+		if column == "_uuid" {
+			res[column] = []interface{}{"uuid", uuid}
+		} else if value, ok := row[column]; ok {
+			res[column] = value
+		} else {
+			// The optional columns not set are empty sets
+			res[column] = set()
+		}
+	}
+	return res
+}
+
+// resolveNamed replaces the named-uuid references by the uuids of the rows inserted in the transaction
+func resolveNamed(value interface{}, named map[string]string) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 2 && v[0] == "named-uuid" {
+			return []interface{}{"uuid", named[v[1].(string)]}
+		}
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = resolveNamed(e, named)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			// Keep the uuid-name of the insert operation
+			if k == "uuid-name" {
+				res[k] = e
+				continue
+			}
+			res[k] = resolveNamed(e, named)
+		}
+		return res
+	}
+	return value
+}
+
+// garbageCollect deletes the rows of the non-root tables which are no longer referenced
+func garbageCollect(tables map[string]table) {
+	for _, tableName := range []string{"Port", "Interface"} {
+		referenced := map[string]bool{}
+		for source, ref := range references {
+			if ref.target != tableName {
+				continue
+			}
+			for _, row := range tables[source] {
+				for _, e := range elems(row[ref.column]) {
+					if uuid, ok := e.([]interface{}); ok && len(uuid) == 2 {
+						referenced[uuid[1].(string)] = true
+					}
+				}
+			}
+		}
+		for uuid := range tables[tableName] {
+			if !rootTables[tableName] && !referenced[uuid] {
+				delete(tables[tableName], uuid)
+			}
+		}
+	}
+}
+
+// checkUnique enforces the name index of the Bridge, Port and Interface tables
+func checkUnique(tables map[string]table) map[string]interface{} {
+	for _, tableName := range []string{"Bridge", "Port", "Interface"} {
+		names := map[interface{}]bool{}
+		for _, row := range tables[tableName] {
+			if names[row["name"]] {
+				return opError("constraint violation", fmt.Sprintf("duplicate %s name %v", tableName, row["name"]))
+			}
+			names[row["name"]] = true
+		}
+	}
+	return nil
+}
+
+func sortedKeys(t table) []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyValue deep copies a value decoded from JSON, or the tables
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]table:
+		res := make(map[string]table, len(v))
+		for name, t := range v {
+			res[name] = copyValue(t).(table)
+		}
+		return res
+	case table:
+		res := make(table, len(v))
+		for uuid, row := range v {
+			res[uuid] = copyValue(row).(map[string]interface{})
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[k] = copyValue(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = copyValue(e)
+		}
+		return res
+	}
+	return value
+}
@@ -15,8 +15,11 @@
 package p4rtclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	log "github.com/sirupsen/logrus"
@@ -41,46 +44,139 @@ func NewP4RtClient(p4RtBin string, portMuxVsi int, p4BridgeName string, brType t
 	}
 }
 
-func (p *p4rtclient) AddRules(macAddr []byte, vlan int) {
+func (p *p4rtclient) AddRules(ctx context.Context, spec types.FxpRuleSpec) {
 	// For all rules  in RuleSets call
 	// P4CP_INSTALL/bin/p4rt-ctl add-entry br0
 
-	ruleSets := p.getAddRuleSets(macAddr, vlan)
-	log.WithField("number of rules", len(ruleSets)).Debug("adding FXP rules")
+	ruleSets := p.getAddRuleSets(spec.MacAddr, spec.Vsi, spec.Vlan)
+	logger := tracing.Logger(ctx)
+	if spec.Stag != 0 {
+		// The linux_networking tables only push and pop a single vlan tag
+		logger.WithField("stag", spec.Stag).Warn("s-tag is not supported by the linux P4 package, it is not pushed by the FXP rules")
+	}
+	logger.WithField("number of rules", len(ruleSets)).Debug("adding FXP rules")
 
 	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p.p4RtBin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing add rule command")
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing add rule command")
 		}
 	}
-	log.Info("FXP rules were added")
+	logger.Info("FXP rules were added")
 }
 
-func (p *p4rtclient) DeleteRules(macAddr []byte, vlan int) {
+func (p *p4rtclient) DeleteRules(ctx context.Context, spec types.FxpRuleSpec) {
 	// For all rules  in RuleSets call
 	// P4CP_INSTALL/bin/p4rt-ctl del-entry br0
 
-	ruleSets := p.getDelRuleSets(macAddr, vlan)
-	log.WithField("number of rules", len(ruleSets)).Debug("deleting FXP rules")
+	ruleSets := p.getDelRuleSets(spec.MacAddr, spec.Vsi, spec.Vlan)
+	logger := tracing.Logger(ctx)
+	logger.WithField("number of rules", len(ruleSets)).Debug("deleting FXP rules")
 
 	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p.p4RtBin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing del rule command")
 		}
 	}
-	log.Info("FXP rules were deleted")
+	logger.Info("FXP rules were deleted")
 }
 
-func (p *p4rtclient) getAddRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
+// vxlanPort is the IANA VXLAN UDP port
+const vxlanPort = 4789
+
+// AddVxlanRules adds the rules encapsulating the frames of the logical bridge vlan into its VXLAN tunnel, and
+// terminating the tunnel on the local VTEP
+func (p *p4rtclient) AddVxlanRules(ctx context.Context, spec types.VxlanRuleSpec) {
+	logger := tracing.Logger(ctx).WithFields(log.Fields{"vni": spec.Vni, "vtep": spec.VtepIp})
+	vtep := spec.VtepIp.To4()
+	if vtep == nil {
+		// The linux_networking tunnel tables only match IPv4 outer headers
+		logger.Warn("IPv6 VTEP is not supported by the linux P4 package, the VXLAN tunnel is not offloaded")
+		return
+	}
+	ruleSets := []fxpRuleParams{
+		// Frames of the vlan sent to the tunnel get the VXLAN header of the VNI with the VTEP as source
+		[]string{"add-entry", p.p4br, "linux_networking_control.vxlan_encap_mod_table", fmt.Sprintf("vmeta.common.mod_blob_ptr=%d,action=linux_networking_control.vxlan_encap(%s,%d,%d)", spec.Vlan, vtep, vxlanPort, spec.Vni)},
+		// Packets of the VNI to the VTEP are decapsulated and forwarded on the vlan
+		[]string{"add-entry", p.p4br, "linux_networking_control.ipv4_tunnel_term_table", fmt.Sprintf("tunnel_type=0,ipv4_dst=%s,vni=%d,action=linux_networking_control.decap_outer_ipv4(%d)", vtep, spec.Vni, spec.Vlan)},
+	}
+	for _, r := range ruleSets {
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing add rule command")
+		}
+	}
+	logger.Info("VXLAN FXP rules were added")
+}
 
-	macAddrSize := len(macAddr)
-	if macAddrSize < 1 || macAddrSize > 6 {
-		// We do not have a valid mac address
-		log.WithField("mac address", macAddr).Error("Invalid mac address")
-		return []fxpRuleParams{}
+// DeleteVxlanRules deletes the rules added by AddVxlanRules
+func (p *p4rtclient) DeleteVxlanRules(ctx context.Context, spec types.VxlanRuleSpec) {
+	logger := tracing.Logger(ctx).WithFields(log.Fields{"vni": spec.Vni, "vtep": spec.VtepIp})
+	vtep := spec.VtepIp.To4()
+	if vtep == nil {
+		return
 	}
-	vfVsi := int(macAddr[1])
+	ruleSets := []fxpRuleParams{
+		[]string{"del-entry", p.p4br, "linux_networking_control.vxlan_encap_mod_table", fmt.Sprintf("vmeta.common.mod_blob_ptr=%d", spec.Vlan)},
+		[]string{"del-entry", p.p4br, "linux_networking_control.ipv4_tunnel_term_table", fmt.Sprintf("tunnel_type=0,ipv4_dst=%s,vni=%d", vtep, spec.Vni)},
+	}
+	for _, r := range ruleSets {
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing del rule command")
+		}
+	}
+	logger.Info("VXLAN FXP rules were deleted")
+}
 
+// AddQosRules adds the rules metering the frames sent by the VF: the committed rate is the guaranteed rate and the
+// peak rate the rate above which frames are dropped, both in kbps. The frames are tagged with the priority. The
+// errors of the rules which could not be added are returned.
+func (p *p4rtclient) AddQosRules(ctx context.Context, spec types.QosRuleSpec) error {
+	logger := tracing.Logger(ctx).WithFields(log.Fields{"vsi": spec.Vsi, "maxTxRate": spec.MaxTxRate,
+		"minTxRate": spec.MinTxRate, "priority": spec.Priority})
+	var ruleSets []fxpRuleParams
+	if spec.MaxTxRate != 0 || spec.MinTxRate != 0 {
+		// The meter of the VF is indexed by its VSI, a peak rate of 0 does not limit the VF
+		ruleSets = append(ruleSets, []string{"add-entry", p.p4br, "linux_networking_control.tx_meter_table", fmt.Sprintf("vmeta.common.vsi=%d,action=linux_networking_control.set_tx_meter(%d,%d,%d)", spec.Vsi, spec.Vsi, spec.MinTxRate*1000, spec.MaxTxRate*1000)})
+	}
+	if spec.Priority != 0 {
+		ruleSets = append(ruleSets, []string{"add-entry", p.p4br, "linux_networking_control.tx_priority_table", fmt.Sprintf("vmeta.common.vsi=%d,action=linux_networking_control.set_pcp(%d)", spec.Vsi, spec.Priority)})
+	}
+	var errs []error
+	for _, r := range ruleSets {
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing add rule command")
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	logger.Info("QoS FXP rules were added")
+	return nil
+}
+
+// DeleteQosRules deletes the rules added by AddQosRules, the errors of the rules which could not be deleted are
+// returned
+func (p *p4rtclient) DeleteQosRules(ctx context.Context, spec types.QosRuleSpec) error {
+	logger := tracing.Logger(ctx).WithField("vsi", spec.Vsi)
+	ruleSets := []fxpRuleParams{
+		[]string{"del-entry", p.p4br, "linux_networking_control.tx_meter_table", fmt.Sprintf("vmeta.common.vsi=%d", spec.Vsi)},
+		[]string{"del-entry", p.p4br, "linux_networking_control.tx_priority_table", fmt.Sprintf("vmeta.common.vsi=%d", spec.Vsi)},
+	}
+	var errs []error
+	for _, r := range ruleSets {
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing del rule command")
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	logger.Info("QoS FXP rules were deleted")
+	return nil
+}
+
+func (p *p4rtclient) getAddRuleSets(macAddr []byte, vfVsi, vlan int) []fxpRuleParams {
 	vfVport := utils.GetVportForVsi(vfVsi)
 	portMuxVport := utils.GetVportForVsi(p.portMuxVsi)
 
@@ -96,6 +192,8 @@ func (p *p4rtclient) getAddRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
 		[]string{"add-entry", p.p4br, "linux_networking_control.handle_rx_loopback_from_ovs_to_host_table", fmt.Sprintf("vmeta.misc_internal.vm_to_vm_or_port_to_port[27:17]=%d,user_meta.cmeta.bit32_zeros=0,action=linux_networking_control.set_dest(%d)", vfVsi, vfVport)},
 		[]string{"add-entry", p.p4br, "linux_networking_control.vlan_pop_mod_table", fmt.Sprintf("vmeta.common.mod_blob_ptr=%d,action=linux_networking_control.vlan_pop", vlan)},
 	}
+	// The MAC based forwarding to the VF bypasses the bridge, which would let the frames of any vlan reach the VF
+	// on a vlan_filtering bridge. There, the frames are only sent to the VF by the vlan rules above.
 	if p.bridgeType == types.LinuxBridge {
 		// Add additional add rules
 		macToIntValue := utils.GetMacIntValueFromBytes(macAddr)
@@ -108,16 +206,7 @@ func (p *p4rtclient) getAddRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
 	return ruleSets
 }
 
-func (p *p4rtclient) getDelRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
-
-	macAddrSize := len(macAddr)
-	if macAddrSize < 1 || macAddrSize > 6 {
-		// We do not have a valid mac address
-		log.WithField("mac address", macAddr).Error("Invalid mac address")
-		return []fxpRuleParams{}
-	}
-	vfVsi := int(macAddr[1])
-
+func (p *p4rtclient) getDelRuleSets(macAddr []byte, vfVsi, vlan int) []fxpRuleParams {
 	ruleSets := []fxpRuleParams{
 		// Rules for control packets coming from overlay VF (vfVsi), IPU will add a VLAN tag (vlan) and send to PortMux Vport (portMuxVport)
 		[]string{"del-entry", p.p4br, "linux_networking_control.handle_tx_from_host_to_ovs_and_ovs_to_wire_table", fmt.Sprintf("vmeta.common.vsi=%d,user_meta.cmeta.bit32_zeros=0", vfVsi)},
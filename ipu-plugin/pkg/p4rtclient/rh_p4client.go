@@ -15,16 +15,17 @@
 package p4rtclient
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	stag          = 0 // Using single flat L2 network with vlan 0
 	portMuxModPtr = 1
 )
 
@@ -45,46 +46,39 @@ func NewRHP4Client(p4RtBin string, portMuxVsi int, p4BridgeName string, brType t
 	}
 }
 
-func (p *rhP4Client) AddRules(macAddr []byte, vlan int) {
+func (p *rhP4Client) AddRules(ctx context.Context, spec types.FxpRuleSpec) {
 	// For all rules  in RuleSets call
 	// P4CP_INSTALL/bin/p4rt-ctl add-entry br0
 
-	ruleSets := p.getAddRuleSets(macAddr, vlan)
-	log.WithField("number of rules", len(ruleSets)).Debug("adding FXP rules")
+	ruleSets := p.getAddRuleSets(spec.MacAddr, spec.Vsi, spec.Vlan, spec.Stag)
+	logger := tracing.Logger(ctx)
+	logger.WithField("number of rules", len(ruleSets)).Debug("adding FXP rules")
 
 	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p.p4RtBin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing add rule command")
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing add rule command")
 		}
 	}
-	log.Info("FXP rules were added")
+	logger.Info("FXP rules were added")
 }
 
-func (p *rhP4Client) DeleteRules(macAddr []byte, vlan int) {
+func (p *rhP4Client) DeleteRules(ctx context.Context, spec types.FxpRuleSpec) {
 	// For all rules  in RuleSets call
 	// P4CP_INSTALL/bin/p4rt-ctl del-entry br0
 
-	ruleSets := p.getDelRuleSets(macAddr, vlan)
-	log.WithField("number of rules", len(ruleSets)).Debug("deleting FXP rules")
+	ruleSets := p.getDelRuleSets(spec.MacAddr, spec.Vsi, spec.Vlan)
+	logger := tracing.Logger(ctx)
+	logger.WithField("number of rules", len(ruleSets)).Debug("deleting FXP rules")
 
 	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p.p4RtBin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
+		if err := utils.RunP4rtCtlCommandContext(ctx, p.p4RtBin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing del rule command")
 		}
 	}
-	log.Info("FXP rules were delete")
+	logger.Info("FXP rules were delete")
 }
 
-func (p *rhP4Client) getAddRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
-
-	macAddrSize := len(macAddr)
-	if macAddrSize < 1 || macAddrSize > 6 {
-		// We do not have a valid mac address
-		log.WithField("mac address", macAddr).Error("Invalid mac address")
-		return []fxpRuleParams{}
-	}
-	vfVsi := int(macAddr[1])
-
+func (p *rhP4Client) getAddRuleSets(macAddr []byte, vfVsi, vlan, stag int) []fxpRuleParams {
 	vfVport := utils.GetVportForVsi(vfVsi)
 	portMuxVport := utils.GetVportForVsi(p.portMuxVsi)
 
@@ -110,16 +104,7 @@ func (p *rhP4Client) getAddRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
 	return ruleSets
 }
 
-func (p *rhP4Client) getDelRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
-
-	macAddrSize := len(macAddr)
-	if macAddrSize < 1 || macAddrSize > 6 {
-		// We do not have a valid mac address
-		log.WithField("mac address", macAddr).Error("Invalid mac address")
-		return []fxpRuleParams{}
-	}
-	vfVsi := int(macAddr[1])
-
+func (p *rhP4Client) getDelRuleSets(macAddr []byte, vfVsi, vlan int) []fxpRuleParams {
 	ruleSets := []fxpRuleParams{
 		// $P4CP_INSTALL/bin/p4rt-ctl del-entry br0 rh_mvp_control.vport_arp_egress_table "vsi=0x15,bit32_zeros=0x0000"
 		[]string{"del-entry", p.p4br, "rh_mvp_control.vport_arp_egress_table", fmt.Sprintf("vsi=%d,bit32_zeros=0x0000", vfVsi)},
@@ -136,7 +121,8 @@ func (p *rhP4Client) getDelRuleSets(macAddr []byte, vlan int) []fxpRuleParams {
 	return ruleSets
 }
 
-func CreateNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string, apf2 string) {
+func CreateNetworkFunctionRules(ctx context.Context, p4rtbin string, vfMacList []string, apf1 string, apf2 string) {
+	logger := tracing.Logger(ctx)
 
 	ruleSets := []fxpRuleParams{}
 
@@ -144,7 +130,7 @@ func CreateNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 		vfMac, err := utils.GetMacAsByteArray(vfMacList[i])
 		if err != nil {
-			fmt.Printf("unable to extract octets from %s: %v", vfMacList[i], err)
+			logger.Errorf("unable to extract octets from %s: %v", vfMacList[i], err)
 			return
 		}
 
@@ -152,7 +138,7 @@ func CreateNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 		apf1Mac, err := utils.GetMacAsByteArray(apf1)
 		if err != nil {
-			fmt.Printf("unable to extract octets from apf %s: %v", apf1, err)
+			logger.Errorf("unable to extract octets from apf %s: %v", apf1, err)
 			return
 		}
 
@@ -170,7 +156,7 @@ func CreateNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 	apf2Mac, err := utils.GetMacAsByteArray(apf2)
 	if err != nil {
-		fmt.Printf("unable to extract octets from apf %s: %v", apf2, err)
+		logger.Errorf("unable to extract octets from apf %s: %v", apf2, err)
 		return
 	}
 
@@ -181,16 +167,11 @@ func CreateNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 			fmt.Sprintf("vsi=0x%X,target_vsi=0x%X,action=rh_mvp_control.fwd_to_port(%d)", apf2Mac[1], apf2Mac[1], apf2Mac[1]+16)},
 	)
 
-	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p4rtbin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
-		} else {
-			log.Infof("Finished running: %s", p4rtbin+" "+strings.Join(r, " "))
-		}
-	}
+	runRuleSets(ctx, p4rtbin, ruleSets)
 }
 
-func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string, apf2 string) {
+func DeleteNetworkFunctionRules(ctx context.Context, p4rtbin string, vfMacList []string, apf1 string, apf2 string) {
+	logger := tracing.Logger(ctx)
 
 	ruleSets := []fxpRuleParams{}
 
@@ -198,7 +179,7 @@ func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 		vfMac, err := utils.GetMacAsByteArray(vfMacList[i])
 		if err != nil {
-			fmt.Printf("unable to extract octets from %s: %v", vfMacList[i], err)
+			logger.Errorf("unable to extract octets from %s: %v", vfMacList[i], err)
 			return
 		}
 
@@ -206,7 +187,7 @@ func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 		apf1Mac, err := utils.GetMacAsByteArray(apf1)
 		if err != nil {
-			fmt.Printf("unable to extract octets from apf %s: %v", apf1, err)
+			logger.Errorf("unable to extract octets from apf %s: %v", apf1, err)
 			return
 		}
 
@@ -224,7 +205,7 @@ func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 
 	apf2Mac, err := utils.GetMacAsByteArray(apf2)
 	if err != nil {
-		fmt.Printf("unable to extract octets from apf %s: %v", apf2, err)
+		logger.Errorf("unable to extract octets from apf %s: %v", apf2, err)
 		return
 	}
 
@@ -235,13 +216,7 @@ func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 			fmt.Sprintf("vsi=0x%X,target_vsi=0x%X", apf2Mac[1], apf2Mac[1])},
 	)
 
-	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p4rtbin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
-		} else {
-			log.Infof("Finished running: %s", p4rtbin+" "+strings.Join(r, " "))
-		}
-	}
+	runRuleSets(ctx, p4rtbin, ruleSets)
 }
 
 /*
@@ -253,7 +228,8 @@ func DeleteNetworkFunctionRules(p4rtbin string, vfMacList []string, apf1 string,
 * Function CreatePointToPointVFRules will create all the point to point rules between all the initilised VFs on the host.
 * Function DeletePointToPointVFRules will remove all the point to point rules between all the initilised VFs on the host.
  */
-func CreatePointToPointVFRules(p4rtbin string, vfMacList []string) {
+func CreatePointToPointVFRules(ctx context.Context, p4rtbin string, vfMacList []string) {
+	logger := tracing.Logger(ctx)
 
 	ruleSets := []fxpRuleParams{}
 
@@ -263,13 +239,13 @@ func CreatePointToPointVFRules(p4rtbin string, vfMacList []string) {
 
 				srcVfMac, err := utils.GetMacAsByteArray(vfMacList[i])
 				if err != nil {
-					fmt.Printf("unable to extract octets from %s: %v", vfMacList[i], err)
+					logger.Errorf("unable to extract octets from %s: %v", vfMacList[i], err)
 					return
 				}
 
 				dstVfMac, err := utils.GetMacAsByteArray(vfMacList[j])
 				if err != nil {
-					fmt.Printf("unable to extract octets from %s: %v", vfMacList[j], err)
+					logger.Errorf("unable to extract octets from %s: %v", vfMacList[j], err)
 					return
 				}
 
@@ -285,16 +261,11 @@ func CreatePointToPointVFRules(p4rtbin string, vfMacList []string) {
 		}
 	}
 
-	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p4rtbin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
-		} else {
-			log.Infof("Finished running: %s", p4rtbin+" "+strings.Join(r, " "))
-		}
-	}
+	runRuleSets(ctx, p4rtbin, ruleSets)
 }
 
-func DeletePointToPointVFRules(p4rtbin string, vfMacList []string) {
+func DeletePointToPointVFRules(ctx context.Context, p4rtbin string, vfMacList []string) {
+	logger := tracing.Logger(ctx)
 
 	ruleSets := []fxpRuleParams{}
 
@@ -304,13 +275,13 @@ func DeletePointToPointVFRules(p4rtbin string, vfMacList []string) {
 
 				srcVfMac, err := utils.GetMacAsByteArray(vfMacList[i])
 				if err != nil {
-					fmt.Printf("unable to extract octets from %s: %v", vfMacList[i], err)
+					logger.Errorf("unable to extract octets from %s: %v", vfMacList[i], err)
 					return
 				}
 
 				dstVfMac, err := utils.GetMacAsByteArray(vfMacList[j])
 				if err != nil {
-					fmt.Printf("unable to extract octets from %s: %v", vfMacList[j], err)
+					logger.Errorf("unable to extract octets from %s: %v", vfMacList[j], err)
 					return
 				}
 
@@ -326,11 +297,143 @@ func DeletePointToPointVFRules(p4rtbin string, vfMacList []string) {
 		}
 	}
 
+	runRuleSets(ctx, p4rtbin, ruleSets)
+}
+
+/*
+* The functions below are used by the VF watcher to incrementally update the FXP when VFs are
+* hot-plugged on the host, without removing and re-adding the rules of the VFs that did not change.
+*
+* Function AddVFsToPointToPointRules will create the point to point rules between each VF in vfMacs and all
+* the VFs in vfMacList (vfMacList is expected to already contain vfMacs).
+* Function RemoveVFsFromPointToPointRules will remove the point to point rules between each VF in vfMacs and all
+* the VFs in vfMacList (vfMacList is expected to still contain vfMacs).
+* Function AddVFsToNetworkFunctionRules and RemoveVFsFromNetworkFunctionRules do the same for the rules between
+* the VFs and the NF input port apf1, when an NF is deployed.
+ */
+func AddVFsToPointToPointRules(p4rtbin string, vfMacList []string, vfMacs []string) {
+	runRuleSets(context.Background(), p4rtbin, getPointToPointRuleSets("add-entry", vfMacList, vfMacs))
+}
+
+func RemoveVFsFromPointToPointRules(p4rtbin string, vfMacList []string, vfMacs []string) {
+	runRuleSets(context.Background(), p4rtbin, getPointToPointRuleSets("del-entry", vfMacList, vfMacs))
+}
+
+func AddVFsToNetworkFunctionRules(p4rtbin string, vfMacs []string, apf1 string) {
+	runRuleSets(context.Background(), p4rtbin, getNetworkFunctionVFRuleSets("add-entry", vfMacs, apf1))
+}
+
+func RemoveVFsFromNetworkFunctionRules(p4rtbin string, vfMacs []string, apf1 string) {
+	runRuleSets(context.Background(), p4rtbin, getNetworkFunctionVFRuleSets("del-entry", vfMacs, apf1))
+}
+
+// getPointToPointRuleSets returns the rules between every pair of VFs in vfMacList where at least one side is in vfMacs.
+func getPointToPointRuleSets(op string, vfMacList []string, vfMacs []string) []fxpRuleParams {
+
+	ruleSets := []fxpRuleParams{}
+
+	changed := make(map[string]bool, len(vfMacs))
+	for _, mac := range vfMacs {
+		changed[mac] = true
+	}
+
+	for i := range vfMacList {
+		for j := range vfMacList {
+			if i == j || (!changed[vfMacList[i]] && !changed[vfMacList[j]]) {
+				continue
+			}
+
+			srcVfMac, err := utils.GetMacAsByteArray(vfMacList[i])
+			if err != nil {
+				log.Errorf("unable to extract octets from %s: %v", vfMacList[i], err)
+				return []fxpRuleParams{}
+			}
+
+			dstVfMac, err := utils.GetMacAsByteArray(vfMacList[j])
+			if err != nil {
+				log.Errorf("unable to extract octets from %s: %v", vfMacList[j], err)
+				return []fxpRuleParams{}
+			}
+
+			dmac := strings.Replace(vfMacList[j], string(':'), "", -1)
+
+			if op == "add-entry" {
+				ruleSets = append(ruleSets,
+					[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+						fmt.Sprintf("vsi=0x%X,target_vsi=0x%X,action=rh_mvp_control.fwd_to_port(%d)", srcVfMac[1], dstVfMac[1], dstVfMac[1]+16)},
+					[]string{op, "br0", "rh_mvp_control.vport_egress_dmac_vsi_table",
+						fmt.Sprintf("vsi=0x%X,dmac=0x%s,action=rh_mvp_control.fwd_to_port(%d)", srcVfMac[1], dmac, dstVfMac[1]+16)},
+				)
+			} else {
+				ruleSets = append(ruleSets,
+					[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+						fmt.Sprintf("vsi=0x%X,target_vsi=0x%X", srcVfMac[1], dstVfMac[1])},
+					[]string{op, "br0", "rh_mvp_control.vport_egress_dmac_vsi_table",
+						fmt.Sprintf("vsi=0x%X,dmac=0x%s", srcVfMac[1], dmac)},
+				)
+			}
+		}
+	}
+
+	return ruleSets
+}
+
+// getNetworkFunctionVFRuleSets returns the rules steering the VFs in vfMacs to and from the NF input port apf1.
+func getNetworkFunctionVFRuleSets(op string, vfMacs []string, apf1 string) []fxpRuleParams {
+
+	ruleSets := []fxpRuleParams{}
+
+	apf1Mac, err := utils.GetMacAsByteArray(apf1)
+	if err != nil {
+		log.Errorf("unable to extract octets from apf %s: %v", apf1, err)
+		return ruleSets
+	}
+
+	for i := range vfMacs {
+
+		vfMac, err := utils.GetMacAsByteArray(vfMacs[i])
+		if err != nil {
+			log.Errorf("unable to extract octets from %s: %v", vfMacs[i], err)
+			return []fxpRuleParams{}
+		}
+
+		vfDmac := strings.Replace(vfMacs[i], string(':'), "", -1)
+
+		if op == "add-entry" {
+			ruleSets = append(ruleSets,
+				[]string{op, "br0", "rh_mvp_control.vport_egress_vsi_table",
+					fmt.Sprintf("vsi=0x%X,action=rh_mvp_control.fwd_to_port(%d)", vfMac[1], apf1Mac[1]+16)},
+				[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+					fmt.Sprintf("vsi=0x%X,target_vsi=0x%X,action=rh_mvp_control.fwd_to_port(%d)", vfMac[1], apf1Mac[1], apf1Mac[1]+16)},
+				[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+					fmt.Sprintf("vsi=0x%X,target_vsi=0x%X,action=rh_mvp_control.fwd_to_port(%d)", apf1Mac[1], vfMac[1], vfMac[1]+16)},
+				[]string{op, "br0", "rh_mvp_control.vport_egress_dmac_vsi_table",
+					fmt.Sprintf("vsi=0x%X,dmac=0x%s,action=rh_mvp_control.fwd_to_port(%d)", apf1Mac[1], vfDmac, vfMac[1]+16)},
+			)
+		} else {
+			ruleSets = append(ruleSets,
+				[]string{op, "br0", "rh_mvp_control.vport_egress_vsi_table",
+					fmt.Sprintf("vsi=0x%X", vfMac[1])},
+				[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+					fmt.Sprintf("vsi=0x%X,target_vsi=0x%X", vfMac[1], apf1Mac[1])},
+				[]string{op, "br0", "rh_mvp_control.ingress_loopback_table",
+					fmt.Sprintf("vsi=0x%X,target_vsi=0x%X", apf1Mac[1], vfMac[1])},
+				[]string{op, "br0", "rh_mvp_control.vport_egress_dmac_vsi_table",
+					fmt.Sprintf("vsi=0x%X,dmac=0x%s", apf1Mac[1], vfDmac)},
+			)
+		}
+	}
+
+	return ruleSets
+}
+
+func runRuleSets(ctx context.Context, p4rtbin string, ruleSets []fxpRuleParams) {
+	logger := tracing.Logger(ctx)
 	for _, r := range ruleSets {
-		if err := utils.RunP4rtCtlCommand(p4rtbin, r...); err != nil {
-			log.WithField("error", err).Errorf("error executing del rule command")
+		if err := utils.RunP4rtCtlCommandContext(ctx, p4rtbin, r...); err != nil {
+			logger.WithField("error", err).Errorf("error executing %s rule command", r[0])
 		} else {
-			log.Infof("Finished running: %s", p4rtbin+" "+strings.Join(r, " "))
+			logger.Infof("Finished running: %s", p4rtbin+" "+strings.Join(r, " "))
 		}
 	}
 }
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing scopes a logger to each gRPC request, so that the netlink, bridge and p4rt-ctl activity of a
+// request can be correlated with it. The spans are recorded with OpenTelemetry, only when SetupOTLP was called.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RequestIDKey is the gRPC metadata key carrying the request ID, in the request and in the response header
+	RequestIDKey = "x-request-id"
+	// tracerName is the instrumentation scope of the spans started with StartSpan
+	tracerName = "github.com/intel/ipu-opi-plugins/ipu-plugin"
+)
+
+type ctxKey int
+
+const loggerKey ctxKey = iota
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the logger of the request ctx belongs to, or the standard logger outside of a request
+func Logger(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(loggerKey).(*log.Entry); ok {
+		return logger
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// StartSpan starts a span as a child of the span of ctx, if any, and returns a copy of ctx carrying it. The span
+// is not recorded unless SetupOTLP was called.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// EndSpan ends span with the outcome err
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// UnaryServerInterceptor scopes a logger to each request, tagged with the request ID sent by the client in the
+// x-request-id metadata, or a generated one. The request ID is returned in the response header. The logger also
+// carries the trace ID of the server span started by the otelgrpc stats handler, when it is recorded.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var reqID string
+		if values := md.Get(RequestIDKey); len(values) > 0 {
+			reqID = values[0]
+		}
+		if reqID == "" {
+			var id [8]byte
+			// crypto/rand.Read never returns an error on the supported platforms
+			_, _ = rand.Read(id[:])
+			reqID = hex.EncodeToString(id[:])
+		}
+		// Fails when not called from a gRPC server, e.g.; in tests
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, reqID))
+
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.String("request.id", reqID))
+		logger := log.WithFields(log.Fields{"requestId": reqID, "method": info.FullMethod})
+		if sc := span.SpanContext(); sc.IsValid() && sc.IsSampled() {
+			logger = logger.WithField("traceId", sc.TraceID().String())
+		}
+		return handler(WithLogger(ctx, logger), req)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2024 Intel Corporation.  All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License")
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// recordSpans sets the global TracerProvider to one recording the spans in memory until the end of the test
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	DeferCleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return exporter
+}
+
+var _ = Describe("UnaryServerInterceptor", Serial, func() {
+	var (
+		info      = &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		handlerFn func(ctx context.Context) error
+		reqCtx    context.Context
+	)
+
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		reqCtx = ctx
+		return nil, handlerFn(ctx)
+	}
+
+	BeforeEach(func() {
+		handlerFn = func(context.Context) error { return nil }
+	})
+
+	It("should tag the request logger with the request ID sent by the client", func() {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDKey, "cni-1234"))
+		_, err := UnaryServerInterceptor()(ctx, nil, info, handler)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(Logger(reqCtx).Data).To(HaveKeyWithValue("requestId", "cni-1234"))
+		Expect(Logger(reqCtx).Data).To(HaveKeyWithValue("method", info.FullMethod))
+	})
+
+	It("should generate a request ID when the client sends none", func() {
+		_, err := UnaryServerInterceptor()(context.Background(), nil, info, handler)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(Logger(reqCtx).Data["requestId"]).To(MatchRegexp("^[0-9a-f]{16}$"))
+	})
+
+	It("should not record spans until SetupOTLP is called", func() {
+		_, err := UnaryServerInterceptor()(context.Background(), nil, info, handler)
+		Expect(err).NotTo(HaveOccurred())
+		_, span := StartSpan(reqCtx, "child")
+		Expect(span.IsRecording()).To(BeFalse())
+		Expect(Logger(reqCtx).Data).NotTo(HaveKey("traceId"))
+	})
+
+	It("should tag the server span and the logger of the request with each other's IDs", func() {
+		exporter := recordSpans()
+		handlerFn = func(ctx context.Context) error {
+			_, child := StartSpan(ctx, "p4rt-ctl")
+			EndSpan(child, fmt.Errorf("failed"))
+			return nil
+		}
+		ctx, server := otel.Tracer("test").Start(context.Background(), info.FullMethod)
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDKey, "cni-1234"))
+		_, err := UnaryServerInterceptor()(ctx, nil, info, handler)
+		Expect(err).NotTo(HaveOccurred())
+		server.End()
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(2))
+		child, parent := spans[0], spans[1]
+		Expect(parent.Attributes).To(ContainElement(attribute.String("request.id", "cni-1234")))
+		Expect(child.Name).To(Equal("p4rt-ctl"))
+		Expect(child.Parent.SpanID()).To(Equal(parent.SpanContext.SpanID()))
+		Expect(child.Status.Code).To(Equal(codes.Error))
+		Expect(Logger(reqCtx).Data).To(HaveKeyWithValue("traceId", parent.SpanContext.TraceID().String()))
+	})
+})
+
+var _ = Describe("otelgrpc server handler", Serial, func() {
+	It("should continue the trace of the caller sending a traceparent", func() {
+		exporter := recordSpans()
+		sockPath := filepath.Join(GinkgoT().TempDir(), "test.sock")
+		lis, err := net.Listen("unix", sockPath)
+		Expect(err).NotTo(HaveOccurred())
+		srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			grpc.ChainUnaryInterceptor(UnaryServerInterceptor()))
+		healthpb.RegisterHealthServer(srv, health.NewServer())
+		go func() { _ = srv.Serve(lis) }()
+		DeferCleanup(srv.Stop)
+
+		conn, err := grpc.NewClient("unix://"+sockPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		ctx := metadata.AppendToOutgoingContext(context.Background(),
+			"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(exporter.GetSpans).Should(HaveLen(1))
+		span := exporter.GetSpans()[0]
+		Expect(span.Name).To(Equal("grpc.health.v1.Health/Check"))
+		Expect(span.SpanKind).To(Equal(trace.SpanKindServer))
+		Expect(span.SpanContext.TraceID().String()).To(Equal(traceID))
+		Expect(span.Parent.SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		Expect(span.Attributes).To(ContainElement(HaveField("Key", attribute.Key("request.id"))))
+	})
+})
+
+var _ = Describe("Logger", func() {
+	It("should return the standard logger outside of a request", func() {
+		Expect(Logger(context.Background()).Logger).To(Equal(log.StandardLogger()))
+	})
+})
+
+var _ = Describe("SetupOTLP", Serial, func() {
+	It("should reject an endpoint that is not an URL", func() {
+		_, err := SetupOTLP("collector:4318", "ipuplugin")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should export the pending spans to the collector on shutdown", func() {
+		var (
+			mu          sync.Mutex
+			path        string
+			contentType string
+		)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		}))
+		defer collector.Close()
+		DeferCleanup(func() {
+			otel.SetTracerProvider(noop.NewTracerProvider())
+			otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+		})
+
+		shutdown, err := SetupOTLP(collector.URL, "ipuplugin")
+		Expect(err).NotTo(HaveOccurred())
+		_, span := StartSpan(context.Background(), "test")
+		Expect(span.IsRecording()).To(BeTrue())
+		EndSpan(span, nil)
+		shutdown()
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(path).To(Equal(otlpTracesPath))
+		Expect(contentType).To(Equal("application/x-protobuf"))
+	})
+})
@@ -14,11 +14,19 @@
 
 package types
 
+import (
+	"context"
+	"net"
+)
+
 type BridgeType int
 
 const (
 	OvsBridge BridgeType = iota
 	LinuxBridge
+	// LinuxVlanBridge is a vlan_filtering linux bridge with a single trunk port carrying the VLANs of all the
+	// BridgePorts
+	LinuxVlanBridge
 	HostMode = "host"
 	IpuMode  = "ipu"
 )
@@ -29,27 +37,117 @@ func (b BridgeType) String() string {
 		return "ovs"
 	case LinuxBridge:
 		return "linux"
+	case LinuxVlanBridge:
+		return "linux-vlan"
 	}
 	return "unknown"
 }
 
+// Runnable runs until ctx is done or Stop is called
 type Runnable interface {
-	Run() error
+	Run(ctx context.Context) error
 	Stop()
 }
 
+// Reloader is implemented by the Runnables which can reload their configuration while running
+type Reloader interface {
+	Reload() error
+}
+
 // BridgeController is an interface to interact with bridge provider to add remove host interface to it.
 type BridgeController interface {
 	// EnsureBridgeExists checks for the bridge that the controller is going to manage. It will attempt to
 	// create one if it doesn't exist.
 	EnsureBridgeExists() error
 	// AddPort will add host interface "portName" to the bridge that this BridgeController is managing
-	AddPort(portName string) error
+	AddPort(ctx context.Context, portName string) error
 	// DeletePort will remove a port "portName" from the bridge that this BridgeController is managing
-	DeletePort(portName string) error
+	DeletePort(ctx context.Context, portName string) error
+	// ListPorts returns the names of the ports of the bridge
+	ListPorts(ctx context.Context) ([]string, error)
+	// HasPort returns whether "portName" is a port of the bridge
+	HasPort(ctx context.Context, portName string) (bool, error)
+	// SetPortVlan makes port "portName" a member of VLAN vid, tagged for a trunk port or untagged as its
+	// native VLAN for an access port
+	SetPortVlan(ctx context.Context, portName string, vid int, tagged bool) error
+	// RemovePortVlan removes port "portName" from VLAN vid, whether it is a tagged or its native VLAN
+	RemovePortVlan(ctx context.Context, portName string, vid int) error
+	// PortStatus returns the link state and the VLANs of port "portName"
+	PortStatus(ctx context.Context, portName string) (*PortStatus, error)
+}
+
+// PortStatus is the state of a bridge port
+type PortStatus struct {
+	Name    string
+	AdminUp bool
+	OperUp  bool
+	// AccessVlan is the untagged VLAN of the port, 0 when it has none
+	AccessVlan int
+	// TaggedVlans are the VLANs the port is a tagged member of
+	TaggedVlans []int
+}
+
+type bridgePortNameKey struct{}
+
+// WithBridgePortName returns a copy of ctx carrying the name of the BridgePort a port is added for, which the
+// BridgeController can record with the port
+func WithBridgePortName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, bridgePortNameKey{}, name)
+}
+
+// BridgePortName returns the BridgePort name carried by ctx, or "" when the port is not added for a BridgePort
+func BridgePortName(ctx context.Context) string {
+	name, _ := ctx.Value(bridgePortNameKey{}).(string)
+	return name
 }
 
 type P4RTClient interface {
-	AddRules(macAddr []byte, vlan int)
-	DeleteRules(macAddr []byte, vlan int)
+	AddRules(ctx context.Context, spec FxpRuleSpec)
+	DeleteRules(ctx context.Context, spec FxpRuleSpec)
+}
+
+// FxpRuleSpec is the BridgePort the FXP rules are added or deleted for
+type FxpRuleSpec struct {
+	// MacAddr is the MAC address of the VF
+	MacAddr []byte
+	// Vsi is the VSI of the VF
+	Vsi int
+	// Vlan is the inner 802.1Q VLAN (C-tag) of the port
+	Vlan int
+	// Stag is the outer 802.1ad VLAN (S-tag) of the port
+	Stag int
+}
+
+// VxlanP4RTClient is implemented by the P4RTClients of the P4 packages with VXLAN tunnel tables
+type VxlanP4RTClient interface {
+	AddVxlanRules(ctx context.Context, spec VxlanRuleSpec)
+	DeleteVxlanRules(ctx context.Context, spec VxlanRuleSpec)
+}
+
+// VxlanRuleSpec is the logical bridge the VXLAN encap and decap FXP rules are added or deleted for
+type VxlanRuleSpec struct {
+	// Vni is the VXLAN network identifier of the logical bridge
+	Vni int
+	// Vlan is the 802.1Q VLAN of the logical bridge on the uplink
+	Vlan int
+	// VtepIp is the local VXLAN tunnel endpoint address
+	VtepIp net.IP
+}
+
+// QosP4RTClient is implemented by the P4RTClients of the P4 packages with meter tables
+type QosP4RTClient interface {
+	AddQosRules(ctx context.Context, spec QosRuleSpec) error
+	DeleteQosRules(ctx context.Context, spec QosRuleSpec) error
+}
+
+// QosRuleSpec is the BridgePort the FXP meter rules are added or deleted for
+type QosRuleSpec struct {
+	// Vsi is the VSI of the VF
+	Vsi int
+	// MaxTxRate is the rate in Mbps the VF transmits at most, 0 when not limited
+	MaxTxRate int
+	// MinTxRate is the rate in Mbps guaranteed to the VF, 0 when none is
+	MinTxRate int
+	// Priority is the 802.1p priority of the frames of the VF, 0-7
+	Priority int
 }
@@ -16,15 +16,20 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/metrics"
+	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/tracing"
 	"github.com/intel/ipu-opi-plugins/ipu-plugin/pkg/types"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -80,6 +85,14 @@ func GetMacIntValueFromBytes(macAddr []byte) uint64 {
 var p4rtCtlCommand = exec.Command
 
 func RunP4rtCtlCommand(p4RtBin string, params ...string) error {
+	return RunP4rtCtlCommandContext(context.Background(), p4RtBin, params...)
+}
+
+// RunP4rtCtlCommandContext runs p4rt-ctl with params, logged and traced as part of the request of ctx
+func RunP4rtCtlCommandContext(ctx context.Context, p4RtBin string, params ...string) error {
+	_, span := tracing.StartSpan(ctx, "p4rt-ctl")
+	span.SetAttributes(attribute.String("p4rt.params", strings.Join(params, " ")))
+	logger := tracing.Logger(ctx)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd := p4rtCtlCommand(p4RtBin, params...)
@@ -89,8 +102,11 @@ func RunP4rtCtlCommand(p4RtBin string, params ...string) error {
 	cmd.Env = append(cmd.Env, pbPythonEnvVar)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		log.WithFields(log.Fields{
+	err := cmd.Run()
+	metrics.ObserveP4rtCtl(params, err)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		logger.WithFields(log.Fields{
 			"params": params,
 			"err":    err,
 			"stdout": stdout.String(),
@@ -99,7 +115,7 @@ func RunP4rtCtlCommand(p4RtBin string, params ...string) error {
 		return err
 	}
 
-	log.WithField("params", params).Debugf("successfully executed %s", p4RtBin)
+	logger.WithField("params", params).Debugf("successfully executed %s", p4RtBin)
 	return nil
 }
 
@@ -128,8 +144,10 @@ func ImcQueryfindVsiGivenMacAddr(mode string, mac string) (string, error) {
 	runCommand := fmt.Sprintf(`ssh -o StrictHostKeyChecking=no -o ConnectTimeout=10 root@"%s" "/usr/bin/cli_client -cq" \
 		| awk '{if(($17 == "%s")) {print $8}}'`, ipAddr, mac)
 
+	start := time.Now()
 	output, err := ExecuteScript(runCommand)
 	output = strings.TrimSpace(string(output))
+	metrics.ObserveImcQuery("vsi_by_mac", start, err)
 
 	if err != nil || output == "" {
 		log.Errorf("unable to reach IMC %v or null output->%v", err, output)
@@ -140,8 +158,10 @@ func ImcQueryfindVsiGivenMacAddr(mode string, mac string) (string, error) {
 
 func GetVfMacList() ([]string, error) {
 	// reach out to the IMC to get the mac addresses of the VFs
+	start := time.Now()
 	output, err := ExecuteScript(`ssh -o StrictHostKeyChecking=no -o ConnectTimeout=10 root@192.168.0.1 "/usr/bin/cli_client -cq" \
 		| awk '{if(($4 == "0x0") && ($6 == "yes")) {print $17}}'`)
+	metrics.ObserveImcQuery("vf_mac_list", start, err)
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to reach the IMC %v", err)
@@ -19,11 +19,20 @@ import (
 	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/config"
 	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/logging"
 	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/sriov"
+	sriovtypes "github.com/k8snetworkplumbingwg/sriov-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/utils"
 	pb "github.com/opiproject/opi-api/network/evpn-gw/v1alpha1/gen/go"
 	"github.com/vishvananda/netlink"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// gRPC metadata of the CreateBridgePort request giving the rates and the priority of the bridge port
+	ipuMaxTxRateKey = "ipu-max-tx-rate"
+	ipuMinTxRateKey = "ipu-min-tx-rate"
+	ipuPriorityKey  = "ipu-priority"
 )
 
 type envArgs struct {
@@ -222,6 +231,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	ctx = withQosMetadata(ctx, netConf)
 
 	mac, err := net.ParseMAC(netConf.OrigVfState.EffectiveMAC)
 	if err != nil {
@@ -234,7 +244,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 			Spec: &pb.BridgePortSpec{
 				Ptype:          1,
 				MacAddress:     []byte(mac),
-				LogicalBridges: []string{fmt.Sprint(netConf.VFID + 2)},
+				LogicalBridges: getVlanLogicalBridges(netConf),
 			},
 		},
 	}
@@ -243,11 +253,37 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return fmt.Errorf("could not create bridge port: %v", err)
 	}
-	log.Printf("BridgePort Name: %s", r.GetName())
+	log.Printf("BridgePort Name: %s, logical bridges: %v", r.GetName(), r.GetSpec().GetLogicalBridges())
 
 	return types.PrintResult(result, netConf.CNIVersion)
 }
 
+// getVlanLogicalBridges returns the vlan of the bridge port, VFID+2, or none when the IPU plugin allocates it
+func getVlanLogicalBridges(netConf *sriovtypes.NetConf) []string {
+	if netConf.IpuAutoVlan {
+		return nil
+	}
+	return []string{fmt.Sprint(netConf.VFID + 2)}
+}
+
+// withQosMetadata adds the rates and the priority of the bridge port to the request metadata, the IPU plugin
+// applies them instead of the VF when ipu_qos is set
+func withQosMetadata(ctx context.Context, netConf *sriovtypes.NetConf) context.Context {
+	if !netConf.IpuQos {
+		return ctx
+	}
+	if netConf.MaxTxRate != nil && *netConf.MaxTxRate != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, ipuMaxTxRateKey, fmt.Sprint(*netConf.MaxTxRate))
+	}
+	if netConf.MinTxRate != nil && *netConf.MinTxRate != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, ipuMinTxRateKey, fmt.Sprint(*netConf.MinTxRate))
+	}
+	if netConf.VlanQoS != nil && *netConf.VlanQoS != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, ipuPriorityKey, fmt.Sprint(*netConf.VlanQoS))
+	}
+	return ctx
+}
+
 func cmdDel(args *skel.CmdArgs) error {
 	if err := config.SetLogging(args.StdinData, args.ContainerID, args.Netns, args.IfName); err != nil {
 		return err
@@ -347,8 +383,10 @@ func cmdDel(args *skel.CmdArgs) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// A DEL may be retried after the bridge port is gone, which is not an error
 	deleteRequest := &pb.DeleteBridgePortRequest{
-		Name: netConf.Master + fmt.Sprint(netConf.VFID),
+		Name:         netConf.Master + fmt.Sprint(netConf.VFID),
+		AllowMissing: true,
 	}
 
 	_, err = c.DeleteBridgePort(ctx, deleteRequest)
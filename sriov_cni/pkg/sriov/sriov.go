@@ -236,6 +236,7 @@ func (s *sriovManager) ApplyVFConfig(conf *sriovtypes.NetConf) error {
 	}
 
 	// 3. Set min/max tx link rate. 0 means no rate limiting. Support depends on NICs and driver.
+	// The rates are applied to the bridge port by the IPU plugin instead when ipu_qos is set.
 	var minTxRate, maxTxRate int
 	rateConfigured := false
 	if conf.MinTxRate != nil {
@@ -248,7 +249,7 @@ func (s *sriovManager) ApplyVFConfig(conf *sriovtypes.NetConf) error {
 		rateConfigured = true
 	}
 
-	if rateConfigured {
+	if rateConfigured && !conf.IpuQos {
 		if err = s.nLink.LinkSetVfRate(pfLink, conf.VFID, minTxRate, maxTxRate); err != nil {
 			return fmt.Errorf("failed to set vf %d min_tx_rate to %d Mbps: max_tx_rate to %d Mbps: %v",
 				conf.VFID, minTxRate, maxTxRate, err)
@@ -354,7 +355,7 @@ func (s *sriovManager) ResetVFConfig(conf *sriovtypes.NetConf) error {
 	}
 
 	// Restore rate limiting
-	if conf.MinTxRate != nil || conf.MaxTxRate != nil {
+	if (conf.MinTxRate != nil || conf.MaxTxRate != nil) && !conf.IpuQos {
 		if err = s.nLink.LinkSetVfRate(pfLink, conf.VFID, conf.OrigVfState.MinTxRate, conf.OrigVfState.MaxTxRate); err != nil {
 			return fmt.Errorf("failed to disable rate limiting for vf %d %v", conf.VFID, err)
 		}
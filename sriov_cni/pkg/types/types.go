@@ -61,6 +61,8 @@ type NetConf struct {
 		Mac string `json:"mac,omitempty"`
 	} `json:"runtimeConfig,omitempty"`
 	DpuDaemonAddress string `json:"ipu_manager_address,omitempty"`
+	IpuAutoVlan      bool   `json:"ipu_auto_vlan,omitempty"` // let the IPU plugin allocate the vlan of the bridge port
+	IpuQos           bool   `json:"ipu_qos,omitempty"`       // let the IPU plugin apply the rates and the priority to the bridge port
 	LogLevel         string `json:"logLevel,omitempty"`
 	LogFile          string `json:"logFile,omitempty"`
 }